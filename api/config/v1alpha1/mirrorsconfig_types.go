@@ -0,0 +1,190 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	cfg "sigs.k8s.io/controller-runtime/pkg/config/v1alpha1"
+)
+
+// ControllerSpec tunes a single controller
+type ControllerSpec struct {
+	// Maximum number of objects reconciled concurrently. Default: 1
+	// +optional
+	MaxConcurrentReconciles int `json:"maxConcurrentReconciles,omitempty"`
+}
+
+// ControllersSpec tunes the controllers of mirrors
+type ControllersSpec struct {
+	// +optional
+	SecretMirror ControllerSpec `json:"secretMirror,omitempty"`
+	// +optional
+	Namespace ControllerSpec `json:"namespace,omitempty"`
+}
+
+// KubeClientSpec tunes the Kubernetes API client
+type KubeClientSpec struct {
+	// Maximum queries per second to the Kubernetes API. Default: client-go default
+	// +optional
+	QPS int `json:"qps,omitempty"`
+
+	// Maximum burst of queries to the Kubernetes API. Default: client-go default
+	// +optional
+	Burst int `json:"burst,omitempty"`
+}
+
+// WriteRateLimitSpec limits how fast secrets are written to destinations
+type WriteRateLimitSpec struct {
+	// Maximum destination writes per second across all mirrors. Default: unlimited
+	// +optional
+	QPS int `json:"qps,omitempty"`
+
+	// Maximum burst of destination writes. Default: equals to QPS
+	// +optional
+	Burst int `json:"burst,omitempty"`
+}
+
+// PluginsSpec tells where to find source and destination plugins
+type PluginsSpec struct {
+	// Directory with plugin binaries launched by the controller, each available as plugin/<file name>
+	// +optional
+	Dir string `json:"dir,omitempty"`
+
+	// Unix sockets of sidecar plugins by name, each available as plugin/<name>
+	// +optional
+	Sockets map[string]string `json:"sockets,omitempty"`
+
+	// Maximum duration of a single call to a plugin. Default: 30 seconds
+	// +optional
+	CallTimeout *metav1.Duration `json:"callTimeout,omitempty"`
+}
+
+// MirrorsSpec contains mirrors-specific settings
+type MirrorsSpec struct {
+	// Size of a worker pool syncing secrets to destination namespaces. Default: 100
+	// +optional
+	WorkerPoolSize int `json:"workerPoolSize,omitempty"`
+
+	// Poll period of SecretMirrors which do not specify one. Default: 180 seconds
+	// +optional
+	DefaultPollPeriodSeconds int64 `json:"defaultPollPeriodSeconds,omitempty"`
+
+	// Upper bound of a retry delay after consecutive failed syncs. Default: 10 minutes
+	// +optional
+	MaxRetryBackoff *metav1.Duration `json:"maxRetryBackoff,omitempty"`
+
+	// +optional
+	Controllers ControllersSpec `json:"controllers,omitempty"`
+
+	// +optional
+	KubeClient KubeClientSpec `json:"kubeClient,omitempty"`
+
+	// +optional
+	WriteRateLimit WriteRateLimitSpec `json:"writeRateLimit,omitempty"`
+
+	// Cluster name available in destination Vault path templates as {{ .Cluster }}
+	// +optional
+	ClusterName string `json:"clusterName,omitempty"`
+
+	// Variables available in destination Vault path templates as {{ .Vars.<name> }}
+	// +optional
+	PathVariables map[string]string `json:"pathVariables,omitempty"`
+
+	// +optional
+	Plugins PluginsSpec `json:"plugins,omitempty"`
+
+	// Secret (<namespace>/<name>) with age identities under the age.agekey key, decrypting age sources
+	// which do not reference a Secret of their own
+	// +optional
+	AgeIdentitySecret string `json:"ageIdentitySecret,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// MirrorsConfig is the Schema for the mirrors controller configuration file
+type MirrorsConfig struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// ControllerManagerConfigurationSpec returns the configurations for controllers
+	cfg.ControllerManagerConfigurationSpec `json:",inline"`
+
+	// +optional
+	Mirrors MirrorsSpec `json:"mirrors,omitempty"`
+}
+
+func init() {
+	SchemeBuilder.Register(&MirrorsConfig{})
+}
+
+// Override replaces settings with the ones explicitly set (non-zero) in other
+func (s *MirrorsSpec) Override(other MirrorsSpec) {
+	if other.WorkerPoolSize > 0 {
+		s.WorkerPoolSize = other.WorkerPoolSize
+	}
+	if other.DefaultPollPeriodSeconds > 0 {
+		s.DefaultPollPeriodSeconds = other.DefaultPollPeriodSeconds
+	}
+	if other.MaxRetryBackoff != nil && other.MaxRetryBackoff.Duration > 0 {
+		s.MaxRetryBackoff = other.MaxRetryBackoff
+	}
+	if other.Controllers.SecretMirror.MaxConcurrentReconciles > 0 {
+		s.Controllers.SecretMirror.MaxConcurrentReconciles = other.Controllers.SecretMirror.MaxConcurrentReconciles
+	}
+	if other.Controllers.Namespace.MaxConcurrentReconciles > 0 {
+		s.Controllers.Namespace.MaxConcurrentReconciles = other.Controllers.Namespace.MaxConcurrentReconciles
+	}
+	if other.KubeClient.QPS > 0 {
+		s.KubeClient.QPS = other.KubeClient.QPS
+	}
+	if other.KubeClient.Burst > 0 {
+		s.KubeClient.Burst = other.KubeClient.Burst
+	}
+	if other.WriteRateLimit.QPS > 0 {
+		s.WriteRateLimit.QPS = other.WriteRateLimit.QPS
+	}
+	if other.WriteRateLimit.Burst > 0 {
+		s.WriteRateLimit.Burst = other.WriteRateLimit.Burst
+	}
+	if other.ClusterName != "" {
+		s.ClusterName = other.ClusterName
+	}
+	if len(other.PathVariables) > 0 {
+		if s.PathVariables == nil {
+			s.PathVariables = make(map[string]string, len(other.PathVariables))
+		}
+		for k, v := range other.PathVariables {
+			s.PathVariables[k] = v
+		}
+	}
+	if other.Plugins.Dir != "" {
+		s.Plugins.Dir = other.Plugins.Dir
+	}
+	if len(other.Plugins.Sockets) > 0 {
+		if s.Plugins.Sockets == nil {
+			s.Plugins.Sockets = make(map[string]string, len(other.Plugins.Sockets))
+		}
+		for k, v := range other.Plugins.Sockets {
+			s.Plugins.Sockets[k] = v
+		}
+	}
+	if other.Plugins.CallTimeout != nil && other.Plugins.CallTimeout.Duration > 0 {
+		s.Plugins.CallTimeout = other.Plugins.CallTimeout
+	}
+	if other.AgeIdentitySecret != "" {
+		s.AgeIdentitySecret = other.AgeIdentitySecret
+	}
+}
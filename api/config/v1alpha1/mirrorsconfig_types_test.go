@@ -0,0 +1,79 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMirrorsSpecOverride(t *testing.T) {
+	file := MirrorsSpec{
+		WorkerPoolSize:           50,
+		DefaultPollPeriodSeconds: 300,
+		MaxRetryBackoff:          &metav1.Duration{Duration: 5 * time.Minute},
+		Controllers: ControllersSpec{
+			SecretMirror: ControllerSpec{MaxConcurrentReconciles: 4},
+			Namespace:    ControllerSpec{MaxConcurrentReconciles: 2},
+		},
+		KubeClient:     KubeClientSpec{QPS: 20, Burst: 30},
+		WriteRateLimit: WriteRateLimitSpec{QPS: 50, Burst: 100},
+		ClusterName:    "prod",
+		PathVariables:  map[string]string{"region": "eu-west", "zone": "a"},
+		Plugins: PluginsSpec{
+			Dir:     "/plugins",
+			Sockets: map[string]string{"onepassword": "/run/op.sock"},
+		},
+		AgeIdentitySecret: "mirrors-system/age",
+	}
+	flags := MirrorsSpec{
+		DefaultPollPeriodSeconds: 60,
+		MaxRetryBackoff:          &metav1.Duration{},
+		Controllers: ControllersSpec{
+			Namespace: ControllerSpec{MaxConcurrentReconciles: 8},
+		},
+		KubeClient:    KubeClientSpec{Burst: 40},
+		ClusterName:   "staging",
+		PathVariables: map[string]string{"zone": "b"},
+		Plugins: PluginsSpec{
+			Sockets:     map[string]string{"doppler": "/run/doppler.sock"},
+			CallTimeout: &metav1.Duration{Duration: time.Minute},
+		},
+	}
+
+	expected := MirrorsSpec{
+		WorkerPoolSize:           50,
+		DefaultPollPeriodSeconds: 60,
+		MaxRetryBackoff:          &metav1.Duration{Duration: 5 * time.Minute},
+		Controllers: ControllersSpec{
+			SecretMirror: ControllerSpec{MaxConcurrentReconciles: 4},
+			Namespace:    ControllerSpec{MaxConcurrentReconciles: 8},
+		},
+		KubeClient:     KubeClientSpec{QPS: 20, Burst: 40},
+		WriteRateLimit: WriteRateLimitSpec{QPS: 50, Burst: 100},
+		ClusterName:    "staging",
+		PathVariables:  map[string]string{"region": "eu-west", "zone": "b"},
+		Plugins: PluginsSpec{
+			Dir:     "/plugins",
+			Sockets:     map[string]string{"onepassword": "/run/op.sock", "doppler": "/run/doppler.sock"},
+			CallTimeout: &metav1.Duration{Duration: time.Minute},
+		},
+		AgeIdentitySecret: "mirrors-system/age",
+	}
+
+	file.Override(flags)
+	if !reflect.DeepEqual(file, expected) {
+		t.Fatalf("unexpected settings after override:\n%+v\nexpected:\n%+v", file, expected)
+	}
+
+	// nothing set - nothing overridden
+	var empty MirrorsSpec
+	empty.Override(MirrorsSpec{})
+	if !reflect.DeepEqual(empty, MirrorsSpec{}) {
+		t.Fatalf("unexpected settings after an empty override: %+v", empty)
+	}
+	empty.Override(expected)
+	if !reflect.DeepEqual(empty, expected) {
+		t.Fatalf("unexpected settings after override of empty ones:\n%+v\nexpected:\n%+v", empty, expected)
+	}
+}
@@ -0,0 +1,173 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by controller-gen. DO NOT EDIT.
+
+package v1alpha1
+
+import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	runtime "k8s.io/apimachinery/pkg/runtime"
+)
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ControllerSpec) DeepCopyInto(out *ControllerSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ControllerSpec.
+func (in *ControllerSpec) DeepCopy() *ControllerSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ControllerSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ControllersSpec) DeepCopyInto(out *ControllersSpec) {
+	*out = *in
+	out.SecretMirror = in.SecretMirror
+	out.Namespace = in.Namespace
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ControllersSpec.
+func (in *ControllersSpec) DeepCopy() *ControllersSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ControllersSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KubeClientSpec) DeepCopyInto(out *KubeClientSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KubeClientSpec.
+func (in *KubeClientSpec) DeepCopy() *KubeClientSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(KubeClientSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MirrorsConfig) DeepCopyInto(out *MirrorsConfig) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ControllerManagerConfigurationSpec.DeepCopyInto(&out.ControllerManagerConfigurationSpec)
+	in.Mirrors.DeepCopyInto(&out.Mirrors)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MirrorsConfig.
+func (in *MirrorsConfig) DeepCopy() *MirrorsConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(MirrorsConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *MirrorsConfig) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MirrorsSpec) DeepCopyInto(out *MirrorsSpec) {
+	*out = *in
+	if in.MaxRetryBackoff != nil {
+		in, out := &in.MaxRetryBackoff, &out.MaxRetryBackoff
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	out.Controllers = in.Controllers
+	out.KubeClient = in.KubeClient
+	out.WriteRateLimit = in.WriteRateLimit
+	if in.PathVariables != nil {
+		in, out := &in.PathVariables, &out.PathVariables
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	in.Plugins.DeepCopyInto(&out.Plugins)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MirrorsSpec.
+func (in *MirrorsSpec) DeepCopy() *MirrorsSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(MirrorsSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PluginsSpec) DeepCopyInto(out *PluginsSpec) {
+	*out = *in
+	if in.Sockets != nil {
+		in, out := &in.Sockets, &out.Sockets
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.CallTimeout != nil {
+		in, out := &in.CallTimeout, &out.CallTimeout
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PluginsSpec.
+func (in *PluginsSpec) DeepCopy() *PluginsSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(PluginsSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WriteRateLimitSpec) DeepCopyInto(out *WriteRateLimitSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WriteRateLimitSpec.
+func (in *WriteRateLimitSpec) DeepCopy() *WriteRateLimitSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(WriteRateLimitSpec)
+	in.DeepCopyInto(out)
+	return out
+}
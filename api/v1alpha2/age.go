@@ -0,0 +1,52 @@
+package v1alpha2
+
+import (
+	"errors"
+	"k8s.io/api/core/v1"
+)
+
+// AgeSourceKind specifies a kind of an object holding age-encrypted values
+type AgeSourceKind string
+
+const (
+	AgeSourceKindSecret    AgeSourceKind = "Secret"
+	AgeSourceKindConfigMap AgeSourceKind = "ConfigMap"
+)
+
+// AgeSpec describes a Secret or a ConfigMap with age-encrypted values
+type AgeSpec struct {
+	// Kind of the source object - Secret or ConfigMap. Default: Secret
+	// +kubebuilder:validation:Enum=Secret;ConfigMap
+	// +optional
+	Kind AgeSourceKind `json:"kind,omitempty"`
+
+	// Reference to a Secret in the namespace of a SecretMirror containing age identities.
+	// Default: the controller-wide identity Secret (--age-identity-secret)
+	// +optional
+	IdentitySecretRef *v1.LocalObjectReference `json:"identitySecretRef,omitempty"`
+
+	// A key in the identity Secret which contains age identities (AGE-SECRET-KEY-1...). Default: age.agekey
+	// +optional
+	IdentityKey string `json:"identityKey,omitempty"`
+}
+
+func (s *AgeSpec) Default() {
+	if s.Kind == "" {
+		s.Kind = AgeSourceKindSecret
+	}
+	if s.IdentityKey == "" {
+		s.IdentityKey = "age.agekey"
+	}
+}
+
+func (s *AgeSpec) Validate() error {
+	switch s.Kind {
+	case "", AgeSourceKindSecret, AgeSourceKindConfigMap:
+	default:
+		return errors.New("age.kind must be one of the following: `Secret`, `ConfigMap`")
+	}
+	if s.IdentitySecretRef != nil && s.IdentitySecretRef.Name == "" {
+		return errors.New("age.identitySecretRef.name must be specified")
+	}
+	return nil
+}
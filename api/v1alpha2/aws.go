@@ -0,0 +1,118 @@
+package v1alpha2
+
+import (
+	"errors"
+	"k8s.io/api/core/v1"
+	"strings"
+)
+
+type AWSService string
+
+const (
+	AWSServiceSecretsManager AWSService = "secretsmanager"
+	AWSServiceParameterStore AWSService = "ssm"
+)
+
+// AWSAuthSpec describes how to authenticate against AWS
+type AWSAuthSpec struct {
+	// Reference to a Secret containing static access keys. When empty, IRSA web identity
+	// (AWS_ROLE_ARN and AWS_WEB_IDENTITY_TOKEN_FILE of the controller) is used
+	// +optional
+	SecretRef *v1.SecretReference `json:"secretRef,omitempty"`
+
+	// A key in the SecretRef which contains an access key id. Default: accessKeyId
+	// +optional
+	AccessKeyIDKey string `json:"accessKeyIdKey,omitempty"`
+
+	// A key in the SecretRef which contains a secret access key. Default: secretAccessKey
+	// +optional
+	SecretAccessKeyKey string `json:"secretAccessKeyKey,omitempty"`
+
+	// A key in the SecretRef which contains an optional session token. Default: sessionToken
+	// +optional
+	SessionTokenKey string `json:"sessionTokenKey,omitempty"`
+
+	// Role to assume with a web identity token instead of AWS_ROLE_ARN
+	// +optional
+	RoleARN string `json:"roleArn,omitempty"`
+}
+
+// AWSSpec contains information of a secret location in AWS Secrets Manager or SSM Parameter Store
+type AWSSpec struct {
+	// AWS service - secretsmanager or ssm. Default: secretsmanager
+	// +kubebuilder:validation:Enum=secretsmanager;ssm
+	// +optional
+	Service AWSService `json:"service,omitempty"`
+
+	// AWS region, e.g. eu-west-1
+	Region string `json:"region"`
+
+	// Name or ARN of a Secrets Manager secret. A JSON object SecretString is exploded into keys,
+	// any other value is stored under the `value` key
+	// +optional
+	SecretID string `json:"secretId,omitempty"`
+
+	// Name of an SSM parameter holding a JSON object, or a path ending with / whose every parameter
+	// is a key named after the parameter name relative to the path
+	// +optional
+	ParameterPath string `json:"parameterPath,omitempty"`
+
+	// Overrides the service endpoint, e.g. http://localstack:4566
+	// +optional
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// +optional
+	Auth AWSAuthSpec `json:"auth,omitempty"`
+}
+
+// IsParameterPrefix reports whether ParameterPath addresses every parameter under a path
+func (s *AWSSpec) IsParameterPrefix() bool {
+	return strings.HasSuffix(s.ParameterPath, "/")
+}
+
+func (s *AWSSpec) Default(namespace string) {
+	if s.Service == "" {
+		s.Service = AWSServiceSecretsManager
+	}
+	if s.Auth.SecretRef != nil {
+		if s.Auth.SecretRef.Namespace == "" {
+			s.Auth.SecretRef.Namespace = namespace
+		}
+		if s.Auth.AccessKeyIDKey == "" {
+			s.Auth.AccessKeyIDKey = "accessKeyId"
+		}
+		if s.Auth.SecretAccessKeyKey == "" {
+			s.Auth.SecretAccessKeyKey = "secretAccessKey"
+		}
+		if s.Auth.SessionTokenKey == "" {
+			s.Auth.SessionTokenKey = "sessionToken"
+		}
+	}
+}
+
+func (s *AWSSpec) Validate() error {
+	if s.Region == "" {
+		return errors.New("aws.region must be specified")
+	}
+
+	switch s.Service {
+	case "", AWSServiceSecretsManager:
+		if s.SecretID == "" {
+			return errors.New("aws.secretId must be specified with `secretsmanager` service")
+		}
+	case AWSServiceParameterStore:
+		if s.ParameterPath == "" {
+			return errors.New("aws.parameterPath must be specified with `ssm` service")
+		}
+		if s.IsParameterPrefix() && !strings.HasPrefix(s.ParameterPath, "/") {
+			return errors.New("aws.parameterPath of a parameter path must start with /")
+		}
+	default:
+		return errors.New("aws.service must be one of the following: `secretsmanager`, `ssm`")
+	}
+
+	if s.Auth.SecretRef != nil && s.Auth.SecretRef.Name == "" {
+		return errors.New("aws.auth.secretRef.name is required when using static credentials")
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package v1alpha2
+
+import (
+	"errors"
+	"k8s.io/api/core/v1"
+	"strings"
+)
+
+// ConsulAuthSpec describes how to authenticate against a Consul agent
+type ConsulAuthSpec struct {
+	// Reference to a Secret containing an ACL token. No token is sent when empty
+	// +optional
+	SecretRef *v1.SecretReference `json:"secretRef,omitempty"`
+
+	// A key in the SecretRef which contains token value. Default: token
+	// +optional
+	TokenKey string `json:"tokenKey,omitempty"`
+}
+
+// ConsulSpec contains information of a key prefix in Consul KV
+type ConsulSpec struct {
+	// Address of a Consul agent, e.g. http://consul.example.com:8500
+	Addr string `json:"addr"`
+
+	// Datacenter to read from or write to. Default: the datacenter of the agent
+	// +optional
+	Datacenter string `json:"datacenter,omitempty"`
+
+	// Key prefix. Every secret key maps to a Consul key <prefix>/<key>, nested keys are joined with .
+	Prefix string `json:"prefix"`
+
+	// +optional
+	Auth ConsulAuthSpec `json:"auth,omitempty"`
+}
+
+func (s *ConsulSpec) Default(namespace string) {
+	if s.Auth.SecretRef != nil {
+		if s.Auth.SecretRef.Namespace == "" {
+			s.Auth.SecretRef.Namespace = namespace
+		}
+		if s.Auth.TokenKey == "" {
+			s.Auth.TokenKey = "token"
+		}
+	}
+}
+
+func (s *ConsulSpec) Validate() error {
+	if s.Addr == "" {
+		return errors.New("consul.addr must be specified")
+	}
+	if strings.Trim(s.Prefix, "/") == "" {
+		return errors.New("consul.prefix must be specified")
+	}
+	if s.Auth.SecretRef != nil && s.Auth.SecretRef.Name == "" {
+		return errors.New("consul.auth.secretRef.name is required when using a token")
+	}
+	return nil
+}
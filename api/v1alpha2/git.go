@@ -0,0 +1,185 @@
+package v1alpha2
+
+import (
+	"errors"
+	"k8s.io/api/core/v1"
+	"path"
+	"strings"
+)
+
+// GitFileFormat specifies a format of a SOPS-encrypted file
+type GitFileFormat string
+
+const (
+	GitFileFormatYAML   GitFileFormat = "yaml"
+	GitFileFormatJSON   GitFileFormat = "json"
+	GitFileFormatDotenv GitFileFormat = "dotenv"
+)
+
+// GitAuthSpec describes how to authenticate against a git remote
+type GitAuthSpec struct {
+	// Reference to a Secret containing an SSH private key and known hosts, or a username and a password for HTTPS
+	// +optional
+	SecretRef *v1.SecretReference `json:"secretRef,omitempty"`
+
+	// A key in the SecretRef which contains an SSH private key. Default: identity
+	// +optional
+	SSHKeyKey string `json:"sshKeyKey,omitempty"`
+
+	// A key in the SecretRef which contains known_hosts entries of an SSH remote. Default: known_hosts
+	// +optional
+	KnownHostsKey string `json:"knownHostsKey,omitempty"`
+
+	// A key in the SecretRef which contains an HTTPS username. Default: username
+	// +optional
+	UsernameKey string `json:"usernameKey,omitempty"`
+
+	// A key in the SecretRef which contains an HTTPS password or token. Default: password
+	// +optional
+	PasswordKey string `json:"passwordKey,omitempty"`
+}
+
+// SOPSSpec describes keys decrypting and encrypting a SOPS file
+type SOPSSpec struct {
+	// Reference to a Secret containing age identities and/or an armored PGP private keyring
+	KeySecretRef v1.SecretReference `json:"keySecretRef"`
+
+	// A key in the KeySecretRef which contains age identities (AGE-SECRET-KEY-1...). Default: age.agekey
+	// +optional
+	AgeKeyKey string `json:"ageKeyKey,omitempty"`
+
+	// A key in the KeySecretRef which contains an armored PGP keyring. Default: sops.asc
+	// +optional
+	PGPKeyKey string `json:"pgpKeyKey,omitempty"`
+
+	// Additional age recipients (age1...) an exported file is encrypted for. A destination file is always
+	// encrypted for the identities and PGP keys of the KeySecretRef, so that it can be compared with a source
+	// +optional
+	AgeRecipients []string `json:"ageRecipients,omitempty"`
+}
+
+// GitSpec contains information of a SOPS-encrypted file in a git repository
+type GitSpec struct {
+	// Repository URL - file://, ssh://, user@host:path or https://
+	URL string `json:"url"`
+
+	// Branch, tag or commit to read from. A destination commits to a branch. Default: main
+	// +optional
+	Revision string `json:"revision,omitempty"`
+
+	// Path of a file in the repository. A directory with a file per secret (<name>.<format>)
+	// when a source yields many secrets
+	Path string `json:"path"`
+
+	// File format - yaml, json or dotenv. Default: guessed from the file extension, yaml otherwise
+	// +kubebuilder:validation:Enum=yaml;json;dotenv
+	// +optional
+	Format GitFileFormat `json:"format,omitempty"`
+
+	// +optional
+	Auth GitAuthSpec `json:"auth,omitempty"`
+
+	SOPS SOPSSpec `json:"sops"`
+
+	// Name and email of the author of commits made by a destination. Default: SecretMirror <mirrors@kts.studio>
+	// +optional
+	AuthorName string `json:"authorName,omitempty"`
+
+	// +optional
+	AuthorEmail string `json:"authorEmail,omitempty"`
+}
+
+func (s *GitSpec) Default(namespace string) {
+	if s.Revision == "" {
+		s.Revision = "main"
+	}
+	if s.Format == "" {
+		switch path.Ext(s.Path) {
+		case ".json":
+			s.Format = GitFileFormatJSON
+		case ".env":
+			s.Format = GitFileFormatDotenv
+		default:
+			s.Format = GitFileFormatYAML
+		}
+	}
+	if s.Auth.SecretRef != nil {
+		if s.Auth.SecretRef.Namespace == "" {
+			s.Auth.SecretRef.Namespace = namespace
+		}
+		if s.Auth.SSHKeyKey == "" {
+			s.Auth.SSHKeyKey = "identity"
+		}
+		if s.Auth.KnownHostsKey == "" {
+			s.Auth.KnownHostsKey = "known_hosts"
+		}
+		if s.Auth.UsernameKey == "" {
+			s.Auth.UsernameKey = "username"
+		}
+		if s.Auth.PasswordKey == "" {
+			s.Auth.PasswordKey = "password"
+		}
+	}
+	if s.SOPS.KeySecretRef.Namespace == "" {
+		s.SOPS.KeySecretRef.Namespace = namespace
+	}
+	if s.SOPS.AgeKeyKey == "" {
+		s.SOPS.AgeKeyKey = "age.agekey"
+	}
+	if s.SOPS.PGPKeyKey == "" {
+		s.SOPS.PGPKeyKey = "sops.asc"
+	}
+	if s.AuthorName == "" {
+		s.AuthorName = "SecretMirror"
+	}
+	if s.AuthorEmail == "" {
+		s.AuthorEmail = "mirrors@kts.studio"
+	}
+}
+
+// IsSSH reports whether URL addresses an SSH remote
+func (s *GitSpec) IsSSH() bool {
+	if strings.HasPrefix(s.URL, "ssh://") {
+		return true
+	}
+	// scp-like syntax: user@host:path
+	return !strings.Contains(s.URL, "://") && strings.Contains(s.URL, ":")
+}
+
+func (s *GitSpec) Validate() error {
+	if s.URL == "" {
+		return errors.New("git.url must be specified")
+	}
+	if !s.IsSSH() && !strings.HasPrefix(s.URL, "file://") &&
+		!strings.HasPrefix(s.URL, "https://") && !strings.HasPrefix(s.URL, "http://") {
+		return errors.New("git.url must be a file://, ssh://, user@host:path or https:// URL")
+	}
+	if s.Path == "" {
+		return errors.New("git.path must be specified")
+	}
+	for _, part := range strings.Split(s.Path, "/") {
+		if part == ".." {
+			return errors.New("git.path must not contain ..")
+		}
+	}
+	switch s.Format {
+	case "", GitFileFormatYAML, GitFileFormatJSON, GitFileFormatDotenv:
+	default:
+		return errors.New("git.format must be one of the following: `yaml`, `json`, `dotenv`")
+	}
+	if s.Auth.SecretRef != nil && s.Auth.SecretRef.Name == "" {
+		return errors.New("git.auth.secretRef.name is required when using credentials")
+	}
+	if s.IsSSH() && s.Auth.SecretRef == nil {
+		return errors.New("git.auth.secretRef is required with an SSH url")
+	}
+	if s.SOPS.KeySecretRef.Name == "" {
+		return errors.New("git.sops.keySecretRef.name must be specified")
+	}
+	for _, recipient := range s.SOPS.AgeRecipients {
+		if !strings.HasPrefix(recipient, "age1") {
+			return errors.New("git.sops.ageRecipients must be age X25519 recipients (age1...)")
+		}
+	}
+	return nil
+}
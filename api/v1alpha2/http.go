@@ -0,0 +1,188 @@
+package v1alpha2
+
+import (
+	"errors"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"net/url"
+	"strings"
+)
+
+// HTTPAuthSpec describes how to authenticate against an HTTP endpoint
+type HTTPAuthSpec struct {
+	// Reference to a Secret containing a bearer token, a username and a password, and/or a client certificate.
+	// A token takes precedence over a username and a password
+	// +optional
+	SecretRef *v1.SecretReference `json:"secretRef,omitempty"`
+
+	// A key in the SecretRef which contains a bearer token. Default: token
+	// +optional
+	TokenKey string `json:"tokenKey,omitempty"`
+
+	// A key in the SecretRef which contains a basic auth username. Default: username
+	// +optional
+	UsernameKey string `json:"usernameKey,omitempty"`
+
+	// A key in the SecretRef which contains a basic auth password. Default: password
+	// +optional
+	PasswordKey string `json:"passwordKey,omitempty"`
+
+	// A key in the SecretRef which contains a PEM client certificate for mTLS. Default: tls.crt
+	// +optional
+	CertKey string `json:"certKey,omitempty"`
+
+	// A key in the SecretRef which contains a PEM private key of the client certificate. Default: tls.key
+	// +optional
+	KeyKey string `json:"keyKey,omitempty"`
+
+	// A key in the SecretRef which contains PEM CA certificates of the endpoint. Default: ca.crt
+	// +optional
+	CAKey string `json:"caKey,omitempty"`
+}
+
+// HTTPSignatureSpec describes an HMAC-SHA256 signature of a request body
+type HTTPSignatureSpec struct {
+	// Reference to a Secret containing an HMAC key
+	SecretRef v1.SecretReference `json:"secretRef"`
+
+	// A key in the SecretRef which contains the HMAC key. Default: hmac-key
+	// +optional
+	Key string `json:"key,omitempty"`
+
+	// Header carrying the signature sha256=<hex>. Default: X-Mirrors-Signature
+	// +optional
+	Header string `json:"header,omitempty"`
+}
+
+// HTTPSpec contains information of an HTTP endpoint receiving secrets
+type HTTPSpec struct {
+	// Endpoint URL, http:// or https://
+	URL string `json:"url"`
+
+	// Request method - POST or PUT. Default: POST
+	// +kubebuilder:validation:Enum=POST;PUT
+	// +optional
+	Method string `json:"method,omitempty"`
+
+	// Go template of a request body with .Namespace and .Name of a mirror, .SourceName and .Data
+	// (a map of string values) of a source secret, and json and b64enc functions.
+	// Default: a JSON object of keys and values
+	// +optional
+	Template string `json:"template,omitempty"`
+
+	// Content-Type of a request body. Default: application/json
+	// +optional
+	ContentType string `json:"contentType,omitempty"`
+
+	// Additional request headers
+	// +optional
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// +optional
+	Auth HTTPAuthSpec `json:"auth,omitempty"`
+
+	// Signs request bodies, so that a receiver can verify their origin
+	// +optional
+	Signature *HTTPSignatureSpec `json:"signature,omitempty"`
+
+	// How many times a failed request (a network error, 429 or 5xx) is retried before the next sync,
+	// with exponential backoff starting at a second. Default: 3
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxRetries *int32 `json:"maxRetries,omitempty"`
+
+	// Timeout of a single request. Default: 10 seconds
+	// +optional
+	TimeoutSeconds int64 `json:"timeoutSeconds,omitempty"`
+}
+
+// HTTPDestinationStatusSpec describes requests sent by an http destination
+type HTTPDestinationStatusSpec struct {
+	// Status code of the last response
+	// +optional
+	LastStatusCode int `json:"lastStatusCode,omitempty"`
+
+	// Time of the last response
+	// +optional
+	LastResponseTime *metav1.Time `json:"lastResponseTime,omitempty"`
+
+	// Hashes of requests delivered to the endpoint by source secret name. A secret is sent again
+	// only when its data (or the url, the method or the template) changes
+	// +optional
+	ContentHashes map[string]string `json:"contentHashes,omitempty"`
+}
+
+func (s *HTTPSpec) Default(namespace string) {
+	if s.Method == "" {
+		s.Method = "POST"
+	}
+	if s.ContentType == "" {
+		s.ContentType = "application/json"
+	}
+	if s.MaxRetries == nil {
+		maxRetries := int32(3)
+		s.MaxRetries = &maxRetries
+	}
+	if s.TimeoutSeconds == 0 {
+		s.TimeoutSeconds = 10
+	}
+	if s.Auth.SecretRef != nil {
+		if s.Auth.SecretRef.Namespace == "" {
+			s.Auth.SecretRef.Namespace = namespace
+		}
+		if s.Auth.TokenKey == "" {
+			s.Auth.TokenKey = "token"
+		}
+		if s.Auth.UsernameKey == "" {
+			s.Auth.UsernameKey = "username"
+		}
+		if s.Auth.PasswordKey == "" {
+			s.Auth.PasswordKey = "password"
+		}
+		if s.Auth.CertKey == "" {
+			s.Auth.CertKey = "tls.crt"
+		}
+		if s.Auth.KeyKey == "" {
+			s.Auth.KeyKey = "tls.key"
+		}
+		if s.Auth.CAKey == "" {
+			s.Auth.CAKey = "ca.crt"
+		}
+	}
+	if s.Signature != nil {
+		if s.Signature.SecretRef.Namespace == "" {
+			s.Signature.SecretRef.Namespace = namespace
+		}
+		if s.Signature.Key == "" {
+			s.Signature.Key = "hmac-key"
+		}
+		if s.Signature.Header == "" {
+			s.Signature.Header = "X-Mirrors-Signature"
+		}
+	}
+}
+
+func (s *HTTPSpec) Validate() error {
+	u, err := url.Parse(s.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("http.url must be an http:// or https:// URL")
+	}
+	switch strings.ToUpper(s.Method) {
+	case "", "POST", "PUT":
+	default:
+		return errors.New("http.method must be one of the following: `POST`, `PUT`")
+	}
+	if s.MaxRetries != nil && *s.MaxRetries < 0 {
+		return errors.New("http.maxRetries must not be negative")
+	}
+	if s.TimeoutSeconds < 0 {
+		return errors.New("http.timeoutSeconds must not be negative")
+	}
+	if s.Auth.SecretRef != nil && s.Auth.SecretRef.Name == "" {
+		return errors.New("http.auth.secretRef.name is required when using credentials")
+	}
+	if s.Signature != nil && s.Signature.SecretRef.Name == "" {
+		return errors.New("http.signature.secretRef.name must be specified")
+	}
+	return nil
+}
@@ -18,6 +18,7 @@ package v1alpha2
 
 import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"time"
 )
 
@@ -28,11 +29,39 @@ const (
 	DeletePolicyRetain                  = "retain"
 )
 
+type DriftPolicyType string
+
+const (
+	DriftPolicyRevert DriftPolicyType = "revert"
+	DriftPolicyReport                 = "report"
+	DriftPolicyIgnore                 = "ignore"
+)
+
+type ConflictPolicyType string
+
+const (
+	ConflictPolicySkip  ConflictPolicyType = "skip"
+	ConflictPolicyAdopt                    = "adopt"
+	ConflictPolicyFail                     = "fail"
+)
+
+type WorkloadKind string
+
+const (
+	WorkloadKindDeployment  WorkloadKind = "Deployment"
+	WorkloadKindStatefulSet              = "StatefulSet"
+	WorkloadKindDaemonSet                = "DaemonSet"
+)
+
 type SourceType string
 
 const (
 	SourceTypeSecret SourceType = "secret"
 	SourceTypeVault             = "vault"
+	SourceTypeAWS               = "aws"
+	SourceTypeConsul            = "consul"
+	SourceTypeGit               = "git"
+	SourceTypeAge               = "age"
 )
 
 type DestType string
@@ -40,33 +69,102 @@ type DestType string
 const (
 	DestTypeNamespaces DestType = "namespaces"
 	DestTypeVault               = "vault"
+	DestTypeAWS                 = "aws"
+	DestTypeConsul              = "consul"
+	DestTypeGit                 = "git"
+	DestTypeHTTP                = "http"
 )
 
+// RolloutWorkloadSpec references a workload in a destination namespace
+type RolloutWorkloadSpec struct {
+	// Workload kind - Deployment, StatefulSet or DaemonSet
+	// +kubebuilder:validation:Enum=Deployment;StatefulSet;DaemonSet
+	Kind WorkloadKind `json:"kind"`
+
+	// Workload name
+	Name string `json:"name"`
+}
+
+// RolloutSpec defines which workloads to restart when a mirrored secret changes
+type RolloutSpec struct {
+	// Workloads restarted in addition to the ones referencing the secret via env, envFrom or volumes
+	// +optional
+	Workloads []RolloutWorkloadSpec `json:"workloads,omitempty"`
+}
+
 // SecretMirrorSource defines where to extract a secret data from
 type SecretMirrorSource struct {
+	// Source type — secret, vault, aws, consul, git, age or a type registered in pkg/backend. Default: secret
 	// +kubebuilder:default:=secret
-	// +kubebuilder:validation:Enum=secret;vault
 	Type SourceType `json:"type,omitempty"`
 
+	// Name of a source secret and its copies. Not used when a source yields many secrets (selector or vault.prefix)
 	// +kubebuilder:validation:Required
 	Name string `json:"name,omitempty"`
+
+	// Selects many secrets in the namespace of a SecretMirror by labels, each mirrored under its own name.
+	// Only applies to secret and age sources, name is ignored when set
+	// +optional
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+
 	// +optional
 	Vault *VaultSpec `json:"vault,omitempty"`
+
+	// +optional
+	AWS *AWSSpec `json:"aws,omitempty"`
+
+	// +optional
+	Consul *ConsulSpec `json:"consul,omitempty"`
+
+	// +optional
+	Git *GitSpec `json:"git,omitempty"`
+
+	// +optional
+	Age *AgeSpec `json:"age,omitempty"`
+
+	// Opaque configuration passed to a plugin source (type plugin/<name>)
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +optional
+	Config *runtime.RawExtension `json:"config,omitempty"`
 }
 
 //SecretMirrorDestination defines where to sync a secret data to
 type SecretMirrorDestination struct {
-	// Destination type. Possible values — namespaces, vault. Default: namespaces
+	// Destination type — namespaces, vault, aws, consul, git, http or a type registered in pkg/backend. Default: namespaces
 	// +kubebuilder:default:=namespaces
-	// +kubebuilder:validation:Enum=namespaces;vault
 	Type DestType `json:"type,omitempty"`
 
 	// An array of regular expressions to match namespaces where to copy a source secret
 	// +optional
 	Namespaces []string `json:"namespaces,omitempty"`
 
+	// What to do when a destination secret already exists but is not managed by this SecretMirror.
+	// Three policies exist – skip (leaves the secret untouched and reports a conflict), adopt (takes
+	// over the secret unless it is managed by another SecretMirror) and fail (puts the mirror into Error).
+	// Default: skip
+	// +kubebuilder:validation:Enum=skip;adopt;fail
+	// +optional
+	ConflictPolicy ConflictPolicyType `json:"conflictPolicy,omitempty"`
+
 	// +optional
 	Vault *VaultSpec `json:"vault,omitempty"`
+
+	// +optional
+	AWS *AWSSpec `json:"aws,omitempty"`
+
+	// +optional
+	Consul *ConsulSpec `json:"consul,omitempty"`
+
+	// +optional
+	Git *GitSpec `json:"git,omitempty"`
+
+	// +optional
+	HTTP *HTTPSpec `json:"http,omitempty"`
+
+	// Opaque configuration passed to a plugin destination (type plugin/<name>)
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +optional
+	Config *runtime.RawExtension `json:"config,omitempty"`
 }
 
 type MirrorStatus string
@@ -75,8 +173,33 @@ const (
 	MirrorStatusPending MirrorStatus = "Pending"
 	MirrorStatusActive               = "Active"
 	MirrorStatusError                = "Error"
+	MirrorStatusDryRun               = "DryRun"
+	MirrorStatusSuspended            = "Suspended"
 )
 
+type PlanAction string
+
+const (
+	PlanActionCreate   PlanAction = "create"
+	PlanActionUpdate              = "update"
+	PlanActionNoop                = "noop"
+	PlanActionConflict            = "conflict"
+)
+
+// DestinationPlan describes what a SecretMirror would do to a single destination
+type DestinationPlan struct {
+	// Destination namespace or Vault path
+	Destination string `json:"destination"`
+
+	// What would happen to the destination - create, update, noop or conflict
+	// +kubebuilder:validation:Enum=create;update;noop;conflict
+	Action PlanAction `json:"action"`
+
+	// Names of the keys which would be added, changed or removed
+	// +optional
+	ChangedKeys []string `json:"changedKeys,omitempty"`
+}
+
 // SecretMirrorSpec defines the desired behaviour of Secret mirroring
 type SecretMirrorSpec struct {
 	// Important: Run "make" to regenerate code after modifying this file
@@ -90,8 +213,27 @@ type SecretMirrorSpec struct {
 	// +kubebuilder:validation:Enum=delete;retain
 	DeletePolicy DeletePolicyType `json:"deletePolicy,omitempty"`
 
-	// How often to check for secret changes. Default: 180 seconds
+	// What to do when a mirrored secret has been modified outside of a SecretMirror. Three policies exist –
+	// revert (overwrites the copy with the source data), report (emits an event but leaves the copy as is)
+	// and ignore (does nothing). Default: revert
+	// +kubebuilder:validation:Enum=revert;report;ignore
+	DriftPolicy DriftPolicyType `json:"driftPolicy,omitempty"`
+
+	// How often to check for secret changes. Default: 180 seconds (can be changed in the controller configuration)
 	PollPeriodSeconds int64 `json:"pollPeriodSeconds,omitempty"`
+
+	// If set, nothing is written to destinations. Instead a plan of changes is published in status
+	// +optional
+	DryRun bool `json:"dryRun,omitempty"`
+
+	// If set, a SecretMirror stops reading its source and writing to destinations until it is unset
+	// +optional
+	Suspend bool `json:"suspend,omitempty"`
+
+	// If set, Deployments, StatefulSets and DaemonSets in destination namespaces are restarted
+	// when the data of a mirrored secret changes. Only applies to namespaces destination
+	// +optional
+	Rollout *RolloutSpec `json:"rollout,omitempty"`
 }
 
 // VaultSourceStatusSpec describes Vault-specific status
@@ -101,6 +243,43 @@ type VaultSourceStatusSpec struct {
 
 	// Contains lease duration of a Vault dynamic secret
 	LeaseDuration int `json:"leaseDuration,omitempty"`
+
+	// Time when the lease expires
+	// +optional
+	LeaseExpiresAt *metav1.Time `json:"leaseExpiresAt,omitempty"`
+
+	// Time when the lease is going to be renewed or new credentials (e.g. a certificate) fetched
+	// +optional
+	LeaseRenewAt *metav1.Time `json:"leaseRenewAt,omitempty"`
+
+	// Lease can no longer be extended because of its max TTL, new credentials will be fetched at LeaseRenewAt
+	// +optional
+	MaxTTLReached bool `json:"maxTTLReached,omitempty"`
+
+	// Error of the last failed renewal of the lease, which is retried at LeaseRenewAt. Cleared once the lease is renewed
+	// +optional
+	LeaseRenewError string `json:"leaseRenewError,omitempty"`
+
+	// Serial number of a certificate issued by a Vault PKI source
+	// +optional
+	CertificateSerial string `json:"certificateSerial,omitempty"`
+
+	// Expiration time of a certificate issued by a Vault PKI source
+	// +optional
+	CertificateNotAfter *metav1.Time `json:"certificateNotAfter,omitempty"`
+}
+
+// SupersededLeaseSpec describes a Vault lease replaced by new credentials and waiting to be revoked
+type SupersededLeaseSpec struct {
+	LeaseID string `json:"leaseID"`
+
+	// Time when the lease expires by itself
+	// +optional
+	LeaseExpiresAt *metav1.Time `json:"leaseExpiresAt,omitempty"`
+
+	// Time when the lease is going to be revoked. Unset until the new credentials have been synced
+	// +optional
+	RevokeAt *metav1.Time `json:"revokeAt,omitempty"`
 }
 
 // SecretMirrorStatus defines the observed state of SecretMirror
@@ -108,14 +287,46 @@ type SecretMirrorStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 
-	// Mirroring status - Active, Pending or Error
+	// Mirroring status - Active, Pending, Error, DryRun or Suspended
 	// +kubebuilder:default:=Pending
-	// +kubebuilder:validation:Enum=Pending;Active;Error
+	// +kubebuilder:validation:Enum=Pending;Active;Error;DryRun;Suspended
 	MirrorStatus MirrorStatus `json:"mirrorStatus,omitempty"`
 
 	// Timestamp of last successful mirrorring
 	LastSyncTime metav1.Time            `json:"lastSyncTime,omitempty"`
 	VaultSource  *VaultSourceStatusSpec `json:"vaultSource,omitempty"`
+
+	// Names of the secrets mirrored by a SecretMirror with many source secrets
+	// +optional
+	MirroredSecrets []string `json:"mirroredSecrets,omitempty"`
+
+	// Vault leases superseded by new credentials which are yet to be revoked
+	// +optional
+	SupersededLeases []SupersededLeaseSpec `json:"supersededLeases,omitempty"`
+
+	// Destination namespaces where a secret exists but is not managed by this SecretMirror
+	// +optional
+	Conflicts []string `json:"conflicts,omitempty"`
+
+	// Number of consecutive failed syncs
+	// +optional
+	ConsecutiveFailures int32 `json:"consecutiveFailures,omitempty"`
+
+	// Time of the next retry after a failed sync
+	// +optional
+	NextRetryTime *metav1.Time `json:"nextRetryTime,omitempty"`
+
+	// Last value of the mirrors.kts.studio/sync-requested-at annotation which has been synced successfully
+	// +optional
+	LastHandledSyncRequest string `json:"lastHandledSyncRequest,omitempty"`
+
+	// Responses of an http destination
+	// +optional
+	HTTPDestination *HTTPDestinationStatusSpec `json:"httpDestination,omitempty"`
+
+	// Planned changes of a SecretMirror running in dry run mode
+	// +optional
+	Plan []DestinationPlan `json:"plan,omitempty"`
 }
 
 //+kubebuilder:object:root=true
@@ -139,10 +350,41 @@ type SecretMirror struct {
 	Status SecretMirrorStatus `json:"status,omitempty"`
 }
 
+// PollPeriodDuration returns a poll period, DefaultPollPeriodSeconds if it is not set
 func (r *SecretMirror) PollPeriodDuration() time.Duration {
+	if r.Spec.PollPeriodSeconds <= 0 {
+		return time.Duration(DefaultPollPeriodSeconds) * time.Second
+	}
 	return time.Duration(r.Spec.PollPeriodSeconds) * time.Second
 }
 
+// IsMultiSource reports whether a SecretMirror mirrors many source secrets, each under its own name
+func (r *SecretMirror) IsMultiSource() bool {
+	switch r.Spec.Source.Type {
+	case SourceTypeSecret, SourceTypeAge:
+		return r.Spec.Source.Selector != nil
+	case SourceTypeVault:
+		return r.Spec.Source.Vault != nil && r.Spec.Source.Vault.Prefix != ""
+	}
+	return false
+}
+
+// NextSyncAt returns when a SecretMirror synced at lastSync needs to be synced again:
+// after a poll period, at a Vault lease renewal or revocation, whichever comes first
+func (r *SecretMirror) NextSyncAt(lastSync time.Time) time.Time {
+	next := lastSync.Add(r.PollPeriodDuration())
+	if r.Status.VaultSource != nil && r.Status.VaultSource.LeaseRenewAt != nil &&
+		r.Status.VaultSource.LeaseRenewAt.Time.Before(next) {
+		next = r.Status.VaultSource.LeaseRenewAt.Time
+	}
+	for _, lease := range r.Status.SupersededLeases {
+		if lease.RevokeAt != nil && lease.RevokeAt.Time.Before(next) {
+			next = lease.RevokeAt.Time
+		}
+	}
+	return next
+}
+
 //+kubebuilder:object:root=true
 
 // SecretMirrorList contains a list of SecretMirror
@@ -20,7 +20,6 @@ import (
 	"errors"
 	"fmt"
 	"k8s.io/apimachinery/pkg/runtime"
-	"regexp"
 	ctrl "sigs.k8s.io/controller-runtime"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
@@ -29,7 +28,27 @@ import (
 // log is for logging in this package.
 var secretmirrorlog = logf.Log.WithName("secretmirror-resource")
 
+// DefaultPollPeriodSeconds is a poll period of SecretMirrors which do not specify one, unless the controller
+// is configured with another default
+const DefaultPollPeriodSeconds int64 = 3 * 60 // 3 minutes
+
+// BackendValidator defaults and validates the parts of a spec specific to source and destination types
+// +kubebuilder:object:generate=false
+type BackendValidator interface {
+	Default(r *SecretMirror)
+	Validate(r *SecretMirror) error
+}
+
+// Backends knows the registered source and destination types. It is set by pkg/backend,
+// which cannot be imported from here. SecretMirrors are rejected while it is not set
+var Backends BackendValidator
+
+var errNoBackends = errors.New("source and destination types are not registered")
+
 func (r *SecretMirror) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	if Backends == nil {
+		return errNoBackends
+	}
 	return ctrl.NewWebhookManagedBy(mgr).
 		For(r).
 		Complete()
@@ -42,19 +61,12 @@ func (r *SecretMirror) SetupWebhookWithManager(mgr ctrl.Manager) error {
 var _ webhook.Defaulter = &SecretMirror{}
 
 // Default implements webhook.Defaulter so a webhook will be registered for the type
+// A poll period is left unset, so that the default of the controller applies
 func (r *SecretMirror) Default() {
-	if r.Spec.PollPeriodSeconds == 0 {
-		r.Spec.PollPeriodSeconds = 3 * 60 // 3 minutes
-	}
-
 	if r.Spec.Source.Type == "" {
 		r.Spec.Source.Type = SourceTypeSecret
 	}
 
-	if r.Spec.Source.Type == SourceTypeVault {
-		r.Spec.Source.Vault.Default(r.Namespace)
-	}
-
 	if r.Spec.Source.Name == "" {
 		r.Spec.Source.Name = r.Name
 	}
@@ -67,9 +79,12 @@ func (r *SecretMirror) Default() {
 		r.Spec.DeletePolicy = DeletePolicyDelete
 	}
 
-	if r.Spec.Destination.Type == DestTypeVault {
-		r.Spec.Destination.Vault.Default(r.Namespace)
-		r.Spec.DeletePolicy = DeletePolicyRetain
+	if r.Spec.DriftPolicy == "" {
+		r.Spec.DriftPolicy = DriftPolicyRevert
+	}
+
+	if Backends != nil {
+		Backends.Default(r)
 	}
 }
 
@@ -83,32 +98,37 @@ func (r *SecretMirror) ValidateCreate() error {
 	secretmirrorlog.Info("validate create", "name", r.Name)
 
 	if r.Spec.Destination.Type == "" {
-		return errors.New("destination type must be one of the following: `namespaces`, `vault")
+		return errors.New("destination type is required")
 	}
 
 	if r.Spec.Source.Name == "" {
 		return errors.New("source name is required")
 	}
 
-	if r.Spec.Destination.Type == DestTypeNamespaces {
-		if len(r.Spec.Destination.Namespaces) == 0 {
-			return errors.New("destination namespaces are empty")
-		} else {
-			for i, nsRegex := range r.Spec.Destination.Namespaces {
-				if nsRegex == "" {
-					return fmt.Errorf("destination namespace #%d is empty", i)
-				}
-				_, err := regexp.Compile(nsRegex)
-				if err != nil {
-					return fmt.Errorf("destination namespace #%d has a problem compiling: %s", i, err)
-				}
-			}
-		}
+	if r.Spec.Source.Selector != nil && r.Spec.Source.Type != SourceTypeSecret && r.Spec.Source.Type != SourceTypeAge {
+		return errors.New("source.selector is only supported with `secret` and `age` sources")
+	}
+
+	if Backends == nil {
+		return errNoBackends
+	}
+	if err := Backends.Validate(r); err != nil {
+		return err
 	}
 
-	if r.Spec.Destination.Type == DestTypeVault {
-		if err := r.Spec.Destination.Vault.Validate(); err != nil {
-			return err
+	if r.Spec.Rollout != nil {
+		if r.Spec.Destination.Type != DestTypeNamespaces {
+			return errors.New("rollout is only supported with `namespaces` destination")
+		}
+		for i, workload := range r.Spec.Rollout.Workloads {
+			switch workload.Kind {
+			case WorkloadKindDeployment, WorkloadKindStatefulSet, WorkloadKindDaemonSet:
+			default:
+				return fmt.Errorf("rollout.workloads #%d kind must be one of the following: `Deployment`, `StatefulSet`, `DaemonSet`", i)
+			}
+			if workload.Name == "" {
+				return fmt.Errorf("rollout.workloads #%d name is empty", i)
+			}
 		}
 	}
 
@@ -116,6 +136,10 @@ func (r *SecretMirror) ValidateCreate() error {
 		return errors.New("deletePolicy must be one of the following: `delete`, `retain`")
 	}
 
+	if r.Spec.DriftPolicy != "" && r.Spec.DriftPolicy != DriftPolicyRevert && r.Spec.DriftPolicy != DriftPolicyReport && r.Spec.DriftPolicy != DriftPolicyIgnore {
+		return errors.New("driftPolicy must be one of the following: `revert`, `report`, `ignore`")
+	}
+
 	return nil
 }
 
@@ -0,0 +1,37 @@
+package v1alpha2
+
+import (
+	"errors"
+	"testing"
+)
+
+type rejectBackends struct{}
+
+func (rejectBackends) Default(r *SecretMirror)        {}
+func (rejectBackends) Validate(r *SecretMirror) error { return errors.New("unknown source type") }
+
+func TestValidateBackends(t *testing.T) {
+	defer func(backends BackendValidator) { Backends = backends }(Backends)
+
+	mirror := &SecretMirror{}
+	mirror.Name = "db"
+	mirror.Default()
+
+	Backends = nil
+	if err := mirror.ValidateCreate(); !errors.Is(err, errNoBackends) {
+		t.Fatalf("expected a SecretMirror to be rejected without registered backends, got %v", err)
+	}
+	if err := mirror.SetupWebhookWithManager(nil); !errors.Is(err, errNoBackends) {
+		t.Fatalf("expected a webhook setup to fail without registered backends, got %v", err)
+	}
+
+	Backends = rejectBackends{}
+	if err := mirror.ValidateCreate(); err == nil || err.Error() != "unknown source type" {
+		t.Fatalf("expected a backend validation error, got %v", err)
+	}
+
+	Backends = allowBackends{}
+	if err := mirror.ValidateCreate(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -3,6 +3,8 @@ package v1alpha2
 import (
 	"errors"
 	"k8s.io/api/core/v1"
+	"regexp"
+	"time"
 )
 
 type VaultAuthType string
@@ -55,17 +57,176 @@ func (s *VaultAuthSpec) Type() VaultAuthType {
 	return VaultAuthTypeToken
 }
 
+// VaultEncoding specifies how secret values are stored in Vault
+type VaultEncoding string
+
+const (
+	VaultEncodingAuto   VaultEncoding = "auto"
+	VaultEncodingRaw    VaultEncoding = "raw"
+	VaultEncodingBase64 VaultEncoding = "base64"
+)
+
+// VaultStructuredValues specifies how non-string values of Vault secrets are handled
+type VaultStructuredValues string
+
+const (
+	VaultStructuredValuesError   VaultStructuredValues = "error"
+	VaultStructuredValuesJSON    VaultStructuredValues = "json"
+	VaultStructuredValuesFlatten VaultStructuredValues = "flatten"
+)
+
+// VaultTransitSpec specifies a Vault Transit key encrypting secret values
+type VaultTransitSpec struct {
+	// Mount path of a Transit secrets engine. Default: transit
+	// +optional
+	Mount string `json:"mount,omitempty"`
+
+	// Name of a Transit key
+	Key string `json:"key"`
+}
+
+// VaultPKISpec specifies a certificate issued by a Vault PKI secrets engine
+type VaultPKISpec struct {
+	// Mount path of a PKI secrets engine. Default: pki
+	// +optional
+	Mount string `json:"mount,omitempty"`
+
+	// Role to issue a certificate against
+	Role string `json:"role"`
+
+	// Common name of a certificate
+	CommonName string `json:"commonName"`
+
+	// DNS and email subject alternative names
+	// +optional
+	AltNames []string `json:"altNames,omitempty"`
+
+	// IP subject alternative names
+	// +optional
+	IPSANs []string `json:"ipSans,omitempty"`
+
+	// Requested certificate TTL (e.g. 720h). Default: the role TTL
+	// +optional
+	TTL string `json:"ttl,omitempty"`
+}
+
+func (s *VaultPKISpec) Validate() error {
+	if s.Role == "" {
+		return errors.New("source.vault.pki.role must be specified")
+	}
+	if s.CommonName == "" {
+		return errors.New("source.vault.pki.commonName must be specified")
+	}
+	return nil
+}
+
 // VaultSpec contains information of secret location
 type VaultSpec struct {
 	// Addr specifies a Vault endpoint URL (e.g. https://vault.example.com)
 	Addr string `json:"addr,omitempty"`
-	// Path specifies a vault secret path (e.g. secret/data/some-secret or mongodb/creds/mymongo)
+	// Path specifies a vault secret path (e.g. secret/data/some-secret or mongodb/creds/mymongo).
+	// A destination path is a Go template with .Namespace, .Name and .SourceName of a mirror,
+	// .Cluster and .Vars set on the controller (e.g. secret/data/{{ .Cluster }}/{{ .Namespace }}/{{ .Name }})
 	Path string `json:"path,omitempty"`
+
+	// Prefix makes a source mirror every secret under a Vault path prefix, each into a Kubernetes Secret named after
+	// its path relative to the prefix (e.g. secret/data/team-x). Only applies to a source, path is ignored when set
+	// +optional
+	Prefix string `json:"prefix,omitempty"`
+
+	// If set, secrets under nested paths of a prefix are mirrored too
+	// +optional
+	Recursive bool `json:"recursive,omitempty"`
+
+	// If set, a prefix without secrets deletes copies of all the secrets mirrored before. Otherwise an empty
+	// prefix is an error, as it may as well be caused by a typo or a policy denying the listing
+	// +optional
+	AllowEmptyPrefix bool `json:"allowEmptyPrefix,omitempty"`
+
+	// Transit encrypts values written to a destination with a Vault Transit key, or decrypts values
+	// read from a source
+	// +optional
+	Transit *VaultTransitSpec `json:"transit,omitempty"`
+
+	// PKI makes a source issue a TLS certificate from a Vault PKI secrets engine. Only applies to a source,
+	// path is ignored when set
+	// +optional
+	PKI *VaultPKISpec `json:"pki,omitempty"`
 	// +optional
 	Auth VaultAuthSpec `json:"auth,omitempty"`
+
+	// How secret values are encoded in Vault - auto, raw or base64. raw stores values as plain strings,
+	// base64 stores base64-encoded values. auto writes base64 and reads values according to the encoding
+	// recorded on write, falling back to decoding values which look like base64. Default: auto
+	// +kubebuilder:validation:Enum=auto;raw;base64
+	// +optional
+	Encoding VaultEncoding `json:"encoding,omitempty"`
+
+	// How non-string values (numbers, booleans, lists and nested objects) are handled - error, json or flatten.
+	// error fails a sync, json stores a value serialized as JSON, flatten expands nested objects into keys
+	// joined with flattenSeparator (e.g. db.primary.host) and serializes other values as JSON.
+	// A destination with flatten rebuilds nested objects from such keys. Default: error
+	// +kubebuilder:validation:Enum=error;json;flatten
+	// +optional
+	StructuredValues VaultStructuredValues `json:"structuredValues,omitempty"`
+
+	// Separator of nested keys with structuredValues: flatten. Default: .
+	// +kubebuilder:validation:Pattern=`^[-._a-zA-Z0-9]+$`
+	// +optional
+	FlattenSeparator string `json:"flattenSeparator,omitempty"`
+
+	// Share of a dynamic secret lease TTL (in percent) after which the lease is renewed,
+	// or of a PKI certificate lifetime after which it is re-issued. Only applies to a source. Default: 66
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=99
+	// +optional
+	LeaseRenewPercent int `json:"leaseRenewPercent,omitempty"`
+
+	// Seconds to wait after new credentials have been synced before revoking a lease they superseded.
+	// Only applies to a source. Default: 60
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	LeaseRevokeGracePeriodSeconds *int64 `json:"leaseRevokeGracePeriodSeconds,omitempty"`
+}
+
+const (
+	DefaultLeaseRenewPercent                   = 66
+	DefaultLeaseRevokeGracePeriodSeconds int64 = 60
+)
+
+// LeaseRevokeGracePeriod returns how long a superseded lease is kept after new credentials have been synced
+func (s *VaultSpec) LeaseRevokeGracePeriod() time.Duration {
+	if s.LeaseRevokeGracePeriodSeconds == nil {
+		return time.Duration(DefaultLeaseRevokeGracePeriodSeconds) * time.Second
+	}
+	return time.Duration(*s.LeaseRevokeGracePeriodSeconds) * time.Second
+}
+
+// LeaseRenewAfter returns when to renew a lease with the remaining ttl
+func (s *VaultSpec) LeaseRenewAfter(ttl time.Duration) time.Duration {
+	percent := s.LeaseRenewPercent
+	if percent <= 0 || percent >= 100 {
+		percent = DefaultLeaseRenewPercent
+	}
+	return ttl * time.Duration(percent) / 100
 }
 
 func (s *VaultSpec) Default(namespace string) {
+	if s.Encoding == "" {
+		s.Encoding = VaultEncodingAuto
+	}
+	if s.StructuredValues == "" {
+		s.StructuredValues = VaultStructuredValuesError
+	}
+	if s.FlattenSeparator == "" {
+		s.FlattenSeparator = "."
+	}
+	if s.Transit != nil && s.Transit.Mount == "" {
+		s.Transit.Mount = "transit"
+	}
+	if s.PKI != nil && s.PKI.Mount == "" {
+		s.PKI.Mount = "pki"
+	}
 	if s.Auth.Type() == VaultAuthTypeAppRole {
 		if s.Auth.AppRole.AppRolePath == "" {
 			s.Auth.AppRole.AppRolePath = "approle"
@@ -89,6 +250,39 @@ func (s *VaultSpec) Default(namespace string) {
 	}
 }
 
+// DefaultSource sets defaults of a source, including the ones which only apply to a source
+func (s *VaultSpec) DefaultSource(namespace string) {
+	s.Default(namespace)
+	if s.LeaseRenewPercent == 0 {
+		s.LeaseRenewPercent = DefaultLeaseRenewPercent
+	}
+	if s.LeaseRevokeGracePeriodSeconds == nil {
+		gracePeriod := DefaultLeaseRevokeGracePeriodSeconds
+		s.LeaseRevokeGracePeriodSeconds = &gracePeriod
+	}
+}
+
+// characters allowed in Secret data keys
+var secretKeyRegex = regexp.MustCompile(`^[-._a-zA-Z0-9]+$`)
+
+// ValidateValues validates how values are read from and written to Vault
+func (s *VaultSpec) ValidateValues() error {
+	switch s.Encoding {
+	case "", VaultEncodingAuto, VaultEncodingRaw, VaultEncodingBase64:
+	default:
+		return errors.New("vault.encoding must be one of the following: `auto`, `raw`, `base64`")
+	}
+	switch s.StructuredValues {
+	case "", VaultStructuredValuesError, VaultStructuredValuesJSON, VaultStructuredValuesFlatten:
+	default:
+		return errors.New("vault.structuredValues must be one of the following: `error`, `json`, `flatten`")
+	}
+	if s.FlattenSeparator != "" && !secretKeyRegex.MatchString(s.FlattenSeparator) {
+		return errors.New("vault.flattenSeparator may only contain `-`, `_`, `.` and alphanumeric characters")
+	}
+	return nil
+}
+
 func (s *VaultSpec) Validate() error {
 	if s.Addr == "" {
 		return errors.New("destination.vault.addr must be specified")
@@ -98,6 +292,10 @@ func (s *VaultSpec) Validate() error {
 		return errors.New("destination.vault.path must be specified")
 	}
 
+	if s.Transit != nil && s.Transit.Key == "" {
+		return errors.New("destination.vault.transit.key must be specified")
+	}
+
 	if s.Auth.Type() == VaultAuthTypeAppRole {
 		if s.Auth.AppRole.SecretRef.Name == "" {
 			return errors.New("vault.auth.appRole.secretRef.name is required when using appRole auth")
@@ -49,6 +49,12 @@ var testEnv *envtest.Environment
 var ctx context.Context
 var cancel context.CancelFunc
 
+// allowBackends accepts any source and destination, pkg/backend cannot be imported from here
+type allowBackends struct{}
+
+func (allowBackends) Default(r *SecretMirror)        {}
+func (allowBackends) Validate(r *SecretMirror) error { return nil }
+
 func TestAPIs(t *testing.T) {
 	RegisterFailHandler(Fail)
 
@@ -100,6 +106,7 @@ var _ = BeforeSuite(func() {
 	})
 	Expect(err).NotTo(HaveOccurred())
 
+	Backends = allowBackends{}
 	err = (&SecretMirror{}).SetupWebhookWithManager(mgr)
 	Expect(err).NotTo(HaveOccurred())
 
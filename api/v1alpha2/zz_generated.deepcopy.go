@@ -22,9 +22,311 @@ limitations under the License.
 package v1alpha2
 
 import (
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AWSAuthSpec) DeepCopyInto(out *AWSAuthSpec) {
+	*out = *in
+	if in.SecretRef != nil {
+		in, out := &in.SecretRef, &out.SecretRef
+		*out = new(v1.SecretReference)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AWSAuthSpec.
+func (in *AWSAuthSpec) DeepCopy() *AWSAuthSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(AWSAuthSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AWSSpec) DeepCopyInto(out *AWSSpec) {
+	*out = *in
+	in.Auth.DeepCopyInto(&out.Auth)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AWSSpec.
+func (in *AWSSpec) DeepCopy() *AWSSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(AWSSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AgeSpec) DeepCopyInto(out *AgeSpec) {
+	*out = *in
+	if in.IdentitySecretRef != nil {
+		in, out := &in.IdentitySecretRef, &out.IdentitySecretRef
+		*out = new(v1.LocalObjectReference)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AgeSpec.
+func (in *AgeSpec) DeepCopy() *AgeSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(AgeSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ConsulAuthSpec) DeepCopyInto(out *ConsulAuthSpec) {
+	*out = *in
+	if in.SecretRef != nil {
+		in, out := &in.SecretRef, &out.SecretRef
+		*out = new(v1.SecretReference)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConsulAuthSpec.
+func (in *ConsulAuthSpec) DeepCopy() *ConsulAuthSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ConsulAuthSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ConsulSpec) DeepCopyInto(out *ConsulSpec) {
+	*out = *in
+	in.Auth.DeepCopyInto(&out.Auth)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConsulSpec.
+func (in *ConsulSpec) DeepCopy() *ConsulSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ConsulSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DestinationPlan) DeepCopyInto(out *DestinationPlan) {
+	*out = *in
+	if in.ChangedKeys != nil {
+		in, out := &in.ChangedKeys, &out.ChangedKeys
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DestinationPlan.
+func (in *DestinationPlan) DeepCopy() *DestinationPlan {
+	if in == nil {
+		return nil
+	}
+	out := new(DestinationPlan)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GitAuthSpec) DeepCopyInto(out *GitAuthSpec) {
+	*out = *in
+	if in.SecretRef != nil {
+		in, out := &in.SecretRef, &out.SecretRef
+		*out = new(v1.SecretReference)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GitAuthSpec.
+func (in *GitAuthSpec) DeepCopy() *GitAuthSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(GitAuthSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GitSpec) DeepCopyInto(out *GitSpec) {
+	*out = *in
+	in.Auth.DeepCopyInto(&out.Auth)
+	in.SOPS.DeepCopyInto(&out.SOPS)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GitSpec.
+func (in *GitSpec) DeepCopy() *GitSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(GitSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HTTPAuthSpec) DeepCopyInto(out *HTTPAuthSpec) {
+	*out = *in
+	if in.SecretRef != nil {
+		in, out := &in.SecretRef, &out.SecretRef
+		*out = new(v1.SecretReference)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HTTPAuthSpec.
+func (in *HTTPAuthSpec) DeepCopy() *HTTPAuthSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(HTTPAuthSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HTTPDestinationStatusSpec) DeepCopyInto(out *HTTPDestinationStatusSpec) {
+	*out = *in
+	if in.LastResponseTime != nil {
+		in, out := &in.LastResponseTime, &out.LastResponseTime
+		*out = (*in).DeepCopy()
+	}
+	if in.ContentHashes != nil {
+		in, out := &in.ContentHashes, &out.ContentHashes
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HTTPDestinationStatusSpec.
+func (in *HTTPDestinationStatusSpec) DeepCopy() *HTTPDestinationStatusSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(HTTPDestinationStatusSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HTTPSignatureSpec) DeepCopyInto(out *HTTPSignatureSpec) {
+	*out = *in
+	out.SecretRef = in.SecretRef
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HTTPSignatureSpec.
+func (in *HTTPSignatureSpec) DeepCopy() *HTTPSignatureSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(HTTPSignatureSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HTTPSpec) DeepCopyInto(out *HTTPSpec) {
+	*out = *in
+	if in.Headers != nil {
+		in, out := &in.Headers, &out.Headers
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	in.Auth.DeepCopyInto(&out.Auth)
+	if in.Signature != nil {
+		in, out := &in.Signature, &out.Signature
+		*out = new(HTTPSignatureSpec)
+		**out = **in
+	}
+	if in.MaxRetries != nil {
+		in, out := &in.MaxRetries, &out.MaxRetries
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HTTPSpec.
+func (in *HTTPSpec) DeepCopy() *HTTPSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(HTTPSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RolloutSpec) DeepCopyInto(out *RolloutSpec) {
+	*out = *in
+	if in.Workloads != nil {
+		in, out := &in.Workloads, &out.Workloads
+		*out = make([]RolloutWorkloadSpec, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RolloutSpec.
+func (in *RolloutSpec) DeepCopy() *RolloutSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(RolloutSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RolloutWorkloadSpec) DeepCopyInto(out *RolloutWorkloadSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RolloutWorkloadSpec.
+func (in *RolloutWorkloadSpec) DeepCopy() *RolloutWorkloadSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(RolloutWorkloadSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SOPSSpec) DeepCopyInto(out *SOPSSpec) {
+	*out = *in
+	out.KeySecretRef = in.KeySecretRef
+	if in.AgeRecipients != nil {
+		in, out := &in.AgeRecipients, &out.AgeRecipients
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SOPSSpec.
+func (in *SOPSSpec) DeepCopy() *SOPSSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(SOPSSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SecretMirror) DeepCopyInto(out *SecretMirror) {
 	*out = *in
@@ -65,6 +367,31 @@ func (in *SecretMirrorDestination) DeepCopyInto(out *SecretMirrorDestination) {
 		*out = new(VaultSpec)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.AWS != nil {
+		in, out := &in.AWS, &out.AWS
+		*out = new(AWSSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Consul != nil {
+		in, out := &in.Consul, &out.Consul
+		*out = new(ConsulSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Git != nil {
+		in, out := &in.Git, &out.Git
+		*out = new(GitSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.HTTP != nil {
+		in, out := &in.HTTP, &out.HTTP
+		*out = new(HTTPSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Config != nil {
+		in, out := &in.Config, &out.Config
+		*out = new(runtime.RawExtension)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecretMirrorDestination.
@@ -112,11 +439,41 @@ func (in *SecretMirrorList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SecretMirrorSource) DeepCopyInto(out *SecretMirrorSource) {
 	*out = *in
+	if in.Selector != nil {
+		in, out := &in.Selector, &out.Selector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.Vault != nil {
 		in, out := &in.Vault, &out.Vault
 		*out = new(VaultSpec)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.AWS != nil {
+		in, out := &in.AWS, &out.AWS
+		*out = new(AWSSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Consul != nil {
+		in, out := &in.Consul, &out.Consul
+		*out = new(ConsulSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Git != nil {
+		in, out := &in.Git, &out.Git
+		*out = new(GitSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Age != nil {
+		in, out := &in.Age, &out.Age
+		*out = new(AgeSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Config != nil {
+		in, out := &in.Config, &out.Config
+		*out = new(runtime.RawExtension)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecretMirrorSource.
@@ -134,6 +491,11 @@ func (in *SecretMirrorSpec) DeepCopyInto(out *SecretMirrorSpec) {
 	*out = *in
 	in.Source.DeepCopyInto(&out.Source)
 	in.Destination.DeepCopyInto(&out.Destination)
+	if in.Rollout != nil {
+		in, out := &in.Rollout, &out.Rollout
+		*out = new(RolloutSpec)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecretMirrorSpec.
@@ -153,7 +515,40 @@ func (in *SecretMirrorStatus) DeepCopyInto(out *SecretMirrorStatus) {
 	if in.VaultSource != nil {
 		in, out := &in.VaultSource, &out.VaultSource
 		*out = new(VaultSourceStatusSpec)
-		**out = **in
+		(*in).DeepCopyInto(*out)
+	}
+	if in.MirroredSecrets != nil {
+		in, out := &in.MirroredSecrets, &out.MirroredSecrets
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.SupersededLeases != nil {
+		in, out := &in.SupersededLeases, &out.SupersededLeases
+		*out = make([]SupersededLeaseSpec, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Conflicts != nil {
+		in, out := &in.Conflicts, &out.Conflicts
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.NextRetryTime != nil {
+		in, out := &in.NextRetryTime, &out.NextRetryTime
+		*out = (*in).DeepCopy()
+	}
+	if in.HTTPDestination != nil {
+		in, out := &in.HTTPDestination, &out.HTTPDestination
+		*out = new(HTTPDestinationStatusSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Plan != nil {
+		in, out := &in.Plan, &out.Plan
+		*out = make([]DestinationPlan, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
 	}
 }
 
@@ -167,6 +562,29 @@ func (in *SecretMirrorStatus) DeepCopy() *SecretMirrorStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SupersededLeaseSpec) DeepCopyInto(out *SupersededLeaseSpec) {
+	*out = *in
+	if in.LeaseExpiresAt != nil {
+		in, out := &in.LeaseExpiresAt, &out.LeaseExpiresAt
+		*out = (*in).DeepCopy()
+	}
+	if in.RevokeAt != nil {
+		in, out := &in.RevokeAt, &out.RevokeAt
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SupersededLeaseSpec.
+func (in *SupersededLeaseSpec) DeepCopy() *SupersededLeaseSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(SupersededLeaseSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *VaultAppRoleAuthSpec) DeepCopyInto(out *VaultAppRoleAuthSpec) {
 	*out = *in
@@ -208,9 +626,46 @@ func (in *VaultAuthSpec) DeepCopy() *VaultAuthSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VaultPKISpec) DeepCopyInto(out *VaultPKISpec) {
+	*out = *in
+	if in.AltNames != nil {
+		in, out := &in.AltNames, &out.AltNames
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.IPSANs != nil {
+		in, out := &in.IPSANs, &out.IPSANs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VaultPKISpec.
+func (in *VaultPKISpec) DeepCopy() *VaultPKISpec {
+	if in == nil {
+		return nil
+	}
+	out := new(VaultPKISpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *VaultSourceStatusSpec) DeepCopyInto(out *VaultSourceStatusSpec) {
 	*out = *in
+	if in.LeaseExpiresAt != nil {
+		in, out := &in.LeaseExpiresAt, &out.LeaseExpiresAt
+		*out = (*in).DeepCopy()
+	}
+	if in.LeaseRenewAt != nil {
+		in, out := &in.LeaseRenewAt, &out.LeaseRenewAt
+		*out = (*in).DeepCopy()
+	}
+	if in.CertificateNotAfter != nil {
+		in, out := &in.CertificateNotAfter, &out.CertificateNotAfter
+		*out = (*in).DeepCopy()
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VaultSourceStatusSpec.
@@ -226,7 +681,22 @@ func (in *VaultSourceStatusSpec) DeepCopy() *VaultSourceStatusSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *VaultSpec) DeepCopyInto(out *VaultSpec) {
 	*out = *in
+	if in.Transit != nil {
+		in, out := &in.Transit, &out.Transit
+		*out = new(VaultTransitSpec)
+		**out = **in
+	}
+	if in.PKI != nil {
+		in, out := &in.PKI, &out.PKI
+		*out = new(VaultPKISpec)
+		(*in).DeepCopyInto(*out)
+	}
 	in.Auth.DeepCopyInto(&out.Auth)
+	if in.LeaseRevokeGracePeriodSeconds != nil {
+		in, out := &in.LeaseRevokeGracePeriodSeconds, &out.LeaseRevokeGracePeriodSeconds
+		*out = new(int64)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VaultSpec.
@@ -254,3 +724,18 @@ func (in *VaultTokenAuthSpec) DeepCopy() *VaultTokenAuthSpec {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VaultTransitSpec) DeepCopyInto(out *VaultTransitSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VaultTransitSpec.
+func (in *VaultTransitSpec) DeepCopy() *VaultTransitSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(VaultTransitSpec)
+	in.DeepCopyInto(out)
+	return out
+}
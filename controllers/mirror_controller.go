@@ -25,26 +25,42 @@ import (
 	"github.com/ktsstudio/mirrors/pkg/reconresult"
 	"github.com/ktsstudio/mirrors/pkg/vaulter"
 	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"time"
 )
 
+// MirrorReconcilerOptions tunes the behaviour of MirrorReconciler
+type MirrorReconcilerOptions struct {
+	// Upper bound of a retry delay after consecutive failed syncs. Default: 10 minutes
+	MaxRetryBackoff time.Duration
+
+	// Maximum number of SecretMirrors reconciled concurrently. Default: 1
+	MaxConcurrentReconciles int
+
+	Backend backend.Options
+}
+
 // MirrorReconciler reconciles a SecretMirror object
 type MirrorReconciler struct {
 	client.Client
 	Scheme   *runtime.Scheme
 	Recorder record.EventRecorder
 	Backend  SecretMirrorBackend
+	Options  MirrorReconcilerOptions
 }
 
 //+kubebuilder:rbac:groups=mirrors.kts.studio,resources=secretmirrors,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=mirrors.kts.studio,resources=secretmirrors/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=mirrors.kts.studio,resources=secretmirrors/finalizers,verbs=update
+//+kubebuilder:rbac:groups=apps,resources=deployments;statefulsets;daemonsets,verbs=get;list;patch
 //+kubebuilder:rbac:groups="",resources=secrets,verbs=get;watch;create;update;patch;delete;list
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
 //+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
@@ -74,7 +90,11 @@ func (r *MirrorReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	if err != nil {
 		return err
 	}
-	return builder.Complete(r)
+	return builder.
+		WithOptions(controller.Options{
+			MaxConcurrentReconciles: r.Options.MaxConcurrentReconciles,
+		}).
+		Complete(r)
 }
 
 func (r *MirrorReconciler) Cleanup() {
@@ -93,7 +113,7 @@ func (r *MirrorReconciler) handleReconcileResult(ctx context.Context, mirrorCtx
 			status = res.Status
 			requeueAfter = res.RequeueAfter
 			if requeueAfter == 0 {
-				requeueAfter = mirrorCtx.SecretMirror.PollPeriodDuration()
+				requeueAfter = time.Until(mirrorCtx.SecretMirror.NextSyncAt(time.Now()))
 			}
 
 			if res.EventType != "" && res.EventReason != "" {
@@ -108,7 +128,23 @@ func (r *MirrorReconciler) handleReconcileResult(ctx context.Context, mirrorCtx
 		if mirrorCtx.SecretMirror.Status.MirrorStatus != v1alpha2.MirrorStatusActive {
 			r.Recorder.Event(mirrorCtx.SecretMirror, v1.EventTypeNormal, "Active", "SecretMirror is synced")
 		}
-		requeueAfter = mirrorCtx.SecretMirror.PollPeriodDuration()
+		requeueAfter = time.Until(mirrorCtx.SecretMirror.NextSyncAt(time.Now()))
+	}
+
+	if status == v1alpha2.MirrorStatusError || status == v1alpha2.MirrorStatusPending {
+		mirrorCtx.SecretMirror.Status.ConsecutiveFailures++
+		requeueAfter = reconresult.Backoff(requeueAfter, mirrorCtx.SecretMirror.Status.ConsecutiveFailures, r.maxRetryBackoff())
+		nextRetryTime := metav1.NewTime(time.Now().Add(requeueAfter))
+		mirrorCtx.SecretMirror.Status.NextRetryTime = &nextRetryTime
+		logger.Info(fmt.Sprintf("sync failed %d times in a row, retrying in %s",
+			mirrorCtx.SecretMirror.Status.ConsecutiveFailures, requeueAfter))
+	} else {
+		if status != "" {
+			mirrorCtx.SecretMirror.Status.ConsecutiveFailures = 0
+			mirrorCtx.SecretMirror.Status.NextRetryTime = nil
+		}
+		// spread polls of mirrors which have been synced at the same moment (e.g. after a restart)
+		requeueAfter = reconresult.Jitter(requeueAfter, reconresult.JitterFactor)
 	}
 
 	if status != "" {
@@ -122,14 +158,23 @@ func (r *MirrorReconciler) handleReconcileResult(ctx context.Context, mirrorCtx
 	}, nil
 }
 
-func SetupMirrorsReconciler(mgr ctrl.Manager, nsKeeper *nskeeper.NSKeeper) (*MirrorReconciler, error) {
+func (r *MirrorReconciler) maxRetryBackoff() time.Duration {
+	if r.Options.MaxRetryBackoff > 0 {
+		return r.Options.MaxRetryBackoff
+	}
+	return reconresult.DefaultMaxRetryBackoff
+}
+
+func SetupMirrorsReconciler(mgr ctrl.Manager, nsKeeper *nskeeper.NSKeeper, options MirrorReconcilerOptions) (*MirrorReconciler, error) {
 	secretMirrorBackend, err := backend.MakeSecretMirrorBackend(
 		mgr.GetClient(),
+		mgr.GetAPIReader(),
 		mgr.GetEventRecorderFor("mirrors.kts.studio"),
 		nsKeeper,
 		func(addr string) (backend.VaultBackend, error) {
 			return vaulter.New(addr)
 		},
+		options.Backend,
 	)
 
 	if err != nil {
@@ -141,5 +186,6 @@ func SetupMirrorsReconciler(mgr ctrl.Manager, nsKeeper *nskeeper.NSKeeper) (*Mir
 		Scheme:   mgr.GetScheme(),
 		Backend:  secretMirrorBackend,
 		Recorder: secretMirrorBackend.Recorder,
+		Options:  options,
 	}, nil
 }
@@ -6,6 +6,7 @@ import (
 	"github.com/ktsstudio/mirrors/pkg/backend"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
@@ -212,6 +213,302 @@ var _ = Describe("SecretMirror", func() {
 			Expect(secretCopy2.Data).Should(Equal(secretData))
 		})
 
+		It("Should revert a copy modified outside of the mirror", func() {
+			By("Creating a mirror")
+			Expect(k8sClient.Create(ctx, track(makeTestMirror()))).Should(Succeed())
+			mirror = &v1alpha2.SecretMirror{}
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, mirrorKey, mirror)
+				if err != nil {
+					return false
+				}
+				return mirror.Status.MirrorStatus == v1alpha2.MirrorStatusActive
+			}, timeout, interval).Should(BeTrue())
+
+			By("Tampering with a copy")
+			copyKey := types.NamespacedName{
+				Name:      SourceSecretName,
+				Namespace: "mirror-ns-1",
+			}
+			secretCopy, err := backend.FetchSecret(ctx, k8sClient, copyKey)
+			Expect(err).Should(Succeed())
+			secretCopy.Data["hello"] = []byte("tampered")
+			Expect(k8sClient.Update(ctx, secretCopy)).Should(Succeed())
+
+			By("Ensuring the copy has been reverted")
+			Eventually(func() map[string][]byte {
+				r, _ := backend.FetchSecret(ctx, k8sClient, copyKey)
+				if r == nil {
+					return nil
+				}
+				return r.Data
+			}, timeout, interval).Should(Equal(secretData))
+		})
+
+		It("Should adopt a pre-existing secret with conflictPolicy=adopt", func() {
+			By("Creating an unmanaged secret in a destination namespace")
+			Expect(k8sClient.Create(ctx, track(&v1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      SourceSecretName,
+					Namespace: "mirror-ns-1",
+				},
+				Data: map[string][]byte{
+					"hello": []byte("stranger"),
+				},
+			}))).Should(Succeed())
+
+			By("Creating a mirror")
+			adoptingMirror := makeTestMirror()
+			adoptingMirror.Spec.Destination.ConflictPolicy = v1alpha2.ConflictPolicyAdopt
+			Expect(k8sClient.Create(ctx, track(adoptingMirror))).Should(Succeed())
+			mirror = &v1alpha2.SecretMirror{}
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, mirrorKey, mirror)
+				if err != nil {
+					return false
+				}
+				return mirror.Status.MirrorStatus == v1alpha2.MirrorStatusActive
+			}, timeout, interval).Should(BeTrue())
+			Expect(mirror.Status.Conflicts).Should(BeEmpty())
+
+			By("Ensuring the secret has been taken over")
+			secretCopy, err := backend.FetchSecret(ctx, k8sClient, types.NamespacedName{
+				Name:      SourceSecretName,
+				Namespace: "mirror-ns-1",
+			})
+			Expect(err).Should(Succeed())
+			Expect(secretCopy.Data).Should(Equal(secretData))
+		})
+
+		It("Should only publish a plan with dryRun=true", func() {
+			By("Creating a dry run mirror")
+			dryRunMirror := makeTestMirror()
+			dryRunMirror.Spec.DryRun = true
+			Expect(k8sClient.Create(ctx, track(dryRunMirror))).Should(Succeed())
+			mirror = &v1alpha2.SecretMirror{}
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, mirrorKey, mirror)
+				if err != nil {
+					return false
+				}
+				return mirror.Status.MirrorStatus == v1alpha2.MirrorStatusDryRun
+			}, timeout, interval).Should(BeTrue())
+
+			By("Ensuring the plan contains destination namespaces")
+			Expect(mirror.Status.Plan).Should(ContainElement(v1alpha2.DestinationPlan{
+				Destination: "mirror-ns-1",
+				Action:      v1alpha2.PlanActionCreate,
+				ChangedKeys: []string{"general", "hello"},
+			}))
+
+			By("Ensuring nothing has been copied")
+			secretCopy, err := backend.FetchSecret(ctx, k8sClient, types.NamespacedName{
+				Name:      SourceSecretName,
+				Namespace: "mirror-ns-1",
+			})
+			Expect(err).Should(Succeed())
+			Expect(secretCopy).Should(BeNil())
+		})
+
+		It("Should not sync while suspended and sync once resumed", func() {
+			By("Creating a suspended mirror")
+			suspendedMirror := makeTestMirror()
+			suspendedMirror.Spec.Suspend = true
+			Expect(k8sClient.Create(ctx, track(suspendedMirror))).Should(Succeed())
+			mirror = &v1alpha2.SecretMirror{}
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, mirrorKey, mirror)
+				if err != nil {
+					return false
+				}
+				return mirror.Status.MirrorStatus == v1alpha2.MirrorStatusSuspended
+			}, timeout, interval).Should(BeTrue())
+
+			copyKey := types.NamespacedName{
+				Name:      SourceSecretName,
+				Namespace: "mirror-ns-1",
+			}
+			secretCopy, err := backend.FetchSecret(ctx, k8sClient, copyKey)
+			Expect(err).Should(Succeed())
+			Expect(secretCopy).Should(BeNil())
+
+			By("Resuming the mirror")
+			mirror.Spec.Suspend = false
+			Expect(k8sClient.Update(ctx, mirror)).Should(Succeed())
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, mirrorKey, mirror)
+				if err != nil {
+					return false
+				}
+				return mirror.Status.MirrorStatus == v1alpha2.MirrorStatusActive
+			}, timeout, interval).Should(BeTrue())
+
+			secretCopy, err = backend.FetchSecret(ctx, k8sClient, copyKey)
+			Expect(err).Should(Succeed())
+			Expect(secretCopy.Data).Should(Equal(secretData))
+		})
+
+		It("Should sync on demand when sync is requested via annotation", func() {
+			By("Creating a mirror with a long poll period")
+			slowMirror := makeTestMirror()
+			slowMirror.Spec.PollPeriodSeconds = 3600
+			Expect(k8sClient.Create(ctx, track(slowMirror))).Should(Succeed())
+			mirror = &v1alpha2.SecretMirror{}
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, mirrorKey, mirror)
+				if err != nil {
+					return false
+				}
+				return mirror.Status.MirrorStatus == v1alpha2.MirrorStatusActive
+			}, timeout, interval).Should(BeTrue())
+
+			By("Changing the source secret")
+			source := &v1.Secret{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{
+				Name:      SourceSecretName,
+				Namespace: SecretMirrorNamespace,
+			}, source)).Should(Succeed())
+			source.Data["hello"] = []byte("again")
+			Expect(k8sClient.Update(ctx, source)).Should(Succeed())
+
+			By("Requesting a sync")
+			Expect(k8sClient.Get(ctx, mirrorKey, mirror)).Should(Succeed())
+			mirror.Annotations = map[string]string{
+				"mirrors.kts.studio/sync-requested-at": "1",
+			}
+			Expect(k8sClient.Update(ctx, mirror)).Should(Succeed())
+
+			Eventually(func() string {
+				f := &v1alpha2.SecretMirror{}
+				_ = k8sClient.Get(ctx, mirrorKey, f)
+				return f.Status.LastHandledSyncRequest
+			}, timeout, interval).Should(Equal("1"))
+
+			secretCopy, err := backend.FetchSecret(ctx, k8sClient, types.NamespacedName{
+				Name:      SourceSecretName,
+				Namespace: "mirror-ns-1",
+			})
+			Expect(err).Should(Succeed())
+			Expect(string(secretCopy.Data["hello"])).Should(Equal("again"))
+		})
+
+		It("Should roll out workloads consuming a changed secret with rollout set", func() {
+			By("Creating a deployment reading the secret")
+			deploymentKey := types.NamespacedName{
+				Name:      "consumer",
+				Namespace: "mirror-ns-1",
+			}
+			labels := map[string]string{"app": "consumer"}
+			Expect(k8sClient.Create(ctx, track(&appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      deploymentKey.Name,
+					Namespace: deploymentKey.Namespace,
+				},
+				Spec: appsv1.DeploymentSpec{
+					Selector: &metav1.LabelSelector{MatchLabels: labels},
+					Template: v1.PodTemplateSpec{
+						ObjectMeta: metav1.ObjectMeta{Labels: labels},
+						Spec: v1.PodSpec{
+							Containers: []v1.Container{{
+								Name:  "app",
+								Image: "busybox",
+								EnvFrom: []v1.EnvFromSource{{
+									SecretRef: &v1.SecretEnvSource{
+										LocalObjectReference: v1.LocalObjectReference{Name: SourceSecretName},
+									},
+								}},
+							}},
+						},
+					},
+				},
+			}))).Should(Succeed())
+
+			By("Creating a mirror with rollout")
+			rolloutMirror := makeTestMirror()
+			rolloutMirror.Spec.Rollout = &v1alpha2.RolloutSpec{}
+			Expect(k8sClient.Create(ctx, track(rolloutMirror))).Should(Succeed())
+			mirror = &v1alpha2.SecretMirror{}
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, mirrorKey, mirror)
+				if err != nil {
+					return false
+				}
+				return mirror.Status.MirrorStatus == v1alpha2.MirrorStatusActive
+			}, timeout, interval).Should(BeTrue())
+
+			By("Changing the source secret")
+			source := &v1.Secret{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{
+				Name:      SourceSecretName,
+				Namespace: SecretMirrorNamespace,
+			}, source)).Should(Succeed())
+			source.Data["hello"] = []byte("rotated")
+			Expect(k8sClient.Update(ctx, source)).Should(Succeed())
+
+			By("Ensuring the deployment has been restarted")
+			Eventually(func() string {
+				deployment := &appsv1.Deployment{}
+				_ = k8sClient.Get(ctx, deploymentKey, deployment)
+				return deployment.Spec.Template.Annotations["checksum.mirrors.kts.studio/"+SourceSecretName]
+			}, timeout, interval).ShouldNot(BeEmpty())
+		})
+
+		It("Should mirror secrets selected by labels and prune the ones no longer matching", func() {
+			By("Creating labeled secrets")
+			for _, name := range []string{"shared-1", "shared-2"} {
+				Expect(k8sClient.Create(ctx, track(&v1.Secret{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      name,
+						Namespace: SecretMirrorNamespace,
+						Labels:    map[string]string{"share": "true"},
+					},
+					Data: secretData,
+				}))).Should(Succeed())
+			}
+
+			By("Creating a mirror with a selector")
+			selectorMirror := makeTestMirror()
+			selectorMirror.Spec.Source.Selector = &metav1.LabelSelector{
+				MatchLabels: map[string]string{"share": "true"},
+			}
+			Expect(k8sClient.Create(ctx, track(selectorMirror))).Should(Succeed())
+			mirror = &v1alpha2.SecretMirror{}
+			Eventually(func() []string {
+				_ = k8sClient.Get(ctx, mirrorKey, mirror)
+				return mirror.Status.MirroredSecrets
+			}, timeout, interval).Should(Equal([]string{"shared-1", "shared-2"}))
+
+			for _, name := range []string{"shared-1", "shared-2"} {
+				secretCopy, err := backend.FetchSecret(ctx, k8sClient, types.NamespacedName{
+					Name:      name,
+					Namespace: "mirror-ns-1",
+				})
+				Expect(err).Should(Succeed())
+				Expect(secretCopy).ShouldNot(BeNil())
+				Expect(secretCopy.Data).Should(Equal(secretData))
+			}
+
+			By("Removing a label from a secret")
+			shared := &v1.Secret{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{
+				Name:      "shared-2",
+				Namespace: SecretMirrorNamespace,
+			}, shared)).Should(Succeed())
+			shared.Labels = nil
+			Expect(k8sClient.Update(ctx, shared)).Should(Succeed())
+
+			By("Ensuring its copies have been pruned")
+			Eventually(func() *v1.Secret {
+				r, _ := backend.FetchSecret(ctx, k8sClient, types.NamespacedName{
+					Name:      "shared-2",
+					Namespace: "mirror-ns-1",
+				})
+				return r
+			}, timeout, interval).Should(BeNil())
+			Expect(k8sClient.Get(ctx, mirrorKey, mirror)).Should(Succeed())
+			Expect(mirror.Status.MirroredSecrets).Should(Equal([]string{"shared-1"}))
+		})
+
 		It("Should delete secrets when mirror is deleted", func() {
 			By("Creating a mirror")
 			Expect(k8sClient.Create(ctx, track(makeTestMirror()))).Should(Succeed())
@@ -22,11 +22,14 @@ import (
 	mirrorsv1alpha2 "github.com/ktsstudio/mirrors/api/v1alpha2"
 	"github.com/ktsstudio/mirrors/pkg/nskeeper"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 )
 
@@ -35,6 +38,9 @@ type NamespaceReconciler struct {
 	client.Client
 	Scheme   *runtime.Scheme
 	nsKeeper *nskeeper.NSKeeper
+
+	// Maximum number of Namespaces reconciled concurrently. Default: 1
+	MaxConcurrentReconciles int
 }
 
 //+kubebuilder:rbac:groups="",resources=namespaces,verbs=get;watch;list
@@ -51,8 +57,12 @@ type NamespaceReconciler struct {
 func (r *NamespaceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	logger := log.FromContext(ctx)
 
-	var ns v1.Namespace
+	var ns metav1.PartialObjectMetadata
+	ns.SetGroupVersionKind(nskeeper.NamespaceGVK)
 	if err := r.Get(ctx, req.NamespacedName, &ns); err != nil {
+		if errors.IsNotFound(err) {
+			r.nsKeeper.DeleteNamespace(req.Name)
+		}
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
@@ -86,7 +96,10 @@ func (r *NamespaceReconciler) SetupWithManager(mgr ctrl.Manager, nsKeeper *nskee
 	r.nsKeeper = nsKeeper
 
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&v1.Namespace{}).
+		For(&v1.Namespace{}, builder.OnlyMetadata).
+		WithOptions(controller.Options{
+			MaxConcurrentReconciles: r.MaxConcurrentReconciles,
+		}).
 		Complete(r)
 }
 
@@ -66,7 +66,7 @@ func setupTestMirrors(ctx context.Context, cfg *rest.Config) *MirrorReconciler {
 	Expect(err).ToNot(HaveOccurred())
 
 	testNsKeeper = nskeeper.MakeNSKeeper(k8sManager.GetClient())
-	controller, err := SetupMirrorsReconciler(k8sManager, testNsKeeper)
+	controller, err := SetupMirrorsReconciler(k8sManager, testNsKeeper, MirrorReconcilerOptions{})
 	Expect(err).ToNot(HaveOccurred())
 
 	err = controller.SetupWithManager(k8sManager)
@@ -18,14 +18,22 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"os"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/ktsstudio/mirrors/pkg/backend"
 	"github.com/ktsstudio/mirrors/pkg/nskeeper"
+	"github.com/ktsstudio/mirrors/pkg/plugin"
+	"github.com/ktsstudio/mirrors/pkg/reconresult"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
@@ -33,6 +41,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
+	configv1alpha1 "github.com/ktsstudio/mirrors/api/config/v1alpha1"
 	mirrorsv1alpha1 "github.com/ktsstudio/mirrors/api/v1alpha1"
 	mirrorsv1alpha2 "github.com/ktsstudio/mirrors/api/v1alpha2"
 	"github.com/ktsstudio/mirrors/controllers"
@@ -49,18 +58,57 @@ func init() {
 
 	utilruntime.Must(mirrorsv1alpha1.AddToScheme(scheme))
 	utilruntime.Must(mirrorsv1alpha2.AddToScheme(scheme))
+	utilruntime.Must(configv1alpha1.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
 func main() {
+	var configFile string
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var maxRetryBackoff time.Duration
+	var pluginCallTimeout time.Duration
+	var flagsConfig configv1alpha1.MirrorsSpec
+	flag.StringVar(&configFile, "config", "",
+		"The controller will load its initial configuration from this file. "+
+			"Omit this flag to use the default configuration values. "+
+			"Command-line flags override configuration from this file.")
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.DurationVar(&maxRetryBackoff, "max-retry-backoff", 0,
+		"The maximum delay between retries of a failing SecretMirror (default 10m).")
+	flag.IntVar(&flagsConfig.WorkerPoolSize, "worker-pool-size", 0,
+		"The number of workers syncing secrets to destination namespaces (default 100).")
+	flag.Int64Var(&flagsConfig.DefaultPollPeriodSeconds, "default-poll-period-seconds", 0,
+		"The poll period of SecretMirrors which do not specify one (default 180).")
+	flag.IntVar(&flagsConfig.Controllers.SecretMirror.MaxConcurrentReconciles, "secretmirror-max-concurrent-reconciles", 0,
+		"The maximum number of SecretMirrors reconciled concurrently (default 1).")
+	flag.IntVar(&flagsConfig.Controllers.Namespace.MaxConcurrentReconciles, "namespace-max-concurrent-reconciles", 0,
+		"The maximum number of Namespaces reconciled concurrently (default 1).")
+	flag.IntVar(&flagsConfig.KubeClient.QPS, "kube-api-qps", 0,
+		"The maximum queries per second to the Kubernetes API (default is the client-go default).")
+	flag.IntVar(&flagsConfig.KubeClient.Burst, "kube-api-burst", 0,
+		"The maximum burst of queries to the Kubernetes API (default is the client-go default).")
+	flag.IntVar(&flagsConfig.WriteRateLimit.QPS, "destination-write-qps", 0,
+		"The maximum destination writes per second across all SecretMirrors (default unlimited).")
+	flag.IntVar(&flagsConfig.WriteRateLimit.Burst, "destination-write-burst", 0,
+		"The maximum burst of destination writes (default equals to destination-write-qps).")
+	flag.StringVar(&flagsConfig.ClusterName, "cluster-name", "",
+		"The cluster name available in destination Vault path templates as {{ .Cluster }}.")
+	flag.Var(mapFlag{&flagsConfig.PathVariables}, "path-variable",
+		"A key=value variable available in destination Vault path templates as {{ .Vars.key }}. Can be repeated.")
+	flag.StringVar(&flagsConfig.Plugins.Dir, "plugin-dir", "",
+		"The directory with plugin binaries, each available as source or destination type plugin/<file name>.")
+	flag.Var(mapFlag{&flagsConfig.Plugins.Sockets}, "plugin-socket",
+		"A name=path unix socket of a sidecar plugin available as source or destination type plugin/<name>. Can be repeated.")
+	flag.DurationVar(&pluginCallTimeout, "plugin-call-timeout", 0,
+		"The maximum duration of a single call to a plugin (default 30s).")
+	flag.StringVar(&flagsConfig.AgeIdentitySecret, "age-identity-secret", "",
+		"The namespace/name of a Secret with age identities decrypting age sources which do not reference one.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -69,59 +117,122 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+	if maxRetryBackoff > 0 {
+		flagsConfig.MaxRetryBackoff = &metav1.Duration{Duration: maxRetryBackoff}
+	}
+	if pluginCallTimeout > 0 {
+		flagsConfig.Plugins.CallTimeout = &metav1.Duration{Duration: pluginCallTimeout}
+	}
+
+	var err error
+	mirrorsConfig := configv1alpha1.MirrorsConfig{}
+	options := ctrl.Options{
 		Scheme:                 scheme,
 		MetricsBindAddress:     metricsAddr,
 		Port:                   9443,
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
 		LeaderElectionID:       "7e6f5103.kts.studio",
-	})
+	}
+	if configFile != "" {
+		options, err = options.AndFrom(ctrl.ConfigFile().AtPath(configFile).OfKind(&mirrorsConfig))
+		if err != nil {
+			setupLog.Error(err, "unable to load the config file")
+			os.Exit(1)
+		}
+	}
+	mirrorsConfig.Mirrors.Override(flagsConfig)
+	tuning := mirrorsConfig.Mirrors
+	if tuning.MaxRetryBackoff == nil {
+		tuning.MaxRetryBackoff = &metav1.Duration{Duration: reconresult.DefaultMaxRetryBackoff}
+	}
+
+	restConfig := ctrl.GetConfigOrDie()
+	if tuning.KubeClient.QPS > 0 {
+		restConfig.QPS = float32(tuning.KubeClient.QPS)
+	}
+	if tuning.KubeClient.Burst > 0 {
+		restConfig.Burst = tuning.KubeClient.Burst
+	}
+
+	if tuning.Plugins.CallTimeout == nil {
+		tuning.Plugins.CallTimeout = &metav1.Duration{Duration: plugin.DefaultCallTimeout}
+	}
+	plugins, err := plugin.Discover(tuning.Plugins.Dir, tuning.Plugins.Sockets, tuning.Plugins.CallTimeout.Duration)
 	if err != nil {
-		setupLog.Error(err, "unable to start manager")
+		setupLog.Error(err, "unable to discover plugins")
+		os.Exit(1)
+	}
+	plugins.Register()
+	defer plugins.Kill()
+	// os.Exit skips deferred calls, so plugin binaries are stopped before exiting
+	exit := func() {
+		plugins.Kill()
 		os.Exit(1)
 	}
+	if names := plugins.Names(); len(names) > 0 {
+		setupLog.Info("registered plugins", "plugins", names)
+	}
+
+	mgr, err := ctrl.NewManager(restConfig, options)
+	if err != nil {
+		setupLog.Error(err, "unable to start manager")
+		exit()
+	}
 
 	nsKeeper := nskeeper.MakeNSKeeper(mgr.GetClient())
 
-	secretMirrorReconciler, err := controllers.SetupMirrorsReconciler(mgr, nsKeeper)
+	secretMirrorReconciler, err := controllers.SetupMirrorsReconciler(mgr, nsKeeper, controllers.MirrorReconcilerOptions{
+		MaxRetryBackoff:         tuning.MaxRetryBackoff.Duration,
+		MaxConcurrentReconciles: tuning.Controllers.SecretMirror.MaxConcurrentReconciles,
+		Backend: backend.Options{
+			WorkerPoolSize:           tuning.WorkerPoolSize,
+			DefaultPollPeriodSeconds: tuning.DefaultPollPeriodSeconds,
+			WriteQPS:                 tuning.WriteRateLimit.QPS,
+			WriteBurst:               tuning.WriteRateLimit.Burst,
+			ClusterName:              tuning.ClusterName,
+			PathVariables:            tuning.PathVariables,
+			AgeIdentitySecret:        tuning.AgeIdentitySecret,
+		},
+	})
 	if err != nil {
 		setupLog.Error(err, "unable to create secret mirror backend")
-		os.Exit(1)
+		exit()
 	}
 	defer secretMirrorReconciler.Cleanup()
 
 	if err = secretMirrorReconciler.SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create secretmirror controller", "controller", "SecretMirror")
-		os.Exit(1)
+		exit()
 	}
 
 	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
 		if err = (&mirrorsv1alpha1.SecretMirror{}).SetupWebhookWithManager(mgr); err != nil {
 			setupLog.Error(err, "unable to create webhook", "webhook", "SecretMirror")
-			os.Exit(1)
+			exit()
 		}
 		if err = (&mirrorsv1alpha2.SecretMirror{}).SetupWebhookWithManager(mgr); err != nil {
 			setupLog.Error(err, "unable to create webhook", "webhook", "SecretMirror")
-			os.Exit(1)
+			exit()
 		}
 	}
 	if err = (&controllers.NamespaceReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:                  mgr.GetClient(),
+		Scheme:                  mgr.GetScheme(),
+		MaxConcurrentReconciles: tuning.Controllers.Namespace.MaxConcurrentReconciles,
 	}).SetupWithManager(mgr, nsKeeper); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Namespace")
-		os.Exit(1)
+		exit()
 	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
-		os.Exit(1)
+		exit()
 	}
 	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up ready check")
-		os.Exit(1)
+		exit()
 	}
 
 	workContext := ctrl.SetupSignalHandler()
@@ -132,6 +243,35 @@ func main() {
 	setupLog.Info("starting manager")
 	if err := mgr.Start(workContext); err != nil {
 		setupLog.Error(err, "problem running manager")
-		os.Exit(1)
+		exit()
+	}
+}
+
+// mapFlag collects repeated key=value flags into a map
+type mapFlag struct {
+	values *map[string]string
+}
+
+func (f mapFlag) String() string {
+	if f.values == nil {
+		return ""
+	}
+	pairs := make([]string, 0, len(*f.values))
+	for k, v := range *f.values {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (f mapFlag) Set(value string) error {
+	kv := strings.SplitN(value, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return fmt.Errorf("%q is not in key=value format", value)
+	}
+	if *f.values == nil {
+		*f.values = make(map[string]string)
 	}
+	(*f.values)[kv[0]] = kv[1]
+	return nil
 }
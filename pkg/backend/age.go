@@ -0,0 +1,80 @@
+package backend
+
+import (
+	"bytes"
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// age files (https://age-encryption.org/v1) are encrypted and decrypted with filippo.io/age.
+// Identities and recipients are X25519 keys: AGE-SECRET-KEY-1... and age1...
+
+// ageIntro is the first line of a binary age file
+const ageIntro = "age-encryption.org/v1"
+
+// parseAgeIdentities parses an identity file: AGE-SECRET-KEY-1... lines, # comments and blank lines are skipped
+func parseAgeIdentities(data []byte) ([]age.Identity, error) {
+	return age.ParseIdentities(bytes.NewReader(data))
+}
+
+// ageRecipient returns an age1... public key of an identity
+func ageRecipient(identity age.Identity) (string, bool) {
+	x25519, ok := identity.(*age.X25519Identity)
+	if !ok {
+		return "", false
+	}
+	return x25519.Recipient().String(), true
+}
+
+// ageEncrypt encrypts plaintext for recipients into an armored age file
+func ageEncrypt(plaintext []byte, recipients []string) (string, error) {
+	parsed := make([]age.Recipient, 0, len(recipients))
+	for _, recipient := range recipients {
+		r, err := age.ParseX25519Recipient(recipient)
+		if err != nil {
+			return "", err
+		}
+		parsed = append(parsed, r)
+	}
+
+	var out bytes.Buffer
+	armored := armor.NewWriter(&out)
+	w, err := age.Encrypt(armored, parsed...)
+	if err != nil {
+		return "", err
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	if err := armored.Close(); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// ageDecrypt decrypts an armored or binary age file with any of identities
+func ageDecrypt(data []byte, identities []age.Identity) ([]byte, error) {
+	var in io.Reader = bytes.NewReader(data)
+	if isAgeArmored(data) {
+		in = armor.NewReader(bytes.NewReader(bytes.TrimSpace(data)))
+	}
+	r, err := age.Decrypt(in, identities...)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read age payload: %w", err)
+	}
+	return plaintext, nil
+}
+
+func isAgeArmored(data []byte) bool {
+	return strings.HasPrefix(string(bytes.TrimSpace(data)), armor.Header)
+}
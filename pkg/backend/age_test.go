@@ -0,0 +1,235 @@
+package backend
+
+import (
+	"context"
+	"filippo.io/age"
+	mirrorsv1alpha2 "github.com/ktsstudio/mirrors/api/v1alpha2"
+	"github.com/ktsstudio/mirrors/pkg/reconresult"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"os"
+	"path/filepath"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strings"
+	"testing"
+)
+
+func TestAgeSecretSourceDecrypt(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	identities, err := parseAgeIdentities([]byte(identity.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := ageEncrypt([]byte("s3cr3t"), []string{identity.Recipient().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var source v1.Secret
+	source.Namespace, source.Name = "default", "db"
+	source.Data = map[string][]byte{
+		"password": []byte(encrypted),
+		"host":     []byte("db.example.com"),
+	}
+
+	s := &AgeSecretSource{identities: identities}
+	decrypted, err := s.decrypt(&source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decrypted.Data["password"]) != "s3cr3t" || string(decrypted.Data["host"]) != "db.example.com" {
+		t.Fatalf("unexpected data %q", decrypted.Data)
+	}
+	if string(source.Data["password"]) != encrypted {
+		t.Fatal("source secret has been modified")
+	}
+
+	s.identities = nil
+	if _, err := s.decrypt(&source); err == nil || strings.Contains(err.Error(), "s3cr3t") {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+// testdata/age files are encrypted for identity.agekey with the age 1.0.0 CLI:
+// password.age is armored (age -a), tls.crt.age is binary
+func testdataAge(t *testing.T, name string) []byte {
+	data, err := os.ReadFile(filepath.Join("testdata/age", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+const testAgeCertificate = "-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n"
+
+func TestAgeDecryptCLIFiles(t *testing.T) {
+	identities, err := parseAgeIdentities(testdataAge(t, "identity.agekey"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, expected := range map[string]string{
+		"password.age": "s3cr3t",
+		"tls.crt.age":  testAgeCertificate,
+	} {
+		encrypted := testdataAge(t, name)
+		if !isAgeEncrypted(encrypted) {
+			t.Fatalf("%s is not detected as age-encrypted", name)
+		}
+		plaintext, err := ageDecrypt(encrypted, identities)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if string(plaintext) != expected {
+			t.Fatalf("%s: unexpected plaintext %q", name, plaintext)
+		}
+	}
+
+	other, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ageDecrypt(testdataAge(t, "password.age"), []age.Identity{other}); err == nil {
+		t.Fatal("decrypted with a wrong identity")
+	}
+}
+
+func testAgeMirror(spec *mirrorsv1alpha2.AgeSpec) *mirrorsv1alpha2.SecretMirror {
+	mirror := testMirror("", "")
+	mirror.Spec.Source.Type = mirrorsv1alpha2.SourceTypeAge
+	mirror.Spec.Source.Age = spec
+	backends.Default(mirror)
+	return mirror
+}
+
+func testAgeIdentitySecret(namespace, name string, identity []byte) *v1.Secret {
+	return &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Data:       map[string][]byte{"age.agekey": identity},
+	}
+}
+
+func retrieveAge(ctx context.Context, b *SecretMirrorBackend, mirror *mirrorsv1alpha2.SecretMirror) (*v1.Secret, error) {
+	registration, _ := backends.source(mirrorsv1alpha2.SourceTypeAge)
+	source, err := registration.Factory(ctx, b, mirror)
+	if err != nil {
+		return nil, err
+	}
+	return source.Retrieve(ctx)
+}
+
+func TestAgeSecretSourceRetrieve(t *testing.T) {
+	ctx := context.Background()
+	identity := testAgeIdentitySecret("default", "age-identity", testdataAge(t, "identity.agekey"))
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "db"},
+		Data: map[string][]byte{
+			"password": testdataAge(t, "password.age"),
+			"tls.crt":  testdataAge(t, "tls.crt.age"),
+			"host":     []byte("db.example.com"),
+		},
+	}
+	configMap := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "db"},
+		Data: map[string]string{
+			"password": string(testdataAge(t, "password.age")),
+			"host":     "db.example.com",
+		},
+		BinaryData: map[string][]byte{"tls.crt": testdataAge(t, "tls.crt.age")},
+	}
+	b := testBackend(t, Options{}, identity, secret, configMap)
+
+	for _, kind := range []mirrorsv1alpha2.AgeSourceKind{mirrorsv1alpha2.AgeSourceKindSecret, mirrorsv1alpha2.AgeSourceKindConfigMap} {
+		mirror := testAgeMirror(&mirrorsv1alpha2.AgeSpec{
+			Kind:              kind,
+			IdentitySecretRef: &v1.LocalObjectReference{Name: "age-identity"},
+		})
+		decrypted, err := retrieveAge(ctx, b, mirror)
+		if err != nil {
+			t.Fatalf("%s: %s", kind, err)
+		}
+		expected := map[string][]byte{
+			"password": []byte("s3cr3t"),
+			"tls.crt":  []byte(testAgeCertificate),
+			"host":     []byte("db.example.com"),
+		}
+		if dataDiffer(expected, decrypted.Data) {
+			t.Fatalf("%s: unexpected data %q", kind, decrypted.Data)
+		}
+	}
+}
+
+func TestAgeSecretSourceWrongIdentity(t *testing.T) {
+	ctx := context.Background()
+	other, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "db"},
+		Data:       map[string][]byte{"password": testdataAge(t, "password.age")},
+	}
+	b := testBackend(t, Options{}, testAgeIdentitySecret("default", "age-identity", []byte(other.String())), secret)
+
+	mirror := testAgeMirror(&mirrorsv1alpha2.AgeSpec{IdentitySecretRef: &v1.LocalObjectReference{Name: "age-identity"}})
+	_, err = retrieveAge(ctx, b, mirror)
+	result, ok := err.(*reconresult.ReconcileResult)
+	if !ok || result.EventReason != "AgeDecryptFailed" || result.Status != mirrorsv1alpha2.MirrorStatusError {
+		t.Fatalf("expected a decryption failure, got %v", err)
+	}
+	if !strings.Contains(result.Message, "key password") || strings.Contains(result.Message, "s3cr3t") {
+		t.Fatalf("unexpected message %q", result.Message)
+	}
+}
+
+func TestAgeSecretSourceMissing(t *testing.T) {
+	ctx := context.Background()
+	identity := testdataAge(t, "identity.agekey")
+	ref := &v1.LocalObjectReference{Name: "age-identity"}
+
+	for _, test := range []struct {
+		name    string
+		options Options
+		objects []client.Object
+		spec    *mirrorsv1alpha2.AgeSpec
+		reason  string
+	}{
+		{
+			name:   "no identity secret configured",
+			spec:   &mirrorsv1alpha2.AgeSpec{},
+			reason: "AgeIdentityMissing",
+		},
+		{
+			name:   "identity secret not found",
+			spec:   &mirrorsv1alpha2.AgeSpec{IdentitySecretRef: ref},
+			reason: "AgeIdentityMissing",
+		},
+		{
+			name:    "identity key missing in the secret",
+			objects: []client.Object{testAgeIdentitySecret("default", "age-identity", identity)},
+			spec:    &mirrorsv1alpha2.AgeSpec{IdentitySecretRef: ref, IdentityKey: "keys.txt"},
+			reason:  "AgeIdentityInvalid",
+		},
+		{
+			name:    "source secret not found",
+			options: Options{AgeIdentitySecret: "mirrors-system/age-identity"},
+			objects: []client.Object{testAgeIdentitySecret("mirrors-system", "age-identity", identity)},
+			spec:    &mirrorsv1alpha2.AgeSpec{},
+			reason:  "NoSecret",
+		},
+		{
+			name:    "source configmap not found",
+			objects: []client.Object{testAgeIdentitySecret("default", "age-identity", identity)},
+			spec:    &mirrorsv1alpha2.AgeSpec{IdentitySecretRef: ref, Kind: mirrorsv1alpha2.AgeSourceKindConfigMap},
+			reason:  "NoConfigMap",
+		},
+	} {
+		b := testBackend(t, test.options, test.objects...)
+		_, err := retrieveAge(ctx, b, testAgeMirror(test.spec))
+		if result, ok := err.(*reconresult.ReconcileResult); !ok || result.EventReason != test.reason {
+			t.Fatalf("%s: expected %s, got %v", test.name, test.reason, err)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
+	mirrorsv1alpha2 "github.com/ktsstudio/mirrors/api/v1alpha2"
+	"github.com/ktsstudio/mirrors/pkg/reconresult"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	awsRequestTimeout = 30 * time.Second
+
+	// web identity credentials are refreshed this long before they expire
+	awsCredentialsExpiryMargin = 5 * time.Minute
+)
+
+func isAWSErrorCode(err error, code string) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == code
+}
+
+// awsClient calls Secrets Manager and SSM of a region
+type awsClient struct {
+	secretsManager *secretsmanager.Client
+	ssm            *ssm.Client
+}
+
+// awsCredentialsCache keeps credentials obtained with a web identity token until they are about to expire
+type awsCredentialsCache struct {
+	mutex       sync.Mutex
+	credentials map[string]*aws.CredentialsCache
+}
+
+func makeAWSCredentialsCache() *awsCredentialsCache {
+	return &awsCredentialsCache{credentials: make(map[string]*aws.CredentialsCache)}
+}
+
+// makeAWS returns a client of the region of spec authenticated with static keys or a web identity
+func (b *SecretMirrorBackend) makeAWS(ctx context.Context, spec *mirrorsv1alpha2.AWSSpec) (*awsClient, error) {
+	config := aws.Config{
+		Region:     spec.Region,
+		HTTPClient: awshttp.NewBuildableClient().WithTimeout(awsRequestTimeout),
+	}
+
+	if spec.Auth.SecretRef != nil {
+		creds, err := b.staticAWSCredentials(ctx, &spec.Auth)
+		if err != nil {
+			return nil, err
+		}
+		config.Credentials = creds
+	} else {
+		creds, err := b.webIdentityAWSCredentials(ctx, config, spec)
+		if err != nil {
+			return nil, &reconresult.ReconcileResult{
+				Message:     fmt.Sprintf("error assuming aws role with web identity: %s", err),
+				Status:      mirrorsv1alpha2.MirrorStatusError,
+				EventType:   v1.EventTypeWarning,
+				EventReason: "AWSAuthInvalid",
+			}
+		}
+		config.Credentials = creds
+	}
+
+	return &awsClient{
+		secretsManager: secretsmanager.NewFromConfig(config, func(o *secretsmanager.Options) {
+			if spec.Endpoint != "" {
+				o.EndpointResolver = secretsmanager.EndpointResolverFromURL(spec.Endpoint)
+			}
+		}),
+		ssm: ssm.NewFromConfig(config, func(o *ssm.Options) {
+			if spec.Endpoint != "" {
+				o.EndpointResolver = ssm.EndpointResolverFromURL(spec.Endpoint)
+			}
+		}),
+	}, nil
+}
+
+func (b *SecretMirrorBackend) staticAWSCredentials(ctx context.Context, auth *mirrorsv1alpha2.AWSAuthSpec) (aws.CredentialsProvider, error) {
+	secretName := types.NamespacedName{
+		Namespace: auth.SecretRef.Namespace,
+		Name:      auth.SecretRef.Name,
+	}
+	secret, err := FetchSecret(ctx, b, secretName)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil {
+		return nil, &reconresult.ReconcileResult{
+			Message:     fmt.Sprintf("secret %s with aws credentials not found", secretName),
+			Status:      mirrorsv1alpha2.MirrorStatusPending,
+			EventType:   v1.EventTypeWarning,
+			EventReason: "AWSAuthMissing",
+		}
+	}
+
+	accessKeyID := string(secret.Data[auth.AccessKeyIDKey])
+	secretAccessKey := string(secret.Data[auth.SecretAccessKeyKey])
+	if accessKeyID == "" || secretAccessKey == "" {
+		return nil, &reconresult.ReconcileResult{
+			Message: fmt.Sprintf("cannot find access keys under secret %s and keys %s, %s",
+				secretName, auth.AccessKeyIDKey, auth.SecretAccessKeyKey),
+			Status:      mirrorsv1alpha2.MirrorStatusPending,
+			EventType:   v1.EventTypeWarning,
+			EventReason: "AWSAuthMissing",
+		}
+	}
+	return credentials.NewStaticCredentialsProvider(accessKeyID, secretAccessKey, string(secret.Data[auth.SessionTokenKey])), nil
+}
+
+// webIdentityAWSCredentials assumes a role with the projected service account token of the controller (IRSA).
+// Credentials are shared by mirrors assuming the same role and retrieved once to report a failure early
+func (b *SecretMirrorBackend) webIdentityAWSCredentials(ctx context.Context, config aws.Config, spec *mirrorsv1alpha2.AWSSpec) (aws.CredentialsProvider, error) {
+	roleARN := spec.Auth.RoleARN
+	if roleARN == "" {
+		roleARN = os.Getenv("AWS_ROLE_ARN")
+	}
+	tokenFile := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+	if roleARN == "" || tokenFile == "" {
+		return nil, errors.New("no aws.auth.secretRef and no web identity (AWS_ROLE_ARN, AWS_WEB_IDENTITY_TOKEN_FILE) configured")
+	}
+
+	cacheKey := spec.Region + "|" + spec.Endpoint + "|" + roleARN
+	b.awsCredentials.mutex.Lock()
+	creds, ok := b.awsCredentials.credentials[cacheKey]
+	if !ok {
+		stsClient := sts.NewFromConfig(config, func(o *sts.Options) {
+			if spec.Endpoint != "" {
+				o.EndpointResolver = sts.EndpointResolverFromURL(spec.Endpoint)
+			}
+		})
+		provider := stscreds.NewWebIdentityRoleProvider(stsClient, roleARN, stscreds.IdentityTokenFile(tokenFile),
+			func(o *stscreds.WebIdentityRoleOptions) {
+				o.RoleSessionName = "mirrors"
+			})
+		creds = aws.NewCredentialsCache(provider, func(o *aws.CredentialsCacheOptions) {
+			o.ExpiryWindow = awsCredentialsExpiryMargin
+		})
+		b.awsCredentials.credentials[cacheKey] = creds
+	}
+	b.awsCredentials.mutex.Unlock()
+
+	if _, err := creds.Retrieve(ctx); err != nil {
+		return nil, err
+	}
+	return creds, nil
+}
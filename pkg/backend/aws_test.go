@@ -0,0 +1,360 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	mirrorsv1alpha2 "github.com/ktsstudio/mirrors/api/v1alpha2"
+	"github.com/ktsstudio/mirrors/pkg/reconresult"
+	"golang.org/x/time/rate"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeAWS serves Secrets Manager and SSM JSON APIs from memory
+type fakeAWS struct {
+	t          *testing.T
+	secrets    map[string]string
+	parameters map[string]string
+	// secret ids and parameter names answered with AccessDeniedException
+	denied map[string]bool
+	calls  []string
+}
+
+func newFakeAWS(t *testing.T) (*fakeAWS, *httptest.Server) {
+	f := &fakeAWS{
+		t:          t,
+		secrets:    make(map[string]string),
+		parameters: make(map[string]string),
+		denied:     make(map[string]bool),
+	}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	return f, server
+}
+
+func (f *fakeAWS) reply(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func (f *fakeAWS) fail(w http.ResponseWriter, code, message string) {
+	f.reply(w, http.StatusBadRequest, map[string]string{"__type": code, "message": message})
+}
+
+func (f *fakeAWS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	target := r.Header.Get("X-Amz-Target")
+	f.calls = append(f.calls, target)
+
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/") || !strings.Contains(auth, "/eu-west-1/") {
+		f.fail(w, "UnrecognizedClientException", "unexpected authorization "+auth)
+		return
+	}
+	if r.Header.Get("X-Amz-Security-Token") != "session" {
+		f.fail(w, "UnrecognizedClientException", "no session token")
+		return
+	}
+
+	var in struct {
+		SecretId, Name, SecretString, Value, Path, NextToken string
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		f.fail(w, "SerializationException", err.Error())
+		return
+	}
+	if f.denied[in.SecretId+in.Name] {
+		f.fail(w, "AccessDeniedException", "access denied")
+		return
+	}
+
+	switch target {
+	case "secretsmanager.GetSecretValue":
+		value, ok := f.secrets[in.SecretId]
+		if !ok {
+			f.fail(w, "ResourceNotFoundException", "Secrets Manager can't find the specified secret.")
+			return
+		}
+		f.reply(w, http.StatusOK, map[string]string{"Name": in.SecretId, "SecretString": value})
+	case "secretsmanager.CreateSecret":
+		if _, ok := f.secrets[in.Name]; ok {
+			f.fail(w, "ResourceExistsException", "the secret already exists")
+			return
+		}
+		f.secrets[in.Name] = in.SecretString
+		f.reply(w, http.StatusOK, map[string]string{"Name": in.Name})
+	case "secretsmanager.PutSecretValue":
+		if _, ok := f.secrets[in.SecretId]; !ok {
+			f.fail(w, "ResourceNotFoundException", "Secrets Manager can't find the specified secret.")
+			return
+		}
+		f.secrets[in.SecretId] = in.SecretString
+		f.reply(w, http.StatusOK, map[string]string{"Name": in.SecretId})
+	case "AmazonSSM.GetParameter":
+		value, ok := f.parameters[in.Name]
+		if !ok {
+			f.fail(w, "ParameterNotFound", "")
+			return
+		}
+		f.reply(w, http.StatusOK, map[string]interface{}{"Parameter": map[string]string{"Name": in.Name, "Value": value}})
+	case "AmazonSSM.GetParametersByPath":
+		// one parameter per page
+		var names []string
+		for name := range f.parameters {
+			if strings.HasPrefix(name, in.Path) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		page, _ := strconv.Atoi(in.NextToken)
+		out := map[string]interface{}{"Parameters": []interface{}{}}
+		if page < len(names) {
+			out["Parameters"] = []interface{}{map[string]string{"Name": names[page], "Value": f.parameters[names[page]]}}
+		}
+		if page+1 < len(names) {
+			out["NextToken"] = strconv.Itoa(page + 1)
+		}
+		f.reply(w, http.StatusOK, out)
+	case "AmazonSSM.PutParameter":
+		f.parameters[in.Name] = in.Value
+		f.reply(w, http.StatusOK, map[string]int{"Version": 1})
+	default:
+		f.fail(w, "UnknownOperationException", target)
+	}
+}
+
+// testAWSSpec returns a spec pointing at server, authenticated with static keys of the aws secret
+func testAWSSpec(server *httptest.Server, service mirrorsv1alpha2.AWSService) *mirrorsv1alpha2.AWSSpec {
+	spec := &mirrorsv1alpha2.AWSSpec{
+		Service:  service,
+		Region:   "eu-west-1",
+		Endpoint: server.URL,
+		Auth:     mirrorsv1alpha2.AWSAuthSpec{SecretRef: &v1.SecretReference{Name: "aws"}},
+	}
+	spec.Default("default")
+	return spec
+}
+
+func testAWSCredentials() *v1.Secret {
+	return &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "aws"},
+		Data: map[string][]byte{
+			"accessKeyId":     []byte("AKID"),
+			"secretAccessKey": []byte("SECRET"),
+			"sessionToken":    []byte("session"),
+		},
+	}
+}
+
+func testAWSSource(t *testing.T, spec *mirrorsv1alpha2.AWSSpec) *AWSSecretSource {
+	b := testBackend(t, Options{}, testAWSCredentials())
+	client, err := b.makeAWS(context.Background(), spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mirror := testMirror("", "")
+	mirror.Spec.Source.Type = mirrorsv1alpha2.SourceTypeAWS
+	mirror.Spec.Source.AWS = spec
+	return &AWSSecretSource{mirror: mirror, aws: client}
+}
+
+func testAWSDest(t *testing.T, spec *mirrorsv1alpha2.AWSSpec) *AWSSecretDest {
+	b := testBackend(t, Options{}, testAWSCredentials())
+	client, err := b.makeAWS(context.Background(), spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mirror := testMirror("", "")
+	mirror.Spec.Destination.Type = mirrorsv1alpha2.DestTypeAWS
+	mirror.Spec.Destination.AWS = spec
+	return &AWSSecretDest{mirror: mirror, aws: client, writeLimiter: rate.NewLimiter(rate.Inf, 1)}
+}
+
+func reconcileStatus(err error) mirrorsv1alpha2.MirrorStatus {
+	if result, ok := err.(*reconresult.ReconcileResult); ok {
+		return result.Status
+	}
+	return ""
+}
+
+func TestAWSSecretsManagerSource(t *testing.T) {
+	ctx := context.Background()
+	f, server := newFakeAWS(t)
+	f.secrets["db"] = `{"user":"app","port":5432,"tls":{"enabled":true}}`
+	f.secrets["token"] = "plain token"
+
+	spec := testAWSSpec(server, mirrorsv1alpha2.AWSServiceSecretsManager)
+	spec.SecretID = "db"
+	s := testAWSSource(t, spec)
+
+	secret, err := s.Retrieve(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"user": "app", "port": "5432", "tls": `{"enabled":true}`}
+	if len(secret.Data) != len(expected) {
+		t.Fatalf("unexpected data %q", secret.Data)
+	}
+	for k, v := range expected {
+		if string(secret.Data[k]) != v {
+			t.Fatalf("unexpected value of %s: %q", k, secret.Data[k])
+		}
+	}
+
+	spec.SecretID = "token"
+	secret, err = s.Retrieve(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secret.Data) != 1 || string(secret.Data[awsValueKey]) != "plain token" {
+		t.Fatalf("a plain value is not stored under %s: %q", awsValueKey, secret.Data)
+	}
+
+	spec.SecretID = "missing"
+	if _, err := s.Retrieve(ctx); reconcileStatus(err) != mirrorsv1alpha2.MirrorStatusPending {
+		t.Fatalf("expected a missing secret to leave a mirror pending, got %v", err)
+	}
+
+	f.denied["db"] = true
+	spec.SecretID = "db"
+	_, err = s.Retrieve(ctx)
+	if reconcileStatus(err) != mirrorsv1alpha2.MirrorStatusError || !strings.Contains(err.Error(), "AccessDeniedException") {
+		t.Fatalf("expected an access error, got %v", err)
+	}
+}
+
+func TestAWSParameterStoreSource(t *testing.T) {
+	ctx := context.Background()
+	f, server := newFakeAWS(t)
+	f.parameters["/app/db"] = `{"user":"app"}`
+	f.parameters["/app/env/user"] = "app"
+	f.parameters["/app/env/tls/ca"] = "cert"
+	f.parameters["/other/user"] = "other"
+
+	spec := testAWSSpec(server, mirrorsv1alpha2.AWSServiceParameterStore)
+	spec.ParameterPath = "/app/db"
+	s := testAWSSource(t, spec)
+
+	secret, err := s.Retrieve(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secret.Data) != 1 || string(secret.Data["user"]) != "app" {
+		t.Fatalf("unexpected data %q", secret.Data)
+	}
+
+	spec.ParameterPath = "/app/env/"
+	secret, err = s.Retrieve(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secret.Data) != 2 || string(secret.Data["user"]) != "app" || string(secret.Data["tls.ca"]) != "cert" {
+		t.Fatalf("unexpected data of parameters under a path %q", secret.Data)
+	}
+
+	spec.ParameterPath = "/app/missing"
+	if _, err := s.Retrieve(ctx); reconcileStatus(err) != mirrorsv1alpha2.MirrorStatusPending {
+		t.Fatalf("expected a missing parameter to leave a mirror pending, got %v", err)
+	}
+}
+
+func TestAWSSecretsManagerDest(t *testing.T) {
+	ctx := context.Background()
+	f, server := newFakeAWS(t)
+	spec := testAWSSpec(server, mirrorsv1alpha2.AWSServiceSecretsManager)
+	spec.SecretID = "copy"
+	d := testAWSDest(t, spec)
+
+	plan, err := d.Plan(ctx, testSourceSecret("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan[0].Action != mirrorsv1alpha2.PlanActionCreate {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+
+	if err := d.Sync(ctx, testSourceSecret("secret")); err != nil {
+		t.Fatal(err)
+	}
+	if f.secrets["copy"] != `{"password":"secret"}` {
+		t.Fatalf("unexpected secret %q", f.secrets["copy"])
+	}
+
+	f.calls = nil
+	if err := d.Sync(ctx, testSourceSecret("secret")); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.calls) != 1 || f.calls[0] != "secretsmanager.GetSecretValue" {
+		t.Fatalf("an identical secret is written: %q", f.calls)
+	}
+
+	if err := d.Sync(ctx, testSourceSecret("rotated")); err != nil {
+		t.Fatal(err)
+	}
+	if f.secrets["copy"] != `{"password":"rotated"}` {
+		t.Fatalf("secret has not been updated: %q", f.secrets["copy"])
+	}
+
+	f.denied["copy"] = true
+	if err := d.Sync(ctx, testSourceSecret("again")); reconcileStatus(err) != mirrorsv1alpha2.MirrorStatusError {
+		t.Fatalf("expected an error, got %v", err)
+	}
+}
+
+func TestAWSParameterStoreDest(t *testing.T) {
+	ctx := context.Background()
+	f, server := newFakeAWS(t)
+	f.parameters["/app/password"] = "secret"
+	f.parameters["/app/retained"] = "kept"
+
+	spec := testAWSSpec(server, mirrorsv1alpha2.AWSServiceParameterStore)
+	spec.ParameterPath = "/app/"
+	d := testAWSDest(t, spec)
+
+	source := testSourceSecret("secret")
+	source.Data["user"] = []byte("app")
+	plan, err := d.Plan(ctx, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan[0].Action != mirrorsv1alpha2.PlanActionUpdate || len(plan[0].ChangedKeys) != 1 || plan[0].ChangedKeys[0] != "user" {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+
+	f.calls = nil
+	if err := d.Sync(ctx, source); err != nil {
+		t.Fatal(err)
+	}
+	var puts int
+	for _, call := range f.calls {
+		if call == "AmazonSSM.PutParameter" {
+			puts++
+		}
+	}
+	if puts != 1 || f.parameters["/app/user"] != "app" || f.parameters["/app/retained"] != "kept" {
+		t.Fatalf("unexpected writes %q of parameters %q", f.calls, f.parameters)
+	}
+
+	spec.ParameterPath = "/single"
+	if err := d.Sync(ctx, source); err != nil {
+		t.Fatal(err)
+	}
+	if f.parameters["/single"] != `{"password":"secret","user":"app"}` {
+		t.Fatalf("unexpected parameter %q", f.parameters["/single"])
+	}
+}
+
+func TestAWSAuthMissing(t *testing.T) {
+	_, server := newFakeAWS(t)
+	b := testBackend(t, Options{})
+	_, err := b.makeAWS(context.Background(), testAWSSpec(server, mirrorsv1alpha2.AWSServiceSecretsManager))
+	if result, ok := err.(*reconresult.ReconcileResult); !ok || result.EventReason != "AWSAuthMissing" {
+		t.Fatalf("expected missing credentials, got %v", err)
+	}
+}
@@ -9,11 +9,24 @@ var (
 	ownedByMirrorAnnotation      = "mirrors.kts.studio/owned-by"
 	lastSyncAnnotation           = "mirrors.kts.studio/last-sync-at"
 	parentVersionAnnotation      = "mirrors.kts.studio/parent-version"
+	contentHashAnnotation        = "mirrors.kts.studio/content-hash"
+	driftReportedAnnotation      = "mirrors.kts.studio/drift-reported"
 	sourceTypeAnnotation         = "mirrors.kts.studio/source-type"
 	vaultPathAnnotation          = "mirrors.kts.studio/vault-path"
 	vaultLeaseIdAnnotation       = "mirrors.kts.studio/vault-lease-id"
 	vaultLeaseDurationAnnotation = "mirrors.kts.studio/vault-lease-duration"
+	syncRequestedAtAnnotation    = "mirrors.kts.studio/sync-requested-at"
 	mirrorsFinalizerName         = "mirrors.kts.studio/finalizer"
+
+	// serial number of a certificate issued by Vault PKI kept in a Secret named <mirror>.vault-pki
+	vaultCertificateSerialAnnotation = "mirrors.kts.studio/vault-certificate-serial"
+	vaultCertificateSecretSuffix     = ".vault-pki"
+
+	// KV v2 custom metadata key recording how values of a secret written to Vault are encoded
+	vaultEncodingMetadataKey = "mirrors.kts.studio/encoding"
+
+	// prefix of a pod template annotation holding a hash of a mirrored secret, followed by the secret name
+	rolloutChecksumAnnotationPrefix = "checksum.mirrors.kts.studio/"
 )
 
 const (
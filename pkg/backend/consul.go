@@ -0,0 +1,150 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"github.com/hashicorp/consul/api"
+	mirrorsv1alpha2 "github.com/ktsstudio/mirrors/api/v1alpha2"
+	"github.com/ktsstudio/mirrors/pkg/reconresult"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"strings"
+	"time"
+)
+
+const consulRequestTimeout = 30 * time.Second
+
+// consulClient calls the KV endpoints of the Consul HTTP API
+type consulClient struct {
+	kv *api.KV
+}
+
+func consulQuery(ctx context.Context) *api.QueryOptions {
+	return (&api.QueryOptions{}).WithContext(ctx)
+}
+
+func consulWrite(ctx context.Context) *api.WriteOptions {
+	return (&api.WriteOptions{}).WithContext(ctx)
+}
+
+// list returns every key under prefix, nil when there are none
+func (c *consulClient) list(ctx context.Context, prefix string) (api.KVPairs, error) {
+	ctx, cancel := context.WithTimeout(ctx, consulRequestTimeout)
+	defer cancel()
+	pairs, _, err := c.kv.List(prefix, consulQuery(ctx))
+	return pairs, err
+}
+
+// put writes a key if its ModifyIndex is still cas (0 - the key must not exist). It reports whether the key was written
+func (c *consulClient) put(ctx context.Context, key string, value []byte, cas uint64) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, consulRequestTimeout)
+	defer cancel()
+	ok, _, err := c.kv.CAS(&api.KVPair{Key: key, Value: value, ModifyIndex: cas}, consulWrite(ctx))
+	return ok, err
+}
+
+// delete removes a key if its ModifyIndex is still cas. It reports whether the key was removed
+func (c *consulClient) delete(ctx context.Context, key string, cas uint64) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, consulRequestTimeout)
+	defer cancel()
+	ok, _, err := c.kv.DeleteCAS(&api.KVPair{Key: key, ModifyIndex: cas}, consulWrite(ctx))
+	return ok, err
+}
+
+// deleteTree removes every key under prefix
+func (c *consulClient) deleteTree(ctx context.Context, prefix string) error {
+	ctx, cancel := context.WithTimeout(ctx, consulRequestTimeout)
+	defer cancel()
+	_, err := c.kv.DeleteTree(prefix, consulWrite(ctx))
+	return err
+}
+
+// consulKeyPrefix returns a prefix of keys in Consul KV with a trailing slash
+func consulKeyPrefix(prefix string) string {
+	return strings.Trim(prefix, "/") + "/"
+}
+
+// consulData maps keys under prefix to secret keys, nested keys are joined with .
+func consulData(pairs api.KVPairs, prefix string) (map[string][]byte, map[string]*api.KVPair) {
+	data := make(map[string][]byte, len(pairs))
+	byKey := make(map[string]*api.KVPair, len(pairs))
+	for _, pair := range pairs {
+		if strings.HasSuffix(pair.Key, "/") {
+			// a folder
+			continue
+		}
+		key := strings.ReplaceAll(strings.TrimPrefix(pair.Key, prefix), "/", ".")
+		data[key] = pair.Value
+		if data[key] == nil {
+			data[key] = []byte{}
+		}
+		byKey[key] = pair
+	}
+	return data, byKey
+}
+
+// makeConsul returns a client of spec authenticated with a token from spec.auth.secretRef
+func (b *SecretMirrorBackend) makeConsul(ctx context.Context, spec *mirrorsv1alpha2.ConsulSpec) (*consulClient, error) {
+	config := &api.Config{
+		Address:    spec.Addr,
+		Datacenter: spec.Datacenter,
+	}
+	if spec.Auth.SecretRef != nil {
+		token, err := b.consulToken(ctx, &spec.Auth)
+		if err != nil {
+			return nil, err
+		}
+		config.Token = token
+	}
+
+	client, err := api.NewClient(config)
+	if err != nil {
+		return nil, &reconresult.ReconcileResult{
+			Message:     fmt.Sprintf("invalid consul address %s: %s", spec.Addr, err),
+			Status:      mirrorsv1alpha2.MirrorStatusError,
+			EventType:   v1.EventTypeWarning,
+			EventReason: "ConsulError",
+		}
+	}
+	return &consulClient{kv: client.KV()}, nil
+}
+
+// consulToken reads an ACL token from auth.secretRef
+func (b *SecretMirrorBackend) consulToken(ctx context.Context, auth *mirrorsv1alpha2.ConsulAuthSpec) (string, error) {
+	secretName := types.NamespacedName{
+		Namespace: auth.SecretRef.Namespace,
+		Name:      auth.SecretRef.Name,
+	}
+	secret, err := FetchSecret(ctx, b, secretName)
+	if err != nil {
+		return "", err
+	}
+	if secret == nil {
+		return "", &reconresult.ReconcileResult{
+			Message:     fmt.Sprintf("secret %s with consul token not found", secretName),
+			Status:      mirrorsv1alpha2.MirrorStatusPending,
+			EventType:   v1.EventTypeWarning,
+			EventReason: "ConsulAuthMissing",
+		}
+	}
+	token, ok := secret.Data[auth.TokenKey]
+	if !ok || len(token) == 0 {
+		return "", &reconresult.ReconcileResult{
+			Message:     fmt.Sprintf("cannot find consul token under secret %s and key %s", secretName, auth.TokenKey),
+			Status:      mirrorsv1alpha2.MirrorStatusPending,
+			EventType:   v1.EventTypeWarning,
+			EventReason: "ConsulAuthMissing",
+		}
+	}
+	return strings.TrimSpace(string(token)), nil
+}
+
+// consulError reports a Consul API error
+func consulError(message string, err error) error {
+	return &reconresult.ReconcileResult{
+		Message:     fmt.Sprintf("%s: %s", message, err),
+		Status:      mirrorsv1alpha2.MirrorStatusError,
+		EventType:   v1.EventTypeWarning,
+		EventReason: "ConsulError",
+	}
+}
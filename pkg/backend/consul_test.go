@@ -0,0 +1,307 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	mirrorsv1alpha2 "github.com/ktsstudio/mirrors/api/v1alpha2"
+	"github.com/ktsstudio/mirrors/pkg/reconresult"
+	"golang.org/x/time/rate"
+	"io"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type fakeConsulPair struct {
+	value       []byte
+	modifyIndex uint64
+}
+
+// fakeConsul serves Consul KV from memory
+type fakeConsul struct {
+	t     *testing.T
+	index uint64
+	keys  map[string]*fakeConsulPair
+	// runs before a write is checked, e.g. to modify a key concurrently
+	beforeWrite func()
+	calls       []string
+	tokens      []string
+}
+
+func newFakeConsul(t *testing.T) (*fakeConsul, *httptest.Server) {
+	f := &fakeConsul{t: t, keys: make(map[string]*fakeConsulPair)}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	return f, server
+}
+
+func (f *fakeConsul) set(key, value string) {
+	f.index++
+	f.keys[key] = &fakeConsulPair{value: []byte(value), modifyIndex: f.index}
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+	query := r.URL.Query()
+	f.calls = append(f.calls, r.Method+" "+key)
+	f.tokens = append(f.tokens, r.Header.Get("X-Consul-Token"))
+	if query.Get("dc") != "dc2" {
+		http.Error(w, "unexpected datacenter "+query.Get("dc"), http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		var names []string
+		for name := range f.keys {
+			if strings.HasPrefix(name, key) {
+				names = append(names, name)
+			}
+		}
+		if len(names) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		sort.Strings(names)
+		pairs := make([]map[string]interface{}, 0, len(names))
+		for _, name := range names {
+			pairs = append(pairs, map[string]interface{}{
+				"Key":         name,
+				"Value":       f.keys[name].value,
+				"ModifyIndex": f.keys[name].modifyIndex,
+			})
+		}
+		_ = json.NewEncoder(w).Encode(pairs)
+	case http.MethodPut, http.MethodDelete:
+		if f.beforeWrite != nil {
+			f.beforeWrite()
+		}
+		if query.Has("recurse") {
+			for name := range f.keys {
+				if strings.HasPrefix(name, key) {
+					delete(f.keys, name)
+				}
+			}
+			_, _ = io.WriteString(w, "true")
+			return
+		}
+
+		cas, _ := strconv.ParseUint(query.Get("cas"), 10, 64)
+		var current uint64
+		if pair, ok := f.keys[key]; ok {
+			current = pair.modifyIndex
+		}
+		if cas != current {
+			_, _ = io.WriteString(w, "false")
+			return
+		}
+		if r.Method == http.MethodDelete {
+			delete(f.keys, key)
+		} else {
+			value, _ := io.ReadAll(r.Body)
+			f.set(key, string(value))
+		}
+		_, _ = io.WriteString(w, "true")
+	}
+}
+
+// writes returns PUT and DELETE calls
+func (f *fakeConsul) writes() []string {
+	var writes []string
+	for _, call := range f.calls {
+		if !strings.HasPrefix(call, http.MethodGet) {
+			writes = append(writes, call)
+		}
+	}
+	return writes
+}
+
+func testConsulSpec(server *httptest.Server) *mirrorsv1alpha2.ConsulSpec {
+	spec := &mirrorsv1alpha2.ConsulSpec{
+		Addr:       server.URL,
+		Datacenter: "dc2",
+		Prefix:     "apps/db",
+		Auth:       mirrorsv1alpha2.ConsulAuthSpec{SecretRef: &v1.SecretReference{Name: "consul"}},
+	}
+	spec.Default("default")
+	return spec
+}
+
+func testConsulDest(t *testing.T, spec *mirrorsv1alpha2.ConsulSpec, deletePolicy mirrorsv1alpha2.DeletePolicyType) *ConsulSecretDest {
+	token := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "consul"},
+		Data:       map[string][]byte{"token": []byte("acl-token\n")},
+	}
+	client, err := testBackend(t, Options{}, token).makeConsul(context.Background(), spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mirror := testMirror("", "")
+	mirror.Spec.DeletePolicy = deletePolicy
+	mirror.Spec.Destination.Type = mirrorsv1alpha2.DestTypeConsul
+	mirror.Spec.Destination.Consul = spec
+	return &ConsulSecretDest{mirror: mirror, consul: client, writeLimiter: rate.NewLimiter(rate.Inf, 1)}
+}
+
+func TestConsulDestSync(t *testing.T) {
+	ctx := context.Background()
+	f, server := newFakeConsul(t)
+	d := testConsulDest(t, testConsulSpec(server), mirrorsv1alpha2.DeletePolicyDelete)
+
+	// nothing is stored yet: consul answers 404
+	plan, err := d.Plan(ctx, testSourceSecret("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan[0].Action != mirrorsv1alpha2.PlanActionCreate {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+
+	source := testSourceSecret("secret")
+	source.Data["user"] = []byte("app")
+	if err := d.Sync(ctx, source); err != nil {
+		t.Fatal(err)
+	}
+	if string(f.keys["apps/db/password"].value) != "secret" || string(f.keys["apps/db/user"].value) != "app" {
+		t.Fatalf("unexpected keys %v", f.keys)
+	}
+	for _, token := range f.tokens {
+		if token != "acl-token" {
+			t.Fatalf("unexpected token %q", token)
+		}
+	}
+
+	f.calls = nil
+	if err := d.Sync(ctx, source); err != nil {
+		t.Fatal(err)
+	}
+	if writes := f.writes(); len(writes) != 0 {
+		t.Fatalf("identical keys are written: %q", writes)
+	}
+
+	// a changed key is written and a removed key is deleted
+	f.calls = nil
+	if err := d.Sync(ctx, testSourceSecret("rotated")); err != nil {
+		t.Fatal(err)
+	}
+	if writes := f.writes(); len(writes) != 2 || writes[0] != "PUT apps/db/password" || writes[1] != "DELETE apps/db/user" {
+		t.Fatalf("unexpected writes %q", writes)
+	}
+	if _, ok := f.keys["apps/db/user"]; ok || string(f.keys["apps/db/password"].value) != "rotated" {
+		t.Fatalf("unexpected keys %v", f.keys)
+	}
+}
+
+func TestConsulDestConflict(t *testing.T) {
+	ctx := context.Background()
+	f, server := newFakeConsul(t)
+	f.set("apps/db/password", "secret")
+	d := testConsulDest(t, testConsulSpec(server), mirrorsv1alpha2.DeletePolicyDelete)
+
+	// someone else changes the key between the read and the write
+	f.beforeWrite = func() {
+		f.set("apps/db/password", "concurrent")
+		f.beforeWrite = nil
+	}
+	err := d.Sync(ctx, testSourceSecret("rotated"))
+	if result, ok := err.(*reconresult.ReconcileResult); !ok || result.EventReason != "ConsulConflict" {
+		t.Fatalf("expected a conflict, got %v", err)
+	}
+	if string(f.keys["apps/db/password"].value) != "concurrent" {
+		t.Fatal("a concurrent change has been overwritten")
+	}
+
+	// a new key is only created if it still does not exist
+	f.beforeWrite = func() {
+		f.set("apps/db/user", "concurrent")
+		f.beforeWrite = nil
+	}
+	source := testSourceSecret("concurrent")
+	source.Data["user"] = []byte("app")
+	err = d.Sync(ctx, source)
+	if result, ok := err.(*reconresult.ReconcileResult); !ok || result.EventReason != "ConsulConflict" {
+		t.Fatalf("expected a conflict, got %v", err)
+	}
+}
+
+func TestConsulDestCleanup(t *testing.T) {
+	ctx := context.Background()
+	f, server := newFakeConsul(t)
+	f.set("apps/db/password", "secret")
+	f.set("apps/other", "kept")
+
+	d := testConsulDest(t, testConsulSpec(server), mirrorsv1alpha2.DeletePolicyRetain)
+	if err := d.Cleanup(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.calls) != 0 || len(f.keys) != 2 {
+		t.Fatalf("keys are deleted with deletePolicy retain: %q", f.calls)
+	}
+
+	d = testConsulDest(t, testConsulSpec(server), mirrorsv1alpha2.DeletePolicyDelete)
+	if err := d.Cleanup(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.calls) != 1 || f.calls[0] != "DELETE apps/db/" {
+		t.Fatalf("unexpected calls %q", f.calls)
+	}
+	if _, ok := f.keys["apps/other"]; !ok || len(f.keys) != 1 {
+		t.Fatalf("unexpected keys left %v", f.keys)
+	}
+
+	// copies of secrets no longer in a multi-source are pruned
+	f.calls = nil
+	f.set("apps/db/gone/password", "secret")
+	d.mirror.Spec.Source.Type = mirrorsv1alpha2.SourceTypeSecret
+	d.mirror.Spec.Source.Selector = &metav1.LabelSelector{}
+	if err := d.Prune(ctx, []string{"gone"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.calls) != 1 || f.calls[0] != "DELETE apps/db/gone/" {
+		t.Fatalf("unexpected calls %q", f.calls)
+	}
+}
+
+func TestConsulSourceNotFound(t *testing.T) {
+	ctx := context.Background()
+	f, server := newFakeConsul(t)
+	spec := testConsulSpec(server)
+	spec.Auth = mirrorsv1alpha2.ConsulAuthSpec{}
+	client, err := testBackend(t, Options{}).makeConsul(ctx, spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mirror := testMirror("", "")
+	mirror.Spec.Source.Consul = spec
+	s := &ConsulSecretSource{mirror: mirror, consul: client}
+
+	_, err = s.Retrieve(ctx)
+	if result, ok := err.(*reconresult.ReconcileResult); !ok || result.Status != mirrorsv1alpha2.MirrorStatusActive {
+		t.Fatalf("expected nothing to sync on a missing prefix, got %v", err)
+	}
+	if len(f.tokens) != 1 || f.tokens[0] != "" {
+		t.Fatalf("a token is sent without auth: %q", f.tokens)
+	}
+
+	f.set("apps/db/tls/ca", "cert")
+	secret, err := s.Retrieve(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secret.Data) != 1 || string(secret.Data["tls.ca"]) != "cert" {
+		t.Fatalf("unexpected data %q", secret.Data)
+	}
+}
+
+func TestConsulAuthMissing(t *testing.T) {
+	_, server := newFakeConsul(t)
+	_, err := testBackend(t, Options{}).makeConsul(context.Background(), testConsulSpec(server))
+	if result, ok := err.(*reconresult.ReconcileResult); !ok || result.EventReason != "ConsulAuthMissing" {
+		t.Fatalf("expected a missing token, got %v", err)
+	}
+}
@@ -0,0 +1,242 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	mirrorsv1alpha2 "github.com/ktsstudio/mirrors/api/v1alpha2"
+	"github.com/ktsstudio/mirrors/pkg/reconresult"
+	"golang.org/x/time/rate"
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+func init() {
+	RegisterDest(DestRegistration{
+		Type: mirrorsv1alpha2.DestTypeAWS,
+		Default: func(mirror *mirrorsv1alpha2.SecretMirror) {
+			if mirror.Spec.Destination.AWS != nil {
+				mirror.Spec.Destination.AWS.Default(mirror.Namespace)
+			}
+			mirror.Spec.DeletePolicy = mirrorsv1alpha2.DeletePolicyRetain
+		},
+		Validate: func(mirror *mirrorsv1alpha2.SecretMirror) error {
+			if mirror.Spec.Destination.AWS == nil {
+				return errors.New("destination.aws must be specified")
+			}
+			return mirror.Spec.Destination.AWS.Validate()
+		},
+		Factory: func(ctx context.Context, b *SecretMirrorBackend, mirror *mirrorsv1alpha2.SecretMirror) (DestSyncer, error) {
+			client, err := b.makeAWS(ctx, mirror.Spec.Destination.AWS)
+			if err != nil {
+				return nil, err
+			}
+			return &AWSSecretDest{
+				mirror:       mirror,
+				aws:          client,
+				writeLimiter: b.writeLimiter,
+			}, nil
+		},
+	})
+}
+
+type AWSSecretDest struct {
+	mirror       *mirrorsv1alpha2.SecretMirror
+	aws          *awsClient
+	writeLimiter *rate.Limiter
+}
+
+func (d *AWSSecretDest) Setup(ctx context.Context) error {
+	_ = ctx
+	return nil
+}
+
+// location returns a secret id or a parameter path to write a source secret to: <location>/<name> when a source yields many secrets
+func (d *AWSSecretDest) location(secret *v1.Secret) string {
+	spec := d.mirror.Spec.Destination.AWS
+	location := spec.SecretID
+	if spec.Service == mirrorsv1alpha2.AWSServiceParameterStore {
+		location = spec.ParameterPath
+	}
+	if !d.mirror.IsMultiSource() {
+		return location
+	}
+	if spec.IsParameterPrefix() {
+		return location + secret.Name + "/"
+	}
+	return location + "/" + secret.Name
+}
+
+// read returns data stored at a location, nil when nothing is stored yet
+func (d *AWSSecretDest) read(ctx context.Context, location string) (map[string][]byte, error) {
+	var data map[string][]byte
+	var err error
+	switch {
+	case d.mirror.Spec.Destination.AWS.Service != mirrorsv1alpha2.AWSServiceParameterStore:
+		data, err = getAWSSecretValue(ctx, d.aws, location)
+	case strings.HasSuffix(location, "/"):
+		data, err = getAWSParametersByPath(ctx, d.aws, location)
+	default:
+		data, err = getAWSParameter(ctx, d.aws, location)
+	}
+	if isAWSErrorCode(err, "ResourceNotFoundException") || isAWSErrorCode(err, "ParameterNotFound") {
+		return nil, nil
+	}
+	if err != nil || len(data) == 0 {
+		return nil, err
+	}
+	return data, nil
+}
+
+// changed returns keys to write. Parameters under a path are written one by one, so keys removed
+// from a source are retained there
+func (d *AWSSecretDest) changed(location string, src, dest map[string][]byte) []string {
+	if !strings.HasSuffix(location, "/") {
+		return changedKeys(src, dest)
+	}
+
+	var keys []string
+	for k, v := range src {
+		if current, ok := dest[k]; !ok || !bytes.Equal(v, current) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (d *AWSSecretDest) Sync(ctx context.Context, secret *v1.Secret) error {
+	logger := log.FromContext(ctx)
+
+	if len(secret.Data) == 0 {
+		return reconresult.Fmt("no data in source secret")
+	}
+	for k, v := range secret.Data {
+		if !utf8.Valid(v) {
+			return &reconresult.ReconcileResult{
+				Message:     fmt.Sprintf("value of key %s is not valid UTF-8 and cannot be written to aws", k),
+				Status:      mirrorsv1alpha2.MirrorStatusError,
+				EventType:   v1.EventTypeWarning,
+				EventReason: "InvalidAWSData",
+			}
+		}
+	}
+
+	location := d.location(secret)
+	current, err := d.read(ctx, location)
+	if err != nil {
+		return awsError(fmt.Sprintf("Error reading %s from aws", location), err)
+	}
+	keys := d.changed(location, secret.Data, current)
+	if len(keys) == 0 {
+		logger.Info(fmt.Sprintf("secrets %s/%s and <aws>/%s are identical",
+			secret.Namespace, secret.Name, location))
+		return nil
+	}
+
+	if err := d.writeLimiter.Wait(ctx); err != nil {
+		return err
+	}
+
+	if err := d.write(ctx, location, secret.Data, keys, current != nil); err != nil {
+		return awsError(fmt.Sprintf("Error syncing to aws %s", location), err)
+	}
+
+	logger.Info("successfully synced secret to aws")
+	return nil
+}
+
+// write stores a new version of a secret or of parameters. Missing secrets are created
+func (d *AWSSecretDest) write(ctx context.Context, location string, data map[string][]byte, keys []string, exists bool) error {
+	if strings.HasSuffix(location, "/") {
+		for _, k := range keys {
+			if err := putAWSParameter(ctx, d.aws, location+k, string(data[k])); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	object := make(map[string]string, len(data))
+	for k, v := range data {
+		object[k] = string(v)
+	}
+	value, err := json.Marshal(object)
+	if err != nil {
+		return err
+	}
+
+	if d.mirror.Spec.Destination.AWS.Service == mirrorsv1alpha2.AWSServiceParameterStore {
+		return putAWSParameter(ctx, d.aws, location, string(value))
+	}
+
+	if !exists {
+		_, err = d.aws.secretsManager.CreateSecret(ctx, &secretsmanager.CreateSecretInput{
+			Name:         aws.String(location),
+			SecretString: aws.String(string(value)),
+		})
+		if !isAWSErrorCode(err, "ResourceExistsException") {
+			return err
+		}
+		// the secret exists with no value yet
+	}
+	_, err = d.aws.secretsManager.PutSecretValue(ctx, &secretsmanager.PutSecretValueInput{
+		SecretId:     aws.String(location),
+		SecretString: aws.String(string(value)),
+	})
+	return err
+}
+
+func putAWSParameter(ctx context.Context, client *awsClient, name, value string) error {
+	_, err := client.ssm.PutParameter(ctx, &ssm.PutParameterInput{
+		Name:      aws.String(name),
+		Value:     aws.String(value),
+		Type:      ssmtypes.ParameterTypeSecureString,
+		Overwrite: aws.Bool(true),
+	})
+	return err
+}
+
+func (d *AWSSecretDest) Plan(ctx context.Context, secret *v1.Secret) ([]mirrorsv1alpha2.DestinationPlan, error) {
+	location := d.location(secret)
+	plan := mirrorsv1alpha2.DestinationPlan{
+		Destination: location,
+	}
+
+	current, err := d.read(ctx, location)
+	if err != nil {
+		return nil, err
+	}
+
+	plan.ChangedKeys = d.changed(location, secret.Data, current)
+	switch {
+	case current == nil:
+		plan.Action = mirrorsv1alpha2.PlanActionCreate
+	case len(plan.ChangedKeys) > 0:
+		plan.Action = mirrorsv1alpha2.PlanActionUpdate
+	default:
+		plan.Action = mirrorsv1alpha2.PlanActionNoop
+	}
+
+	return []mirrorsv1alpha2.DestinationPlan{plan}, nil
+}
+
+// Prune keeps secrets in AWS as Cleanup does
+func (d *AWSSecretDest) Prune(ctx context.Context, names []string) error {
+	_, _ = ctx, names
+	return nil
+}
+
+func (d *AWSSecretDest) Cleanup(ctx context.Context) error {
+	_ = ctx
+	return nil
+}
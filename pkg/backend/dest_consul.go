@@ -0,0 +1,186 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	mirrorsv1alpha2 "github.com/ktsstudio/mirrors/api/v1alpha2"
+	"github.com/ktsstudio/mirrors/pkg/reconresult"
+	"golang.org/x/time/rate"
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+func init() {
+	RegisterDest(DestRegistration{
+		Type: mirrorsv1alpha2.DestTypeConsul,
+		Default: func(mirror *mirrorsv1alpha2.SecretMirror) {
+			if mirror.Spec.Destination.Consul != nil {
+				mirror.Spec.Destination.Consul.Default(mirror.Namespace)
+			}
+		},
+		Validate: func(mirror *mirrorsv1alpha2.SecretMirror) error {
+			if mirror.Spec.Destination.Consul == nil {
+				return errors.New("destination.consul must be specified")
+			}
+			return mirror.Spec.Destination.Consul.Validate()
+		},
+		Factory: func(ctx context.Context, b *SecretMirrorBackend, mirror *mirrorsv1alpha2.SecretMirror) (DestSyncer, error) {
+			consul, err := b.makeConsul(ctx, mirror.Spec.Destination.Consul)
+			if err != nil {
+				return nil, err
+			}
+			return &ConsulSecretDest{
+				mirror:       mirror,
+				consul:       consul,
+				writeLimiter: b.writeLimiter,
+			}, nil
+		},
+	})
+}
+
+// ConsulSecretDest writes secret keys under a Consul KV prefix. The prefix is owned by a mirror:
+// keys missing from a source are deleted
+type ConsulSecretDest struct {
+	mirror       *mirrorsv1alpha2.SecretMirror
+	consul       *consulClient
+	writeLimiter *rate.Limiter
+}
+
+func (d *ConsulSecretDest) Setup(ctx context.Context) error {
+	_ = ctx
+	return nil
+}
+
+// secretPrefix returns a prefix to write a source secret to: <prefix>/<name>/ when a source yields many secrets
+func (d *ConsulSecretDest) secretPrefix(name string) string {
+	prefix := consulKeyPrefix(d.mirror.Spec.Destination.Consul.Prefix)
+	if d.mirror.IsMultiSource() {
+		return prefix + name + "/"
+	}
+	return prefix
+}
+
+func (d *ConsulSecretDest) Sync(ctx context.Context, secret *v1.Secret) error {
+	logger := log.FromContext(ctx)
+
+	if len(secret.Data) == 0 {
+		return reconresult.Fmt("no data in source secret")
+	}
+
+	prefix := d.secretPrefix(secret.Name)
+	pairs, err := d.consul.list(ctx, prefix)
+	if err != nil {
+		return consulError(fmt.Sprintf("Error reading %s from consul", prefix), err)
+	}
+	current, byKey := consulData(pairs, prefix)
+
+	if !dataDiffer(secret.Data, current) {
+		logger.Info(fmt.Sprintf("secrets %s/%s and <consul>/%s are identical",
+			secret.Namespace, secret.Name, prefix))
+		return nil
+	}
+
+	if err := d.writeLimiter.Wait(ctx); err != nil {
+		return err
+	}
+
+	// every write is checked against the ModifyIndex read above, so concurrent changes are not overwritten
+	for _, k := range sortedKeys(secret.Data) {
+		var cas uint64
+		if pair, ok := byKey[k]; ok && pair.Key == prefix+k {
+			if bytes.Equal(current[k], secret.Data[k]) {
+				continue
+			}
+			cas = pair.ModifyIndex
+		}
+		ok, err := d.consul.put(ctx, prefix+k, secret.Data[k], cas)
+		if err != nil {
+			return consulError(fmt.Sprintf("Error syncing to consul %s", prefix+k), err)
+		}
+		if !ok {
+			return consulConflict(prefix + k)
+		}
+	}
+	for _, k := range sortedKeys(current) {
+		pair := byKey[k]
+		if _, ok := secret.Data[k]; ok && pair.Key == prefix+k {
+			continue
+		}
+		ok, err := d.consul.delete(ctx, pair.Key, pair.ModifyIndex)
+		if err != nil {
+			return consulError(fmt.Sprintf("Error deleting consul key %s", pair.Key), err)
+		}
+		if !ok {
+			return consulConflict(pair.Key)
+		}
+	}
+
+	logger.Info("successfully synced secret to consul")
+	return nil
+}
+
+func consulConflict(key string) error {
+	return &reconresult.ReconcileResult{
+		Message:     fmt.Sprintf("consul key %s has been modified concurrently, will retry", key),
+		Status:      mirrorsv1alpha2.MirrorStatusError,
+		EventType:   v1.EventTypeWarning,
+		EventReason: "ConsulConflict",
+	}
+}
+
+func (d *ConsulSecretDest) Plan(ctx context.Context, secret *v1.Secret) ([]mirrorsv1alpha2.DestinationPlan, error) {
+	prefix := d.secretPrefix(secret.Name)
+	plan := mirrorsv1alpha2.DestinationPlan{
+		Destination: prefix,
+	}
+
+	pairs, err := d.consul.list(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	current, _ := consulData(pairs, prefix)
+
+	plan.ChangedKeys = changedKeys(secret.Data, current)
+	switch {
+	case len(current) == 0:
+		plan.Action = mirrorsv1alpha2.PlanActionCreate
+	case len(plan.ChangedKeys) > 0:
+		plan.Action = mirrorsv1alpha2.PlanActionUpdate
+	default:
+		plan.Action = mirrorsv1alpha2.PlanActionNoop
+	}
+
+	return []mirrorsv1alpha2.DestinationPlan{plan}, nil
+}
+
+// Prune deletes copies of secrets no longer in a source with deletePolicy: delete
+func (d *ConsulSecretDest) Prune(ctx context.Context, names []string) error {
+	return d.deleteSecrets(ctx, names)
+}
+
+// Cleanup deletes keys written by a mirror with deletePolicy: delete and keeps them with deletePolicy: retain
+func (d *ConsulSecretDest) Cleanup(ctx context.Context) error {
+	names := []string{d.mirror.Spec.Source.Name}
+	if d.mirror.IsMultiSource() {
+		names = d.mirror.Status.MirroredSecrets
+	}
+	return d.deleteSecrets(ctx, names)
+}
+
+func (d *ConsulSecretDest) deleteSecrets(ctx context.Context, names []string) error {
+	if d.mirror.Spec.DeletePolicy != mirrorsv1alpha2.DeletePolicyDelete {
+		return nil
+	}
+
+	logger := log.FromContext(ctx)
+	for _, name := range names {
+		prefix := d.secretPrefix(name)
+		if err := d.consul.deleteTree(ctx, prefix); err != nil {
+			return consulError(fmt.Sprintf("Error deleting %s from consul", prefix), err)
+		}
+		logger.Info("deleted consul keys", "prefix", prefix)
+	}
+	return nil
+}
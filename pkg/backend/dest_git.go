@@ -0,0 +1,341 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	mirrorsv1alpha2 "github.com/ktsstudio/mirrors/api/v1alpha2"
+	"github.com/ktsstudio/mirrors/pkg/reconresult"
+	"golang.org/x/time/rate"
+	v1 "k8s.io/api/core/v1"
+	"path"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sort"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterDest(DestRegistration{
+		Type: mirrorsv1alpha2.DestTypeGit,
+		Default: func(mirror *mirrorsv1alpha2.SecretMirror) {
+			if mirror.Spec.Destination.Git != nil {
+				mirror.Spec.Destination.Git.Default(mirror.Namespace)
+			}
+			mirror.Spec.DeletePolicy = mirrorsv1alpha2.DeletePolicyRetain
+		},
+		Validate: func(mirror *mirrorsv1alpha2.SecretMirror) error {
+			if mirror.Spec.Destination.Git == nil {
+				return errors.New("destination.git must be specified")
+			}
+			if plumbing.IsHash(mirror.Spec.Destination.Git.Revision) {
+				return errors.New("destination.git.revision must be a branch")
+			}
+			return mirror.Spec.Destination.Git.Validate()
+		},
+		Factory: func(ctx context.Context, b *SecretMirrorBackend, mirror *mirrorsv1alpha2.SecretMirror) (DestSyncer, error) {
+			auth, err := b.gitAuth(ctx, mirror.Spec.Destination.Git)
+			if err != nil {
+				return nil, err
+			}
+			keys, err := b.sopsKeys(ctx, &mirror.Spec.Destination.Git.SOPS)
+			if err != nil {
+				return nil, err
+			}
+			return &GitSecretDest{
+				mirror:       mirror,
+				repos:        b.gitRepositories,
+				auth:         auth,
+				keys:         keys,
+				writeLimiter: b.writeLimiter,
+			}, nil
+		},
+	})
+}
+
+// GitSecretDest commits SOPS-encrypted files to a branch of a git repository. Files are never deleted
+type GitSecretDest struct {
+	mirror       *mirrorsv1alpha2.SecretMirror
+	repos        *gitRepositories
+	auth         transport.AuthMethod
+	keys         *sopsKeys
+	writeLimiter *rate.Limiter
+}
+
+func (d *GitSecretDest) Setup(ctx context.Context) error {
+	_ = ctx
+	return nil
+}
+
+// filePath returns a path to write a source secret to: <path>/<name>.<format> when a source yields many secrets
+func (d *GitSecretDest) filePath(name string) string {
+	spec := d.mirror.Spec.Destination.Git
+	filePath := strings.TrimPrefix(spec.Path, "/")
+	if d.mirror.IsMultiSource() {
+		return path.Join(filePath, name+gitFileExtension(spec.Format))
+	}
+	return filePath
+}
+
+// current returns a head commit of the branch and decrypted contents of a file in it, nil when there is none
+func (d *GitSecretDest) current(ctx context.Context, repo *git.Repository, filePath string) (*object.Commit, map[string][]byte, error) {
+	spec := d.mirror.Spec.Destination.Git
+
+	var parent *object.Commit
+	ref, err := repo.Reference(plumbing.NewRemoteReferenceName(git.DefaultRemoteName, spec.Revision), true)
+	if err == nil {
+		parent, err = repo.CommitObject(ref.Hash())
+	} else if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		err = nil
+	}
+	if err != nil || parent == nil {
+		return nil, nil, err
+	}
+
+	contents, err := gitReadFile(parent, filePath)
+	if err != nil || contents == nil {
+		return parent, nil, err
+	}
+	data, err := sopsDecrypt(contents, spec.Format, d.keys)
+	if err != nil {
+		// a file which cannot be compared with a source is replaced
+		log.FromContext(ctx).Info(fmt.Sprintf("cannot decrypt %s, it will be overwritten: %s", filePath, err))
+		return parent, map[string][]byte{}, nil
+	}
+	return parent, data, nil
+}
+
+func (d *GitSecretDest) Sync(ctx context.Context, secret *v1.Secret) error {
+	logger := log.FromContext(ctx)
+	spec := d.mirror.Spec.Destination.Git
+
+	if len(secret.Data) == 0 {
+		return reconresult.Fmt("no data in source secret")
+	}
+
+	filePath := d.filePath(secret.Name)
+	repoPath, unlock := d.repos.lock(spec.URL)
+	defer unlock()
+
+	repo, err := gitFetch(ctx, repoPath, spec.URL, d.auth)
+	if err != nil {
+		return gitError(fmt.Sprintf("Error fetching %s", spec.URL), err)
+	}
+	parent, current, err := d.current(ctx, repo, filePath)
+	if err != nil {
+		return gitError(fmt.Sprintf("Error reading %s", filePath), err)
+	}
+
+	if current != nil && !dataDiffer(secret.Data, current) {
+		logger.Info(fmt.Sprintf("secrets %s/%s and <git>/%s are identical",
+			secret.Namespace, secret.Name, filePath))
+		return nil
+	}
+
+	if err := d.writeLimiter.Wait(ctx); err != nil {
+		return err
+	}
+
+	contents, err := sopsEncrypt(secret.Data, spec.Format, d.keys, spec.SOPS.AgeRecipients)
+	if err != nil {
+		return &reconresult.ReconcileResult{
+			Message:     fmt.Sprintf("Error encrypting %s: %s", filePath, err),
+			Status:      mirrorsv1alpha2.MirrorStatusError,
+			EventType:   v1.EventTypeWarning,
+			EventReason: "SOPSError",
+		}
+	}
+
+	signature := object.Signature{
+		Name:  spec.AuthorName,
+		Email: spec.AuthorEmail,
+		When:  time.Now(),
+	}
+	message := fmt.Sprintf("Update %s from SecretMirror %s/%s\n", filePath, d.mirror.Namespace, d.mirror.Name)
+	hash, err := gitCommitFile(repo, parent, filePath, contents, signature, message)
+	if err != nil {
+		return gitError(fmt.Sprintf("Error committing %s", filePath), err)
+	}
+
+	branch := plumbing.NewBranchReferenceName(spec.Revision)
+	if err := repo.Storer.SetReference(plumbing.NewHashReference(branch, hash)); err != nil {
+		return gitError(fmt.Sprintf("Error committing %s", filePath), err)
+	}
+
+	// the push is not forced: a branch moved since the fetch is fetched again on a retry
+	err = repo.PushContext(ctx, &git.PushOptions{
+		RemoteName: git.DefaultRemoteName,
+		RefSpecs:   []config.RefSpec{config.RefSpec(fmt.Sprintf("%s:%s", branch, branch))},
+		Auth:       d.auth,
+	})
+	if err != nil {
+		if isGitConflict(err) {
+			return &reconresult.ReconcileResult{
+				Message:     fmt.Sprintf("branch %s of %s has been modified concurrently, will retry", spec.Revision, spec.URL),
+				Status:      mirrorsv1alpha2.MirrorStatusError,
+				EventType:   v1.EventTypeWarning,
+				EventReason: "GitConflict",
+			}
+		}
+		return gitError(fmt.Sprintf("Error pushing to %s", spec.URL), err)
+	}
+
+	logger.Info("successfully synced secret to git", "path", filePath, "commit", hash.String())
+	return nil
+}
+
+// isGitConflict reports whether a push has been rejected because a remote branch is ahead of a commit
+func isGitConflict(err error) bool {
+	return errors.Is(err, git.ErrForceNeeded) ||
+		strings.Contains(err.Error(), "non-fast-forward") ||
+		strings.Contains(err.Error(), "fetch first")
+}
+
+// gitCommitFile creates a commit on top of parent (nil for a root commit) replacing a single file.
+// Objects are written to the repository storage directly, so a working tree is never touched
+func gitCommitFile(repo *git.Repository, parent *object.Commit, filePath string, contents []byte,
+	signature object.Signature, message string) (plumbing.Hash, error) {
+	blob := repo.Storer.NewEncodedObject()
+	blob.SetType(plumbing.BlobObject)
+	w, err := blob.Writer()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	if _, err := w.Write(contents); err != nil {
+		w.Close()
+		return plumbing.ZeroHash, err
+	}
+	if err := w.Close(); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	blobHash, err := repo.Storer.SetEncodedObject(blob)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	var root *object.Tree
+	var parents []plumbing.Hash
+	if parent != nil {
+		if root, err = parent.Tree(); err != nil {
+			return plumbing.ZeroHash, err
+		}
+		parents = append(parents, parent.Hash)
+	}
+	treeHash, err := gitUpdateTree(repo, root, strings.Split(filePath, "/"), blobHash)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	commit := &object.Commit{
+		Author:       signature,
+		Committer:    signature,
+		Message:      message,
+		TreeHash:     treeHash,
+		ParentHashes: parents,
+	}
+	obj := repo.Storer.NewEncodedObject()
+	if err := commit.Encode(obj); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return repo.Storer.SetEncodedObject(obj)
+}
+
+// gitUpdateTree stores a copy of a tree (nil for an empty one) with a blob at a path, returning its hash
+func gitUpdateTree(repo *git.Repository, tree *object.Tree, parts []string, blob plumbing.Hash) (plumbing.Hash, error) {
+	var entries []object.TreeEntry
+	var existing *object.TreeEntry
+	if tree != nil {
+		for i := range tree.Entries {
+			if tree.Entries[i].Name == parts[0] {
+				existing = &tree.Entries[i]
+				continue
+			}
+			entries = append(entries, tree.Entries[i])
+		}
+	}
+
+	entry := object.TreeEntry{Name: parts[0], Mode: filemode.Regular, Hash: blob}
+	if len(parts) > 1 {
+		var subtree *object.Tree
+		if existing != nil && existing.Mode == filemode.Dir {
+			var err error
+			if subtree, err = repo.TreeObject(existing.Hash); err != nil {
+				return plumbing.ZeroHash, err
+			}
+		}
+		hash, err := gitUpdateTree(repo, subtree, parts[1:], blob)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		entry = object.TreeEntry{Name: parts[0], Mode: filemode.Dir, Hash: hash}
+	} else if existing != nil && existing.Mode == filemode.Executable {
+		entry.Mode = filemode.Executable
+	}
+	entries = append(entries, entry)
+
+	// git orders tree entries by name, directories as if they had a trailing slash
+	sortName := func(e object.TreeEntry) string {
+		if e.Mode == filemode.Dir {
+			return e.Name + "/"
+		}
+		return e.Name
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return sortName(entries[i]) < sortName(entries[j])
+	})
+
+	obj := repo.Storer.NewEncodedObject()
+	if err := (&object.Tree{Entries: entries}).Encode(obj); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return repo.Storer.SetEncodedObject(obj)
+}
+
+func (d *GitSecretDest) Plan(ctx context.Context, secret *v1.Secret) ([]mirrorsv1alpha2.DestinationPlan, error) {
+	spec := d.mirror.Spec.Destination.Git
+	filePath := d.filePath(secret.Name)
+	plan := mirrorsv1alpha2.DestinationPlan{
+		Destination: fmt.Sprintf("%s@%s:%s", spec.URL, spec.Revision, filePath),
+	}
+
+	repoPath, unlock := d.repos.lock(spec.URL)
+	defer unlock()
+
+	repo, err := gitFetch(ctx, repoPath, spec.URL, d.auth)
+	if err != nil {
+		return nil, err
+	}
+	_, current, err := d.current(ctx, repo, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	plan.ChangedKeys = changedKeys(secret.Data, current)
+	switch {
+	case current == nil:
+		plan.Action = mirrorsv1alpha2.PlanActionCreate
+	case len(plan.ChangedKeys) > 0:
+		plan.Action = mirrorsv1alpha2.PlanActionUpdate
+	default:
+		plan.Action = mirrorsv1alpha2.PlanActionNoop
+	}
+
+	return []mirrorsv1alpha2.DestinationPlan{plan}, nil
+}
+
+// Prune keeps files in git as Cleanup does
+func (d *GitSecretDest) Prune(ctx context.Context, names []string) error {
+	_, _ = ctx, names
+	return nil
+}
+
+func (d *GitSecretDest) Cleanup(ctx context.Context) error {
+	_ = ctx
+	return nil
+}
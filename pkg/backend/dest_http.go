@@ -0,0 +1,199 @@
+package backend
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	mirrorsv1alpha2 "github.com/ktsstudio/mirrors/api/v1alpha2"
+	"github.com/ktsstudio/mirrors/pkg/reconresult"
+	"golang.org/x/time/rate"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"strings"
+)
+
+func init() {
+	RegisterDest(DestRegistration{
+		Type: mirrorsv1alpha2.DestTypeHTTP,
+		Default: func(mirror *mirrorsv1alpha2.SecretMirror) {
+			if mirror.Spec.Destination.HTTP != nil {
+				mirror.Spec.Destination.HTTP.Default(mirror.Namespace)
+			}
+			mirror.Spec.DeletePolicy = mirrorsv1alpha2.DeletePolicyRetain
+		},
+		Validate: func(mirror *mirrorsv1alpha2.SecretMirror) error {
+			spec := mirror.Spec.Destination.HTTP
+			if spec == nil {
+				return errors.New("destination.http must be specified")
+			}
+			if err := spec.Validate(); err != nil {
+				return err
+			}
+			if _, err := parseHTTPBodyTemplate(spec.Template); err != nil {
+				return fmt.Errorf("destination.http.template is not a valid template: %s", err)
+			}
+			return nil
+		},
+		Factory: func(ctx context.Context, b *SecretMirrorBackend, mirror *mirrorsv1alpha2.SecretMirror) (DestSyncer, error) {
+			client, err := b.makeHTTP(ctx, mirror.Spec.Destination.HTTP)
+			if err != nil {
+				return nil, err
+			}
+			return &HTTPSecretDest{
+				mirror:       mirror,
+				client:       client,
+				writeLimiter: b.writeLimiter,
+			}, nil
+		},
+	})
+}
+
+// HTTPSecretDest sends secrets to an HTTP endpoint. An endpoint cannot be read back, so hashes of delivered
+// requests are kept in status and a secret is only sent again when its request changes
+type HTTPSecretDest struct {
+	mirror       *mirrorsv1alpha2.SecretMirror
+	client       *httpClient
+	writeLimiter *rate.Limiter
+}
+
+func (d *HTTPSecretDest) Setup(ctx context.Context) error {
+	_ = ctx
+	return nil
+}
+
+// request renders a request body of a source secret and a hash identifying the request
+func (d *HTTPSecretDest) request(secret *v1.Secret) ([]byte, string, error) {
+	data := make(map[string]string, len(secret.Data))
+	for k, v := range secret.Data {
+		data[k] = string(v)
+	}
+	body, err := d.client.body(HTTPBodyData{
+		Namespace:  d.mirror.Namespace,
+		Name:       d.mirror.Name,
+		SourceName: secret.Name,
+		Data:       data,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	spec := d.mirror.Spec.Destination.HTTP
+	h := sha256.New()
+	h.Write([]byte(strings.ToUpper(spec.Method) + " " + spec.URL + "\n"))
+	h.Write(body)
+	return body, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (d *HTTPSecretDest) deliveredHash(name string) string {
+	if d.mirror.Status.HTTPDestination == nil {
+		return ""
+	}
+	return d.mirror.Status.HTTPDestination.ContentHashes[name]
+}
+
+func (d *HTTPSecretDest) maxRetries() int32 {
+	if spec := d.mirror.Spec.Destination.HTTP; spec.MaxRetries != nil {
+		return *spec.MaxRetries
+	}
+	return 0
+}
+
+func (d *HTTPSecretDest) Sync(ctx context.Context, secret *v1.Secret) error {
+	logger := log.FromContext(ctx)
+	spec := d.mirror.Spec.Destination.HTTP
+
+	if len(secret.Data) == 0 {
+		return reconresult.Fmt("no data in source secret")
+	}
+
+	body, hash, err := d.request(secret)
+	if err != nil {
+		return httpError(fmt.Sprintf("Error rendering a request of secret %s", secret.Name), err)
+	}
+	if d.deliveredHash(secret.Name) == hash {
+		logger.Info(fmt.Sprintf("secret %s/%s has already been sent to %s",
+			secret.Namespace, secret.Name, spec.URL))
+		return nil
+	}
+
+	if err := d.writeLimiter.Wait(ctx); err != nil {
+		return err
+	}
+
+	statusCode, err := d.client.send(ctx, body)
+	if d.mirror.Status.HTTPDestination == nil {
+		d.mirror.Status.HTTPDestination = &mirrorsv1alpha2.HTTPDestinationStatusSpec{}
+	}
+	status := d.mirror.Status.HTTPDestination
+	if statusCode != 0 {
+		now := metav1.Now()
+		status.LastStatusCode = statusCode
+		status.LastResponseTime = &now
+	}
+	if err != nil {
+		result := &reconresult.ReconcileResult{
+			Message:     fmt.Sprintf("Error sending secret %s to %s: %s", secret.Name, spec.URL, err),
+			Status:      mirrorsv1alpha2.MirrorStatusError,
+			EventType:   v1.EventTypeWarning,
+			EventReason: "HTTPDeliveryFailed",
+		}
+		// a worker never waits for a retry: the mirror is requeued sooner than its next sync, and the delay
+		// is doubled by the backoff of its consecutive failures
+		if isHTTPRetryable(statusCode) && d.mirror.Status.ConsecutiveFailures < d.maxRetries() {
+			result.RequeueAfter = httpRetryDelay
+		}
+		return result
+	}
+
+	if status.ContentHashes == nil {
+		status.ContentHashes = make(map[string]string)
+	}
+	status.ContentHashes[secret.Name] = hash
+	logger.Info("successfully sent secret to http endpoint", "url", spec.URL, "status", statusCode)
+	return nil
+}
+
+func (d *HTTPSecretDest) Plan(ctx context.Context, secret *v1.Secret) ([]mirrorsv1alpha2.DestinationPlan, error) {
+	_ = ctx
+	plan := mirrorsv1alpha2.DestinationPlan{
+		Destination: d.mirror.Spec.Destination.HTTP.URL,
+	}
+
+	_, hash, err := d.request(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	// keys changed since the last request are unknown, as only its hash is kept
+	switch delivered := d.deliveredHash(secret.Name); {
+	case delivered == "":
+		plan.Action = mirrorsv1alpha2.PlanActionCreate
+		plan.ChangedKeys = sortedKeys(secret.Data)
+	case delivered != hash:
+		plan.Action = mirrorsv1alpha2.PlanActionUpdate
+	default:
+		plan.Action = mirrorsv1alpha2.PlanActionNoop
+	}
+
+	return []mirrorsv1alpha2.DestinationPlan{plan}, nil
+}
+
+// Prune forgets secrets no longer in a source, nothing is sent to the endpoint
+func (d *HTTPSecretDest) Prune(ctx context.Context, names []string) error {
+	_ = ctx
+	if d.mirror.Status.HTTPDestination == nil {
+		return nil
+	}
+	for _, name := range names {
+		delete(d.mirror.Status.HTTPDestination.ContentHashes, name)
+	}
+	return nil
+}
+
+func (d *HTTPSecretDest) Cleanup(ctx context.Context) error {
+	_ = ctx
+	return nil
+}
@@ -2,6 +2,7 @@ package backend
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	mirrorsv1alpha2 "github.com/ktsstudio/mirrors/api/v1alpha2"
 	"github.com/ktsstudio/mirrors/pkg/metrics"
@@ -10,6 +11,7 @@ import (
 	"github.com/panjf2000/ants/v2"
 	"github.com/prometheus/client_golang/prometheus"
 	"golang.org/x/sync/errgroup"
+	"golang.org/x/time/rate"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
@@ -17,15 +19,67 @@ import (
 	"regexp"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sort"
+	"strings"
 	"sync"
 )
 
+func init() {
+	RegisterDest(DestRegistration{
+		Type: mirrorsv1alpha2.DestTypeNamespaces,
+		Default: func(mirror *mirrorsv1alpha2.SecretMirror) {
+			if mirror.Spec.Destination.ConflictPolicy == "" {
+				mirror.Spec.Destination.ConflictPolicy = mirrorsv1alpha2.ConflictPolicySkip
+			}
+		},
+		Validate: validateNamespacesDest,
+		Factory: func(ctx context.Context, b *SecretMirrorBackend, mirror *mirrorsv1alpha2.SecretMirror) (DestSyncer, error) {
+			return &NamespacesDest{
+				Client:        b,
+				EventRecorder: b.Recorder,
+				apiReader:     b.apiReader,
+				mirror:        mirror,
+				nsKeeper:      b.nsKeeper,
+				pool:          b.pool,
+				writeLimiter:  b.writeLimiter,
+			}, nil
+		},
+	})
+}
+
+func validateNamespacesDest(mirror *mirrorsv1alpha2.SecretMirror) error {
+	if len(mirror.Spec.Destination.Namespaces) == 0 {
+		return errors.New("destination namespaces are empty")
+	}
+	for i, nsRegex := range mirror.Spec.Destination.Namespaces {
+		if nsRegex == "" {
+			return fmt.Errorf("destination namespace #%d is empty", i)
+		}
+		_, err := regexp.Compile(nsRegex)
+		if err != nil {
+			return fmt.Errorf("destination namespace #%d has a problem compiling: %s", i, err)
+		}
+	}
+
+	switch mirror.Spec.Destination.ConflictPolicy {
+	case "", mirrorsv1alpha2.ConflictPolicySkip, mirrorsv1alpha2.ConflictPolicyAdopt, mirrorsv1alpha2.ConflictPolicyFail:
+	default:
+		return errors.New("destination.conflictPolicy must be one of the following: `skip`, `adopt`, `fail`")
+	}
+	return nil
+}
+
 type NamespacesDest struct {
 	client.Client
 	record.EventRecorder
-	mirror   *mirrorsv1alpha2.SecretMirror
-	nsKeeper *nskeeper.NSKeeper
-	pool     *ants.Pool
+	apiReader    client.Reader
+	mirror       *mirrorsv1alpha2.SecretMirror
+	nsKeeper     *nskeeper.NSKeeper
+	pool         *ants.Pool
+	writeLimiter *rate.Limiter
+
+	conflictsMutex sync.Mutex
+	conflicts      []string
 }
 
 func (d *NamespacesDest) Setup(ctx context.Context) error {
@@ -49,7 +103,7 @@ func (d *NamespacesDest) Sync(ctx context.Context, secret *v1.Secret) error {
 				defer wg.Done()
 				return d.syncOneToNamespace(ctx, secret, types.NamespacedName{
 					Namespace: ns,
-					Name:      d.mirror.Spec.Source.Name,
+					Name:      destSecretName(d.mirror, secret),
 				})
 			})
 		}); err != nil {
@@ -58,7 +112,11 @@ func (d *NamespacesDest) Sync(ctx context.Context, secret *v1.Secret) error {
 	}
 	wg.Wait()
 
-	if err := g.Wait(); err != nil {
+	// conflicts are reported even if other namespaces have failed
+	err := g.Wait()
+	sort.Strings(d.conflicts)
+	d.mirror.Status.Conflicts = d.conflicts
+	if err != nil {
 		return &reconresult.ReconcileResult{
 			Message:     fmt.Sprintf("unable to sync some objects: %s", err),
 			Status:      mirrorsv1alpha2.MirrorStatusError,
@@ -67,6 +125,16 @@ func (d *NamespacesDest) Sync(ctx context.Context, secret *v1.Secret) error {
 		}
 	}
 
+	if len(d.conflicts) > 0 && d.mirror.Spec.Destination.ConflictPolicy == mirrorsv1alpha2.ConflictPolicyFail {
+		return &reconresult.ReconcileResult{
+			Message: fmt.Sprintf("secret %s exists but is not managed by the mirror in namespaces: %s",
+				destSecretName(d.mirror, secret), strings.Join(d.conflicts, ", ")),
+			Status:      mirrorsv1alpha2.MirrorStatusError,
+			EventType:   v1.EventTypeWarning,
+			EventReason: "Conflict",
+		}
+	}
+
 	metrics.MirrorNSCurrentCount.With(prometheus.Labels{
 		"mirror":      getPrettyName(d.mirror),
 		"source_type": string(d.mirror.Spec.Source.Type),
@@ -74,6 +142,62 @@ func (d *NamespacesDest) Sync(ctx context.Context, secret *v1.Secret) error {
 	return nil
 }
 
+func (d *NamespacesDest) Plan(ctx context.Context, secret *v1.Secret) ([]mirrorsv1alpha2.DestinationPlan, error) {
+	destNamespaces := d.getDestinationNamespaces()
+	sort.Strings(destNamespaces)
+
+	plan := make([]mirrorsv1alpha2.DestinationPlan, 0, len(destNamespaces))
+	for _, ns := range destNamespaces {
+		dest := types.NamespacedName{
+			Namespace: ns,
+			Name:      destSecretName(d.mirror, secret),
+		}
+		destSecret, err := FetchSecret(ctx, d, dest)
+		if err != nil {
+			return nil, err
+		}
+
+		plan = append(plan, d.planOneToNamespace(ctx, secret, destSecret, dest))
+	}
+	return plan, nil
+}
+
+func (d *NamespacesDest) planOneToNamespace(ctx context.Context, secret, destSecret *v1.Secret, dest types.NamespacedName) mirrorsv1alpha2.DestinationPlan {
+	plan := mirrorsv1alpha2.DestinationPlan{
+		Destination: dest.Namespace,
+	}
+	if d.mirror.IsMultiSource() {
+		plan.Destination = dest.String()
+	}
+
+	if destSecret == nil {
+		plan.Action = mirrorsv1alpha2.PlanActionCreate
+		plan.ChangedKeys = changedKeys(secret.Data, nil)
+		return plan
+	}
+
+	if !d.validateAnnotations(ctx, destSecret) && !d.canAdopt(destSecret) {
+		plan.Action = mirrorsv1alpha2.PlanActionConflict
+		return plan
+	}
+
+	sourceHash := hashSecretData(secret.Data)
+	if hasDrifted(destSecret) && d.mirror.Spec.DriftPolicy != mirrorsv1alpha2.DriftPolicyRevert &&
+		destSecret.Annotations[contentHashAnnotation] == sourceHash {
+		plan.Action = mirrorsv1alpha2.PlanActionNoop
+		return plan
+	}
+
+	if !secretDiffer(secret, destSecret) && destSecret.Annotations[contentHashAnnotation] == sourceHash {
+		plan.Action = mirrorsv1alpha2.PlanActionNoop
+		return plan
+	}
+
+	plan.Action = mirrorsv1alpha2.PlanActionUpdate
+	plan.ChangedKeys = changedKeys(secret.Data, destSecret.Data)
+	return plan
+}
+
 func (d *NamespacesDest) registerNamespaces() error {
 	if len(d.mirror.Spec.Destination.Namespaces) > 0 {
 		regexps := make([]*regexp.Regexp, 0, len(d.mirror.Spec.Destination.Namespaces))
@@ -102,7 +226,23 @@ func (d *NamespacesDest) syncOneToNamespace(ctx context.Context, secret *v1.Secr
 	}
 
 	if !d.validateAnnotations(ctx, destSecret) {
-		return nil
+		if !d.canAdopt(destSecret) {
+			d.addConflict(destSecret)
+			return nil
+		}
+
+		logger.Info(fmt.Sprintf("adopting secret %s/%s", destSecret.Namespace, destSecret.Name))
+		d.Eventf(d.mirror, v1.EventTypeNormal, "Adopted", "Adopted secret %s/%s",
+			destSecret.Namespace, destSecret.Name)
+	}
+
+	sourceHash := hashSecretData(secret.Data)
+	if destSecret != nil && d.detectDrift(ctx, destSecret) {
+		// the copy keeps its local changes until the source itself changes
+		if d.mirror.Spec.DriftPolicy != mirrorsv1alpha2.DriftPolicyRevert &&
+			destSecret.Annotations[contentHashAnnotation] == sourceHash {
+			return d.markDriftReported(ctx, destSecret)
+		}
 	}
 
 	doCreate := false
@@ -120,19 +260,29 @@ func (d *NamespacesDest) syncOneToNamespace(ctx context.Context, secret *v1.Secr
 		doCreate = true
 	}
 
-	if !secretDiffer(secret, destSecret) {
+	if !secretDiffer(secret, destSecret) && destSecret.Annotations[contentHashAnnotation] == sourceHash {
 		logger.Info(fmt.Sprintf("secrets %s/%s and %s/%s are identical",
 			secret.Namespace, secret.Name, destSecret.Namespace, destSecret.Name))
 		return nil
 	}
 
+	dataChanged := !doCreate && hashSecretData(destSecret.Data) != sourceHash
+
 	copySecret(secret, destSecret)
+	if destSecret.Annotations == nil {
+		// an adopted secret may have no annotations
+		destSecret.Annotations = make(map[string]string)
+	}
+	delete(destSecret.Annotations, driftReportedAnnotation)
 	destSecret.Annotations[ownedByMirrorAnnotation] = d.getManagedByMirrorValue()
 	destSecret.Annotations[lastSyncAnnotation] = metav1.Now().String()
 	destSecret.Annotations[parentVersionAnnotation] = secret.ResourceVersion
+	destSecret.Annotations[contentHashAnnotation] = sourceHash
 	destSecret.Annotations[sourceTypeAnnotation] = string(d.mirror.Spec.Source.Type)
 	if d.mirror.Spec.Source.Type == mirrorsv1alpha2.SourceTypeVault {
-		destSecret.Annotations[vaultPathAnnotation] = d.mirror.Spec.Source.Vault.Path
+		if !d.mirror.IsMultiSource() {
+			destSecret.Annotations[vaultPathAnnotation] = d.mirror.Spec.Source.Vault.Path
+		}
 
 		if d.mirror.Status.VaultSource != nil {
 			destSecret.Annotations[vaultLeaseIdAnnotation] = d.mirror.Status.VaultSource.LeaseID
@@ -140,6 +290,10 @@ func (d *NamespacesDest) syncOneToNamespace(ctx context.Context, secret *v1.Secr
 		}
 	}
 
+	if err := d.writeLimiter.Wait(ctx); err != nil {
+		return err
+	}
+
 	if doCreate {
 		if err := d.Create(ctx, destSecret); err != nil {
 			logger.Error(err, "unable to create own secret for SecretMirror", "secret", destSecret)
@@ -155,6 +309,15 @@ func (d *NamespacesDest) syncOneToNamespace(ctx context.Context, secret *v1.Secr
 	logger.Info(fmt.Sprintf("successfully mirrored secret %s/%s to %s/%s",
 		secret.Namespace, secret.Name, destSecret.Namespace, destSecret.Name))
 
+	if dataChanged && d.mirror.Spec.Rollout != nil {
+		if err := d.rollout(ctx, destSecret, sourceHash); err != nil {
+			// the secret is already updated, so a failed rollout is only reported
+			logger.Error(err, "error rolling out workloads", "namespace", destSecret.Namespace)
+			d.Eventf(d.mirror, v1.EventTypeWarning, "RolloutFailed", "Failed to roll out workloads in %s: %s",
+				destSecret.Namespace, err)
+		}
+	}
+
 	return nil
 }
 
@@ -191,11 +354,100 @@ func (d *NamespacesDest) validateAnnotations(ctx context.Context, secret *v1.Sec
 	return false
 }
 
+// canAdopt reports whether a secret not managed by the mirror may be taken over. Only secrets
+// not owned by any mirror are adopted, a copy of another mirror is always a conflict
+func (d *NamespacesDest) canAdopt(secret *v1.Secret) bool {
+	return d.mirror.Spec.Destination.ConflictPolicy == mirrorsv1alpha2.ConflictPolicyAdopt &&
+		secret.Annotations[ownedByMirrorAnnotation] == ""
+}
+
+// hasDrifted reports whether a copy no longer matches the data it was last synced with
+func hasDrifted(secret *v1.Secret) bool {
+	expectedHash, ok := secret.Annotations[contentHashAnnotation]
+	return ok && expectedHash != hashSecretData(secret.Data)
+}
+
+// driftReported reports whether the current contents of a drifted copy have already been reported
+func driftReported(secret *v1.Secret) bool {
+	reported, ok := secret.Annotations[driftReportedAnnotation]
+	return ok && reported == hashSecretData(secret.Data)
+}
+
+// detectDrift checks a copy for drift and reports it according to the drift policy.
+// A drift is reported once, until the contents of the copy change again
+func (d *NamespacesDest) detectDrift(ctx context.Context, secret *v1.Secret) bool {
+	if !hasDrifted(secret) {
+		return false
+	}
+
+	if d.mirror.Spec.DriftPolicy == mirrorsv1alpha2.DriftPolicyIgnore || driftReported(secret) {
+		return true
+	}
+
+	logger := log.FromContext(ctx)
+	logger.Info(fmt.Sprintf("secret %s/%s has been modified outside of SecretMirror %s/%s",
+		secret.Namespace, secret.Name, d.mirror.Namespace, d.mirror.Name),
+		"driftPolicy", d.mirror.Spec.DriftPolicy)
+
+	d.Eventf(d.mirror, v1.EventTypeWarning, "DriftDetected",
+		"Secret %s/%s has been modified outside of the mirror (driftPolicy: %s)",
+		secret.Namespace, secret.Name, d.mirror.Spec.DriftPolicy)
+	metrics.MirrorDriftCount.With(prometheus.Labels{
+		"mirror":    getPrettyName(d.mirror),
+		"namespace": secret.Namespace,
+	}).Inc()
+
+	return true
+}
+
+// markDriftReported records contents of a drifted copy which is kept, so that its drift is not reported again
+func (d *NamespacesDest) markDriftReported(ctx context.Context, secret *v1.Secret) error {
+	if d.mirror.Spec.DriftPolicy == mirrorsv1alpha2.DriftPolicyIgnore || driftReported(secret) {
+		return nil
+	}
+
+	patch := client.MergeFrom(secret.DeepCopy())
+	if secret.Annotations == nil {
+		secret.Annotations = make(map[string]string)
+	}
+	secret.Annotations[driftReportedAnnotation] = hashSecretData(secret.Data)
+	return client.IgnoreNotFound(d.Patch(ctx, secret, patch))
+}
+
+func (d *NamespacesDest) addConflict(secret *v1.Secret) {
+	conflict := secret.Namespace
+	if d.mirror.IsMultiSource() {
+		conflict = getPrettyName(secret)
+	}
+
+	d.conflictsMutex.Lock()
+	d.conflicts = append(d.conflicts, conflict)
+	d.conflictsMutex.Unlock()
+
+	if d.mirror.Spec.Destination.ConflictPolicy == mirrorsv1alpha2.ConflictPolicyFail {
+		return
+	}
+	if owner := secret.Annotations[ownedByMirrorAnnotation]; owner != "" {
+		d.Eventf(d.mirror, v1.EventTypeWarning, "Conflict",
+			"Secret %s/%s is managed by SecretMirror %s, skipping",
+			secret.Namespace, secret.Name, owner)
+	} else {
+		d.Eventf(d.mirror, v1.EventTypeWarning, "Conflict",
+			"Secret %s/%s exists but is not managed by the mirror, skipping",
+			secret.Namespace, secret.Name)
+	}
+}
+
 func (d *NamespacesDest) getManagedByMirrorValue() string {
 	return getManagedByMirrorValue(d.mirror.Namespace, d.mirror.Name)
 }
 
+// Cleanup deletes copies of a deleted mirror. Namespaces are registered here, as Setup has not run
+// for a mirror which is suspended or is deleted before its first sync after a restart
 func (d *NamespacesDest) Cleanup(ctx context.Context) error {
+	if err := d.registerNamespaces(); err != nil {
+		return err
+	}
 	namespaces := d.getDestinationNamespaces()
 
 	d.nsKeeper.DeregisterNamespaceRegex(types.NamespacedName{
@@ -203,12 +455,26 @@ func (d *NamespacesDest) Cleanup(ctx context.Context) error {
 		Name:      d.mirror.Name,
 	})
 
+	names := []string{d.mirror.Spec.Source.Name}
+	if d.mirror.IsMultiSource() {
+		names = d.mirror.Status.MirroredSecrets
+	}
+	return d.deleteSecrets(ctx, namespaces, names)
+}
+
+func (d *NamespacesDest) Prune(ctx context.Context, names []string) error {
+	return d.deleteSecrets(ctx, d.getDestinationNamespaces(), names)
+}
+
+func (d *NamespacesDest) deleteSecrets(ctx context.Context, namespaces, names []string) error {
 	for _, ns := range namespaces {
-		if err := d.deleteOneSecret(ctx, types.NamespacedName{
-			Namespace: ns,
-			Name:      d.mirror.Spec.Source.Name,
-		}); err != nil {
-			return err
+		for _, name := range names {
+			if err := d.deleteOneSecret(ctx, types.NamespacedName{
+				Namespace: ns,
+				Name:      name,
+			}); err != nil {
+				return err
+			}
 		}
 	}
 	return nil
@@ -0,0 +1,199 @@
+package backend
+
+import (
+	"context"
+	mirrorsv1alpha2 "github.com/ktsstudio/mirrors/api/v1alpha2"
+	"github.com/ktsstudio/mirrors/pkg/nskeeper"
+	"github.com/ktsstudio/mirrors/pkg/reconresult"
+	"github.com/panjf2000/ants/v2"
+	"golang.org/x/time/rate"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"testing"
+)
+
+func testNamespacesDest(mirror *mirrorsv1alpha2.SecretMirror, objects ...client.Object) (*NamespacesDest, *record.FakeRecorder) {
+	recorder := record.NewFakeRecorder(100)
+	return &NamespacesDest{
+		Client:        fake.NewClientBuilder().WithObjects(objects...).Build(),
+		EventRecorder: recorder,
+		mirror:        mirror,
+		writeLimiter:  rate.NewLimiter(rate.Inf, 1),
+	}, recorder
+}
+
+func testMirror(driftPolicy mirrorsv1alpha2.DriftPolicyType, conflictPolicy mirrorsv1alpha2.ConflictPolicyType) *mirrorsv1alpha2.SecretMirror {
+	var mirror mirrorsv1alpha2.SecretMirror
+	mirror.Namespace, mirror.Name = "default", "db"
+	mirror.Spec.Source.Name = "db"
+	mirror.Spec.DriftPolicy = driftPolicy
+	mirror.Spec.Destination.ConflictPolicy = conflictPolicy
+	return &mirror
+}
+
+func testSourceSecret(password string) *v1.Secret {
+	return &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "db"},
+		Data:       map[string][]byte{"password": []byte(password)},
+	}
+}
+
+func eventReasons(recorder *record.FakeRecorder) []string {
+	var reasons []string
+	for {
+		select {
+		case event := <-recorder.Events:
+			reasons = append(reasons, event)
+		default:
+			return reasons
+		}
+	}
+}
+
+func TestDriftReportedOnce(t *testing.T) {
+	ctx := context.Background()
+	source := testSourceSecret("secret")
+	copied := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "apps",
+			Name:      "db",
+			Annotations: map[string]string{
+				ownedByMirrorAnnotation: "default/db",
+				contentHashAnnotation:   hashSecretData(source.Data),
+			},
+		},
+		Data: map[string][]byte{"password": []byte("changed")},
+	}
+	d, recorder := testNamespacesDest(testMirror(mirrorsv1alpha2.DriftPolicyReport, ""), copied)
+	dest := types.NamespacedName{Namespace: "apps", Name: "db"}
+
+	for i := 0; i < 3; i++ {
+		if err := d.syncOneToNamespace(ctx, source, dest); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if events := eventReasons(recorder); len(events) != 1 {
+		t.Fatalf("expected a single drift event, got %q", events)
+	}
+
+	var kept v1.Secret
+	if err := d.Get(ctx, dest, &kept); err != nil {
+		t.Fatal(err)
+	}
+	if string(kept.Data["password"]) != "changed" {
+		t.Fatal("a drifted copy has been reverted with driftPolicy report")
+	}
+
+	// another change of the copy is a new drift
+	kept.Data["password"] = []byte("changed again")
+	if err := d.Update(ctx, &kept); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.syncOneToNamespace(ctx, source, dest); err != nil {
+		t.Fatal(err)
+	}
+	if events := eventReasons(recorder); len(events) != 1 {
+		t.Fatalf("expected a drift event of a new change, got %q", events)
+	}
+
+	// a change of the source overwrites the copy and forgets the reported drift
+	if err := d.syncOneToNamespace(ctx, testSourceSecret("rotated"), dest); err != nil {
+		t.Fatal(err)
+	}
+	var updated v1.Secret
+	if err := d.Get(ctx, dest, &updated); err != nil {
+		t.Fatal(err)
+	}
+	if string(updated.Data["password"]) != "rotated" {
+		t.Fatalf("copy has not been updated: %q", updated.Data)
+	}
+	if _, ok := updated.Annotations[driftReportedAnnotation]; ok {
+		t.Fatal("reported drift is kept after an update of the copy")
+	}
+}
+
+func TestAdoptOnlyUnownedSecrets(t *testing.T) {
+	ctx := context.Background()
+	source := testSourceSecret("secret")
+	unowned := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "apps", Name: "db"},
+		Data:       map[string][]byte{"password": []byte("local")},
+	}
+	foreign := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "other",
+			Name:        "db",
+			Annotations: map[string]string{ownedByMirrorAnnotation: "other/db"},
+		},
+		Data: map[string][]byte{"password": []byte("other")},
+	}
+	d, recorder := testNamespacesDest(testMirror(mirrorsv1alpha2.DriftPolicyRevert, mirrorsv1alpha2.ConflictPolicyAdopt),
+		unowned, foreign)
+
+	for _, ns := range []string{"apps", "other"} {
+		if err := d.syncOneToNamespace(ctx, source, types.NamespacedName{Namespace: ns, Name: "db"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var adopted v1.Secret
+	if err := d.Get(ctx, types.NamespacedName{Namespace: "apps", Name: "db"}, &adopted); err != nil {
+		t.Fatal(err)
+	}
+	if adopted.Annotations[ownedByMirrorAnnotation] != "default/db" || string(adopted.Data["password"]) != "secret" {
+		t.Fatalf("unowned secret has not been adopted: %v %q", adopted.Annotations, adopted.Data)
+	}
+
+	var kept v1.Secret
+	if err := d.Get(ctx, types.NamespacedName{Namespace: "other", Name: "db"}, &kept); err != nil {
+		t.Fatal(err)
+	}
+	if kept.Annotations[ownedByMirrorAnnotation] != "other/db" || string(kept.Data["password"]) != "other" {
+		t.Fatalf("secret of another mirror has been adopted: %v %q", kept.Annotations, kept.Data)
+	}
+	if len(d.conflicts) != 1 || d.conflicts[0] != "other" {
+		t.Fatalf("unexpected conflicts %q", d.conflicts)
+	}
+	if events := eventReasons(recorder); len(events) != 2 {
+		t.Fatalf("expected an Adopted and a Conflict event, got %q", events)
+	}
+
+	plan := d.planOneToNamespace(ctx, source, &kept, types.NamespacedName{Namespace: "other", Name: "db"})
+	if plan.Action != mirrorsv1alpha2.PlanActionConflict {
+		t.Fatalf("unexpected plan action %s of a secret of another mirror", plan.Action)
+	}
+
+	// conflicts are reported when a sync to another namespace fails
+	for _, ns := range []string{"apps", "other", "broken"} {
+		if err := d.Create(ctx, &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: ns}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d.nsKeeper = nskeeper.MakeNSKeeper(metadataClient{d.Client})
+	d.nsKeeper.InitNamespaces(ctx)
+	d.mirror.Spec.Destination.Namespaces = []string{"apps", "other", "broken"}
+	if err := d.registerNamespaces(); err != nil {
+		t.Fatal(err)
+	}
+	pool, err := ants.NewPool(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Release()
+	d.pool = pool
+	d.Client = &failingCreateClient{Client: d.Client, failures: 1}
+	d.conflicts = nil
+	d.mirror.Status.Conflicts = []string{"stale"}
+
+	err = d.Sync(ctx, source)
+	if result, ok := err.(*reconresult.ReconcileResult); !ok || result.EventReason != "SyncError" {
+		t.Fatalf("expected a sync error, got %v", err)
+	}
+	if conflicts := d.mirror.Status.Conflicts; len(conflicts) != 1 || conflicts[0] != "other" {
+		t.Fatalf("unexpected conflicts %q after a failed sync", conflicts)
+	}
+}
@@ -2,24 +2,91 @@ package backend
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	vault "github.com/hashicorp/vault/api"
 	mirrorsv1alpha2 "github.com/ktsstudio/mirrors/api/v1alpha2"
 	"github.com/ktsstudio/mirrors/pkg/reconresult"
+	"golang.org/x/time/rate"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"strings"
+	"text/template"
 )
 
+func init() {
+	RegisterDest(DestRegistration{
+		Type: mirrorsv1alpha2.DestTypeVault,
+		Default: func(mirror *mirrorsv1alpha2.SecretMirror) {
+			if mirror.Spec.Destination.Vault != nil {
+				mirror.Spec.Destination.Vault.Default(mirror.Namespace)
+			}
+			mirror.Spec.DeletePolicy = mirrorsv1alpha2.DeletePolicyRetain
+		},
+		Validate: validateVaultDest,
+		Factory: func(ctx context.Context, b *SecretMirrorBackend, mirror *mirrorsv1alpha2.SecretMirror) (DestSyncer, error) {
+			vault, err := b.makeVault(ctx, mirror.Spec.Destination.Vault)
+			if err != nil {
+				return nil, err
+			}
+
+			return &VaultSecretDest{
+				Client:        b,
+				EventRecorder: b.Recorder,
+				mirror:        mirror,
+				vault:         vault,
+				writeLimiter:  b.writeLimiter,
+				pathData: VaultPathData{
+					Namespace:  mirror.Namespace,
+					Name:       mirror.Name,
+					SourceName: mirror.Spec.Source.Name,
+					Cluster:    b.clusterName,
+					Vars:       b.pathVariables,
+				},
+			}, nil
+		},
+	})
+}
+
+func validateVaultDest(mirror *mirrorsv1alpha2.SecretMirror) error {
+	spec := mirror.Spec.Destination.Vault
+	if spec == nil {
+		return errors.New("destination.vault must be specified")
+	}
+	if err := spec.Validate(); err != nil {
+		return err
+	}
+	if _, err := template.New("path").Parse(spec.Path); err != nil {
+		return fmt.Errorf("destination.vault.path is not a valid template: %s", err)
+	}
+	return spec.ValidateValues()
+}
+
 type VaultSecretDest struct {
 	client.Client
 	record.EventRecorder
-	mirror *mirrorsv1alpha2.SecretMirror
-	vault  VaultBackend
+	mirror       *mirrorsv1alpha2.SecretMirror
+	vault        VaultBackend
+	writeLimiter *rate.Limiter
+	pathData     VaultPathData
+	path         string
 }
 
 func (d *VaultSecretDest) Setup(ctx context.Context) error {
 	_ = ctx
+
+	path, err := renderVaultPath(d.mirror.Spec.Destination.Vault.Path, d.pathData)
+	if err != nil {
+		return &reconresult.ReconcileResult{
+			Message:     fmt.Sprintf("Error rendering destination.vault.path: %s", err),
+			Status:      mirrorsv1alpha2.MirrorStatusError,
+			EventType:   v1.EventTypeWarning,
+			EventReason: "InvalidVaultPath",
+		}
+	}
+	d.path = path
 	return nil
 }
 
@@ -30,27 +97,77 @@ func (d *VaultSecretDest) Sync(ctx context.Context, secret *v1.Secret) error {
 		return reconresult.Fmt("no data in source secret")
 	}
 
-	path := d.mirror.Spec.Destination.Vault.Path
+	path := d.secretPath(secret)
 
 	vaultSecret, err := d.vault.ReadSecret(path)
 	if err != nil {
 		return err
 	}
 	if vaultSecret != nil {
-		vaultData, err := extractVaultSecretData(vaultSecret)
+		vaultData, err := d.readData(ctx, vaultSecret)
 		if err != nil {
 			return err
 		}
 
-		if !dataDiffer(secret.Data, vaultData) {
+		if !dataDiffer(secret.Data, vaultData) && !d.encodingChanged(vaultSecret) {
 			logger.Info(fmt.Sprintf("secrets %s/%s and <vault>/%s are identical",
 				secret.Namespace, secret.Name, path))
 			return nil
 		}
 	}
 
+	if err := d.writeLimiter.Wait(ctx); err != nil {
+		return err
+	}
+
+	var data map[string]interface{}
+	var encoding mirrorsv1alpha2.VaultEncoding
+	if transit := d.mirror.Spec.Destination.Vault.Transit; transit != nil {
+		if data, err = transitEncrypt(d.vault, transit, secret.Data); err != nil {
+			return &reconresult.ReconcileResult{
+				Message:     fmt.Sprintf("Error encrypting data with transit key %s: %s", transit.Key, err),
+				Status:      mirrorsv1alpha2.MirrorStatusError,
+				EventType:   v1.EventTypeWarning,
+				EventReason: "VaultError",
+			}
+		}
+	} else {
+		if data, encoding, err = encodeVaultData(secret.Data, d.mirror.Spec.Destination.Vault.Encoding); err != nil {
+			return &reconresult.ReconcileResult{
+				Message:     fmt.Sprintf("Error encoding data for vault: %s", err),
+				Status:      mirrorsv1alpha2.MirrorStatusError,
+				EventType:   v1.EventTypeWarning,
+				EventReason: "InvalidVaultEncoding",
+			}
+		}
+	}
+
+	if spec := d.mirror.Spec.Destination.Vault; spec.StructuredValues == mirrorsv1alpha2.VaultStructuredValuesFlatten {
+		if data, err = unflattenVaultData(data, spec.FlattenSeparator); err != nil {
+			return &reconresult.ReconcileResult{
+				Message:     fmt.Sprintf("Error rebuilding nested values: %s", err),
+				Status:      mirrorsv1alpha2.MirrorStatusError,
+				EventType:   v1.EventTypeWarning,
+				EventReason: "InvalidVaultData",
+			}
+		}
+	}
+
+	// the encoding is recorded before the values: a sync failing in between is retried, as the values
+	// read back with the new encoding differ from the source
+	if encoding != "" {
+		if err := d.recordEncoding(path, vaultSecret, encoding); err != nil {
+			return &reconresult.ReconcileResult{
+				Message:     fmt.Sprintf("Error recording encoding in vault secret metadata: %s", err),
+				Status:      mirrorsv1alpha2.MirrorStatusError,
+				EventType:   v1.EventTypeWarning,
+				EventReason: "VaultError",
+			}
+		}
+	}
+
 	if err := d.vault.WriteData(path, map[string]interface{}{
-		"data": secret.Data,
+		"data": data,
 	}); err != nil {
 		return &reconresult.ReconcileResult{
 			Message:     fmt.Sprintf("Error syncing to vault: %s", err),
@@ -64,6 +181,124 @@ func (d *VaultSecretDest) Sync(ctx context.Context, secret *v1.Secret) error {
 	return nil
 }
 
+// encodingChanged reports whether a destination secret has been written with an encoding other than configured
+func (d *VaultSecretDest) encodingChanged(vaultSecret *vault.Secret) bool {
+	if d.mirror.Spec.Destination.Vault.Transit != nil {
+		return false
+	}
+	if _, ok := vaultSecret.Data["metadata"]; !ok {
+		// KV v1 - nothing is recorded
+		return false
+	}
+
+	recorded := recordedVaultEncoding(vaultSecret)
+	if d.mirror.Spec.Destination.Vault.Encoding == mirrorsv1alpha2.VaultEncodingRaw {
+		return recorded != mirrorsv1alpha2.VaultEncodingRaw
+	}
+	// secrets written before encodings were recorded hold base64 values
+	return recorded != "" && recorded != mirrorsv1alpha2.VaultEncodingBase64
+}
+
+// recordEncoding stores an encoding of values in KV v2 custom metadata of a secret, keeping other custom metadata.
+// One encoding per secret is enough: all the values are encoded alike and written by a single request
+func (d *VaultSecretDest) recordEncoding(path string, vaultSecret *vault.Secret, encoding mirrorsv1alpha2.VaultEncoding) error {
+	path = strings.Trim(path, "/")
+	metadataPath := kvMetadataPath(path)
+	if metadataPath == path {
+		// KV v1 has no metadata
+		return nil
+	}
+
+	customMetadata := map[string]interface{}{}
+	if vaultSecret != nil {
+		for k, v := range vaultCustomMetadata(vaultSecret) {
+			customMetadata[k] = v
+		}
+	}
+	customMetadata[vaultEncodingMetadataKey] = string(encoding)
+
+	_, err := d.vault.Write(metadataPath, map[string]interface{}{
+		"custom_metadata": customMetadata,
+	})
+	return err
+}
+
+func (d *VaultSecretDest) Plan(ctx context.Context, secret *v1.Secret) ([]mirrorsv1alpha2.DestinationPlan, error) {
+	path := d.secretPath(secret)
+	plan := mirrorsv1alpha2.DestinationPlan{
+		Destination: path,
+	}
+
+	vaultSecret, err := d.vault.ReadSecret(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if vaultSecret == nil {
+		plan.Action = mirrorsv1alpha2.PlanActionCreate
+		plan.ChangedKeys = changedKeys(secret.Data, nil)
+	} else {
+		vaultData, err := d.readData(ctx, vaultSecret)
+		if err != nil {
+			return nil, err
+		}
+
+		plan.ChangedKeys = changedKeys(secret.Data, vaultData)
+		if len(plan.ChangedKeys) > 0 || d.encodingChanged(vaultSecret) {
+			plan.Action = mirrorsv1alpha2.PlanActionUpdate
+		} else {
+			plan.Action = mirrorsv1alpha2.PlanActionNoop
+		}
+	}
+
+	return []mirrorsv1alpha2.DestinationPlan{plan}, nil
+}
+
+// readData returns data of a destination secret, decrypted with a Transit key if configured.
+// Data which is not a Transit ciphertext (e.g. written before transit was enabled) is treated as missing,
+// so it is overwritten. Any other decryption error is returned
+func (d *VaultSecretDest) readData(ctx context.Context, vaultSecret *vault.Secret) (map[string][]byte, error) {
+	transit := d.mirror.Spec.Destination.Vault.Transit
+	if transit == nil {
+		// values are read back as they have been written
+		data, err := extractVaultSecretData(vaultSecret, d.mirror.Spec.Destination.Vault, mirrorsv1alpha2.VaultEncodingAuto)
+		if err != nil && recordedVaultEncoding(vaultSecret) == mirrorsv1alpha2.VaultEncodingBase64 {
+			// the encoding has been recorded but the values have failed to be written, they will be overwritten
+			log.FromContext(ctx).Info("destination data does not match the recorded encoding", "err", err)
+			return extractVaultSecretData(vaultSecret, d.mirror.Spec.Destination.Vault, mirrorsv1alpha2.VaultEncodingRaw)
+		}
+		return data, err
+	}
+
+	vaultData, err := extractVaultSecretData(vaultSecret, d.mirror.Spec.Destination.Vault, mirrorsv1alpha2.VaultEncodingRaw)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range vaultData {
+		if !isTransitCiphertext(string(v)) {
+			log.FromContext(ctx).Info("destination data is not encrypted, it will be overwritten", "key", k)
+			return nil, nil
+		}
+	}
+
+	return transitDecrypt(d.vault, transit, vaultData)
+}
+
+// secretPath returns a path to write a source secret to: <path>/<name> when a source yields many secrets
+func (d *VaultSecretDest) secretPath(secret *v1.Secret) string {
+	if d.mirror.IsMultiSource() {
+		return strings.TrimSuffix(d.path, "/") + "/" + secret.Name
+	}
+	return d.path
+}
+
+// Prune keeps secrets in Vault as Cleanup does
+func (d *VaultSecretDest) Prune(ctx context.Context, names []string) error {
+	_, _ = ctx, names
+	return nil
+}
+
 func (d *VaultSecretDest) Cleanup(ctx context.Context) error {
 	_ = ctx
 	return nil
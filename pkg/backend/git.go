@@ -0,0 +1,304 @@
+package backend
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	mirrorsv1alpha2 "github.com/ktsstudio/mirrors/api/v1alpha2"
+	"github.com/ktsstudio/mirrors/pkg/reconresult"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+func init() {
+	// file:// remotes are served in-process, so no git binary is needed in the controller image
+	client.InstallProtocol("file", server.NewServer(gitFileLoader{}))
+}
+
+// gitFileLoader loads bare repositories and repositories with a working tree from the local filesystem
+type gitFileLoader struct{}
+
+func (gitFileLoader) Load(ep *transport.Endpoint) (storer.Storer, error) {
+	for _, path := range []string{ep.Path, filepath.Join(ep.Path, ".git")} {
+		local := *ep
+		local.Path = path
+		if s, err := server.DefaultLoader.Load(&local); err == nil {
+			return s, nil
+		}
+	}
+	return nil, transport.ErrRepositoryNotFound
+}
+
+// gitRepositories keeps local clones of remotes under a cache directory. A clone is used by one mirror at a time
+type gitRepositories struct {
+	dir   string
+	mutex sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+func makeGitRepositories(dir string) *gitRepositories {
+	return &gitRepositories{
+		dir:   dir,
+		locks: make(map[string]*sync.Mutex),
+	}
+}
+
+// lock returns a path of a local clone of a remote and locks it until unlock is called
+func (r *gitRepositories) lock(remote string) (string, func()) {
+	sum := sha256.Sum256([]byte(remote))
+	path := filepath.Join(r.dir, hex.EncodeToString(sum[:8]))
+
+	r.mutex.Lock()
+	lock, ok := r.locks[path]
+	if !ok {
+		lock = &sync.Mutex{}
+		r.locks[path] = lock
+	}
+	r.mutex.Unlock()
+
+	lock.Lock()
+	return path, lock.Unlock
+}
+
+// gitFetch brings a local clone of a remote up to date, initializing it first.
+// Remote branches are fetched into refs/remotes/origin/*, an empty remote is not an error
+func gitFetch(ctx context.Context, path, remote string, auth transport.AuthMethod) (*git.Repository, error) {
+	repo, err := git.PlainOpen(path)
+	if errors.Is(err, git.ErrRepositoryNotExists) {
+		if repo, err = git.PlainInit(path, false); err != nil {
+			return nil, err
+		}
+		_, err = repo.CreateRemote(&config.RemoteConfig{
+			Name: git.DefaultRemoteName,
+			URLs: []string{remote},
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = repo.FetchContext(ctx, &git.FetchOptions{
+		RemoteName: git.DefaultRemoteName,
+		RefSpecs: []config.RefSpec{
+			"+refs/heads/*:refs/remotes/origin/*",
+			"+refs/tags/*:refs/tags/*",
+		},
+		Auth:  auth,
+		Force: true,
+	})
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) && !errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		return nil, err
+	}
+	return repo, nil
+}
+
+// gitResolve returns a commit of a branch, a tag or a full commit hash, nil when there is none
+func gitResolve(repo *git.Repository, revision string) (*object.Commit, error) {
+	for _, name := range []string{"refs/remotes/origin/" + revision, "refs/tags/" + revision} {
+		ref, err := repo.Reference(plumbing.ReferenceName(name), true)
+		if errors.Is(err, plumbing.ErrReferenceNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if tag, err := repo.TagObject(ref.Hash()); err == nil {
+			// an annotated tag
+			return tag.Commit()
+		}
+		return repo.CommitObject(ref.Hash())
+	}
+
+	if plumbing.IsHash(revision) {
+		commit, err := repo.CommitObject(plumbing.NewHash(revision))
+		if errors.Is(err, plumbing.ErrObjectNotFound) {
+			return nil, nil
+		}
+		return commit, err
+	}
+	return nil, nil
+}
+
+// gitReadFile returns contents of a file in a commit, nil when there is no such file
+func gitReadFile(commit *object.Commit, path string) ([]byte, error) {
+	file, err := commit.File(strings.TrimPrefix(path, "/"))
+	if errors.Is(err, object.ErrFileNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	contents, err := file.Contents()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(contents), nil
+}
+
+// gitFileExtension returns an extension of files a destination writes when a source yields many secrets
+func gitFileExtension(format mirrorsv1alpha2.GitFileFormat) string {
+	switch format {
+	case mirrorsv1alpha2.GitFileFormatJSON:
+		return ".json"
+	case mirrorsv1alpha2.GitFileFormatDotenv:
+		return ".env"
+	}
+	return ".yaml"
+}
+
+// gitError reports a failure to talk to a git remote
+func gitError(message string, err error) error {
+	return &reconresult.ReconcileResult{
+		Message:     fmt.Sprintf("%s: %s", message, err),
+		Status:      mirrorsv1alpha2.MirrorStatusError,
+		EventType:   v1.EventTypeWarning,
+		EventReason: "GitError",
+	}
+}
+
+// gitAuth returns credentials of a remote from spec.auth.secretRef
+func (b *SecretMirrorBackend) gitAuth(ctx context.Context, spec *mirrorsv1alpha2.GitSpec) (transport.AuthMethod, error) {
+	if spec.Auth.SecretRef == nil {
+		return nil, nil
+	}
+
+	secretName := types.NamespacedName{
+		Namespace: spec.Auth.SecretRef.Namespace,
+		Name:      spec.Auth.SecretRef.Name,
+	}
+	secret, err := FetchSecret(ctx, b, secretName)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil {
+		return nil, &reconresult.ReconcileResult{
+			Message:     fmt.Sprintf("secret %s with git credentials not found", secretName),
+			Status:      mirrorsv1alpha2.MirrorStatusPending,
+			EventType:   v1.EventTypeWarning,
+			EventReason: "GitAuthMissing",
+		}
+	}
+
+	if !spec.IsSSH() {
+		password := string(secret.Data[spec.Auth.PasswordKey])
+		if password == "" {
+			return nil, nil
+		}
+		username := string(secret.Data[spec.Auth.UsernameKey])
+		if username == "" {
+			username = "git"
+		}
+		return &githttp.BasicAuth{Username: username, Password: password}, nil
+	}
+
+	key, knownHosts := secret.Data[spec.Auth.SSHKeyKey], secret.Data[spec.Auth.KnownHostsKey]
+	if len(key) == 0 || len(knownHosts) == 0 {
+		return nil, &reconresult.ReconcileResult{
+			Message: fmt.Sprintf("cannot find an ssh key and known hosts under secret %s and keys %s, %s",
+				secretName, spec.Auth.SSHKeyKey, spec.Auth.KnownHostsKey),
+			Status:      mirrorsv1alpha2.MirrorStatusPending,
+			EventType:   v1.EventTypeWarning,
+			EventReason: "GitAuthMissing",
+		}
+	}
+
+	auth, err := gitssh.NewPublicKeys(gitSSHUser(spec.URL), key, "")
+	if err != nil {
+		return nil, invalidGitAuth(secretName, err)
+	}
+	if auth.HostKeyCallback, err = knownHostsCallback(knownHosts); err != nil {
+		return nil, invalidGitAuth(secretName, err)
+	}
+	return auth, nil
+}
+
+func invalidGitAuth(secretName types.NamespacedName, err error) error {
+	return &reconresult.ReconcileResult{
+		Message:     fmt.Sprintf("invalid git credentials in secret %s: %s", secretName, err),
+		Status:      mirrorsv1alpha2.MirrorStatusError,
+		EventType:   v1.EventTypeWarning,
+		EventReason: "GitAuthInvalid",
+	}
+}
+
+// gitSSHUser returns a user of an ssh:// or user@host:path URL, git by default
+func gitSSHUser(remote string) string {
+	if strings.HasPrefix(remote, "ssh://") {
+		if u, err := url.Parse(remote); err == nil && u.User != nil && u.User.Username() != "" {
+			return u.User.Username()
+		}
+		return "git"
+	}
+	if pos := strings.Index(remote, "@"); pos > 0 && pos < strings.Index(remote, ":") {
+		return remote[:pos]
+	}
+	return "git"
+}
+
+// knownHostsCallback verifies host keys against known_hosts entries, which knownhosts only reads from files
+func knownHostsCallback(knownHosts []byte) (ssh.HostKeyCallback, error) {
+	file, err := os.CreateTemp("", "known_hosts")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(knownHosts); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := file.Close(); err != nil {
+		return nil, err
+	}
+	return knownhosts.New(file.Name())
+}
+
+// sopsKeys returns keys of spec.sops.keySecretRef
+func (b *SecretMirrorBackend) sopsKeys(ctx context.Context, spec *mirrorsv1alpha2.SOPSSpec) (*sopsKeys, error) {
+	secretName := types.NamespacedName{
+		Namespace: spec.KeySecretRef.Namespace,
+		Name:      spec.KeySecretRef.Name,
+	}
+	secret, err := FetchSecret(ctx, b, secretName)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil {
+		return nil, &reconresult.ReconcileResult{
+			Message:     fmt.Sprintf("secret %s with sops keys not found", secretName),
+			Status:      mirrorsv1alpha2.MirrorStatusPending,
+			EventType:   v1.EventTypeWarning,
+			EventReason: "SOPSKeyMissing",
+		}
+	}
+
+	keys, err := parseSOPSKeys(secret.Data[spec.AgeKeyKey], secret.Data[spec.PGPKeyKey])
+	if err != nil {
+		return nil, &reconresult.ReconcileResult{
+			Message: fmt.Sprintf("invalid sops keys in secret %s under keys %s, %s: %s",
+				secretName, spec.AgeKeyKey, spec.PGPKeyKey, err),
+			Status:      mirrorsv1alpha2.MirrorStatusError,
+			EventType:   v1.EventTypeWarning,
+			EventReason: "SOPSKeyInvalid",
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,214 @@
+package backend
+
+import (
+	"context"
+	"filippo.io/age"
+	"github.com/go-git/go-git/v5"
+	mirrorsv1alpha2 "github.com/ktsstudio/mirrors/api/v1alpha2"
+	"golang.org/x/time/rate"
+	v1 "k8s.io/api/core/v1"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testSOPSKeys(t *testing.T) *sopsKeys {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := parseSOPSKeys([]byte(identity.String()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+// testdataSOPSKeys returns keys of files in testdata/sops, encrypted with the sops 3.7.3 CLI
+func testdataSOPSKeys(t *testing.T) (ageKeys, pgpKeys *sopsKeys) {
+	ageKey, err := os.ReadFile("testdata/sops/age.agekey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pgpKey, err := os.ReadFile("testdata/sops/sops.asc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ageKeys, err = parseSOPSKeys(ageKey, nil); err != nil {
+		t.Fatal(err)
+	}
+	if pgpKeys, err = parseSOPSKeys(nil, pgpKey); err != nil {
+		t.Fatal(err)
+	}
+	return ageKeys, pgpKeys
+}
+
+func TestSOPSDecryptCLIFiles(t *testing.T) {
+	ageKeys, pgpKeys := testdataSOPSKeys(t)
+	for _, test := range []struct {
+		file     string
+		format   mirrorsv1alpha2.GitFileFormat
+		keys     *sopsKeys
+		expected map[string][]byte
+	}{
+		{
+			file:   "secrets.yaml",
+			format: mirrorsv1alpha2.GitFileFormatYAML,
+			keys:   ageKeys,
+			expected: map[string][]byte{
+				"username":       []byte("admin"),
+				"password":       []byte("p@ss: \"word\"\n"),
+				"db.port":        []byte("5432"),
+				"db.tls":         []byte("true"),
+				"hosts":          []byte(`["a.example.com","b.example.com"]`),
+				"id_unencrypted": []byte("42"),
+			},
+		},
+		{
+			file:     "secrets.json",
+			format:   mirrorsv1alpha2.GitFileFormatJSON,
+			keys:     ageKeys,
+			expected: map[string][]byte{"username": []byte("admin"), "db.port": []byte("5432")},
+		},
+		{
+			file:     "secrets.env",
+			format:   mirrorsv1alpha2.GitFileFormatDotenv,
+			keys:     ageKeys,
+			expected: map[string][]byte{"username": []byte("admin"), "password": []byte("s3cr3t")},
+		},
+		{
+			file:     "secrets.pgp.yaml",
+			format:   mirrorsv1alpha2.GitFileFormatYAML,
+			keys:     pgpKeys,
+			expected: map[string][]byte{"username": []byte("admin"), "password": []byte("s3cr3t")},
+		},
+	} {
+		contents, err := os.ReadFile(filepath.Join("testdata/sops", test.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		decrypted, err := sopsDecrypt(contents, test.format, test.keys)
+		if err != nil {
+			t.Fatalf("%s: %s", test.file, err)
+		}
+		if dataDiffer(test.expected, decrypted) {
+			t.Fatalf("%s: unexpected decrypted data %q", test.file, decrypted)
+		}
+
+		// the other kind of key cannot decrypt the data key
+		other := ageKeys
+		if test.keys == ageKeys {
+			other = pgpKeys
+		}
+		if _, err := sopsDecrypt(contents, test.format, other); err == nil {
+			t.Fatalf("%s: decrypted without a matching key", test.file)
+		}
+	}
+}
+
+func TestSOPSDecryptTampered(t *testing.T) {
+	ageKeys, _ := testdataSOPSKeys(t)
+	contents, err := os.ReadFile("testdata/sops/secrets.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the unencrypted value is covered by the MAC
+	tampered := strings.Replace(string(contents), `id_unencrypted: "42"`, `id_unencrypted: "43"`, 1)
+	if _, err := sopsDecrypt([]byte(tampered), mirrorsv1alpha2.GitFileFormatYAML, ageKeys); err == nil {
+		t.Fatal("tampered file has been decrypted")
+	}
+}
+
+func TestSOPSRoundTrip(t *testing.T) {
+	_, pgpKeys := testdataSOPSKeys(t)
+	data := map[string][]byte{
+		"username":       []byte("admin"),
+		"password":       []byte("p@ss: \"word\"\n"),
+		"tls.crt":        []byte("-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n"),
+		"id_unencrypted": []byte("42"),
+	}
+
+	for _, keys := range []*sopsKeys{testSOPSKeys(t), pgpKeys} {
+		for _, format := range []mirrorsv1alpha2.GitFileFormat{
+			mirrorsv1alpha2.GitFileFormatYAML,
+			mirrorsv1alpha2.GitFileFormatJSON,
+			mirrorsv1alpha2.GitFileFormatDotenv,
+		} {
+			contents, err := sopsEncrypt(data, format, keys, nil)
+			if err != nil {
+				t.Fatalf("%s: %s", format, err)
+			}
+			if strings.Contains(string(contents), "admin") {
+				t.Fatalf("%s: plaintext value in an encrypted file:\n%s", format, contents)
+			}
+			decrypted, err := sopsDecrypt(contents, format, keys)
+			if err != nil {
+				t.Fatalf("%s: %s\n%s", format, err, contents)
+			}
+			if dataDiffer(data, decrypted) {
+				t.Fatalf("%s: unexpected decrypted data %q", format, decrypted)
+			}
+		}
+	}
+}
+
+func TestGitDestAndSource(t *testing.T) {
+	ctx := context.Background()
+	remote := t.TempDir()
+	if _, err := git.PlainInit(remote, true); err != nil {
+		t.Fatal(err)
+	}
+	keys := testSOPSKeys(t)
+	repos := makeGitRepositories(t.TempDir())
+	spec := &mirrorsv1alpha2.GitSpec{
+		URL:  "file://" + filepath.ToSlash(remote),
+		Path: "apps/db/secrets.yaml",
+		SOPS: mirrorsv1alpha2.SOPSSpec{KeySecretRef: v1.SecretReference{Name: "sops"}},
+	}
+	spec.Default("default")
+
+	var mirror mirrorsv1alpha2.SecretMirror
+	mirror.Namespace, mirror.Name = "default", "db"
+	mirror.Spec.Source.Type = mirrorsv1alpha2.SourceTypeGit
+	mirror.Spec.Source.Git = spec
+	mirror.Spec.Destination.Type = mirrorsv1alpha2.DestTypeGit
+	mirror.Spec.Destination.Git = spec
+
+	dest := &GitSecretDest{
+		mirror:       &mirror,
+		repos:        repos,
+		keys:         keys,
+		writeLimiter: rate.NewLimiter(rate.Inf, 1),
+	}
+	source := &GitSecretSource{
+		mirror: &mirror,
+		repos:  repos,
+		keys:   keys,
+	}
+
+	for _, password := range []string{"first", "second"} {
+		var secret v1.Secret
+		secret.Namespace, secret.Name = "default", "db"
+		secret.Data = map[string][]byte{"password": []byte(password)}
+		if err := dest.Sync(ctx, &secret); err != nil {
+			t.Fatal(err)
+		}
+
+		retrieved, err := source.Retrieve(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(retrieved.Data["password"]) != password {
+			t.Fatalf("unexpected data %q, expected password %s", retrieved.Data, password)
+		}
+	}
+
+	plans, err := dest.Plan(ctx, &v1.Secret{Data: map[string][]byte{"password": []byte("second")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plans[0].Action != mirrorsv1alpha2.PlanActionNoop {
+		t.Fatalf("unexpected plan action %s", plans[0].Action)
+	}
+}
@@ -3,9 +3,17 @@ package backend
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	mirrorsv1alpha2 "github.com/ktsstudio/mirrors/api/v1alpha2"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sort"
+	"strings"
+	"unicode"
 )
 
 func dataDiffer(src, dest map[string][]byte) bool {
@@ -21,6 +29,44 @@ func dataDiffer(src, dest map[string][]byte) bool {
 	return false
 }
 
+// hashSecretData returns a stable sha256 digest of secret data
+func hashSecretData(data map[string][]byte) string {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	size := make([]byte, 8)
+	for _, k := range keys {
+		binary.BigEndian.PutUint64(size, uint64(len(k)))
+		h.Write(size)
+		h.Write([]byte(k))
+		binary.BigEndian.PutUint64(size, uint64(len(data[k])))
+		h.Write(size)
+		h.Write(data[k])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// changedKeys returns sorted names of the keys which differ between src and dest
+func changedKeys(src, dest map[string][]byte) []string {
+	var keys []string
+	for k := range src {
+		if v, ok := dest[k]; !ok || !bytes.Equal(src[k], v) {
+			keys = append(keys, k)
+		}
+	}
+	for k := range dest {
+		if _, ok := src[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 func secretDiffer(src, dest *v1.Secret) bool {
 	for k := range src.Labels {
 		if src.Labels[k] != dest.Labels[k] {
@@ -80,3 +126,35 @@ func FetchSecret(ctx context.Context, cli client.Client, name types.NamespacedNa
 	}
 	return &secret, nil
 }
+
+// kvMetadataPath turns a KV v2 data path (<mount>/data/<path>) into a path to list its keys (<mount>/metadata/<path>).
+// Other paths (e.g. KV v1) are listed as is
+func kvMetadataPath(path string) string {
+	parts := strings.SplitN(path, "/", 3)
+	if len(parts) >= 2 && parts[1] == "data" {
+		parts[1] = "metadata"
+	}
+	return strings.Join(parts, "/")
+}
+
+// secretNameFromPath derives a Kubernetes secret name from a relative path (e.g. team/db -> team-db)
+func secretNameFromPath(path string) (string, bool) {
+	name := strings.Map(func(r rune) rune {
+		r = unicode.ToLower(r)
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '.' || r == '-' {
+			return r
+		}
+		return '-'
+	}, strings.Trim(path, "/"))
+	name = strings.Trim(name, "-.")
+
+	return name, name != "" && len(validation.IsDNS1123Subdomain(name)) == 0
+}
+
+// destSecretName returns a name of the copies of a source secret
+func destSecretName(mirror *mirrorsv1alpha2.SecretMirror, secret *v1.Secret) string {
+	if mirror.IsMultiSource() {
+		return secret.Name
+	}
+	return mirror.Spec.Source.Name
+}
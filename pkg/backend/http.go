@@ -0,0 +1,239 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	mirrorsv1alpha2 "github.com/ktsstudio/mirrors/api/v1alpha2"
+	"github.com/ktsstudio/mirrors/pkg/reconresult"
+	"io"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	// first delay of a retried request, doubled by the backoff of consecutive failures of a mirror
+	httpRetryDelay     = time.Second
+	httpDefaultTimeout = 10 * time.Second
+)
+
+// HTTPBodyData holds variables available in a request body template of an http destination
+type HTTPBodyData struct {
+	// Namespace of a SecretMirror
+	Namespace string
+	// Name of a SecretMirror
+	Name string
+	// Name of a source secret
+	SourceName string
+	// Keys and values of a source secret
+	Data map[string]string
+}
+
+// parseHTTPBodyTemplate parses a request body template. Referencing an unset variable is an error
+func parseHTTPBodyTemplate(text string) (*template.Template, error) {
+	return template.New("body").Option("missingkey=error").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			encoded, err := json.Marshal(v)
+			return string(encoded), err
+		},
+		"b64enc": func(s string) string {
+			return base64.StdEncoding.EncodeToString([]byte(s))
+		},
+	}).Parse(text)
+}
+
+// httpClient delivers secrets to an endpoint of an http destination
+type httpClient struct {
+	http       *http.Client
+	spec       *mirrorsv1alpha2.HTTPSpec
+	token      string
+	username   string
+	password   string
+	hmacKey    []byte
+	bodyFormat *template.Template
+}
+
+// httpStatusError reports an unsuccessful response. A response body is never included, as an endpoint may echo secrets
+type httpStatusError struct {
+	StatusCode int
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("endpoint returned %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// body renders a request body: a template or a JSON object of keys and values
+func (c *httpClient) body(data HTTPBodyData) ([]byte, error) {
+	if c.bodyFormat == nil {
+		return json.Marshal(data.Data)
+	}
+	var body bytes.Buffer
+	if err := c.bodyFormat.Execute(&body, data); err != nil {
+		return nil, err
+	}
+	return body.Bytes(), nil
+}
+
+// sign returns an HMAC-SHA256 signature of a body as sha256=<hex>
+func (c *httpClient) sign(body []byte) string {
+	h := hmac.New(sha256.New, c.hmacKey)
+	h.Write(body)
+	return "sha256=" + hex.EncodeToString(h.Sum(nil))
+}
+
+// send delivers a body in a single request. It returns a status code of the response, zero when there was none
+func (c *httpClient) send(ctx context.Context, body []byte) (int, error) {
+	timeout := time.Duration(c.spec.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = httpDefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, strings.ToUpper(c.spec.Method), c.spec.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	for k, v := range c.spec.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", c.spec.ContentType)
+	switch {
+	case c.token != "":
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	case c.username != "" || c.password != "":
+		req.SetBasicAuth(c.username, c.password)
+	}
+	if c.hmacKey != nil {
+		req.Header.Set(c.spec.Signature.Header, c.sign(body))
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1024*1024))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, &httpStatusError{StatusCode: resp.StatusCode}
+	}
+	return resp.StatusCode, nil
+}
+
+// isHTTPRetryable reports whether a failed request may succeed later: a network error (no status code), 429 or 5xx
+func isHTTPRetryable(statusCode int) bool {
+	return statusCode == 0 || statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
+
+func httpError(message string, err error) error {
+	return &reconresult.ReconcileResult{
+		Message:     fmt.Sprintf("%s: %s", message, err),
+		Status:      mirrorsv1alpha2.MirrorStatusError,
+		EventType:   v1.EventTypeWarning,
+		EventReason: "HTTPError",
+	}
+}
+
+// makeHTTP returns a client of an endpoint with credentials of spec.auth.secretRef and an HMAC key
+// of spec.signature.secretRef
+func (b *SecretMirrorBackend) makeHTTP(ctx context.Context, spec *mirrorsv1alpha2.HTTPSpec) (*httpClient, error) {
+	client := &httpClient{
+		spec: spec,
+	}
+	if spec.Template != "" {
+		var err error
+		if client.bodyFormat, err = parseHTTPBodyTemplate(spec.Template); err != nil {
+			return nil, httpError("Error parsing http.template", err)
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if spec.Auth.SecretRef != nil {
+		secretName := types.NamespacedName{
+			Namespace: spec.Auth.SecretRef.Namespace,
+			Name:      spec.Auth.SecretRef.Name,
+		}
+		secret, err := FetchSecret(ctx, b, secretName)
+		if err != nil {
+			return nil, err
+		}
+		if secret == nil {
+			return nil, &reconresult.ReconcileResult{
+				Message:     fmt.Sprintf("secret %s with http credentials not found", secretName),
+				Status:      mirrorsv1alpha2.MirrorStatusPending,
+				EventType:   v1.EventTypeWarning,
+				EventReason: "HTTPAuthMissing",
+			}
+		}
+
+		client.token = string(secret.Data[spec.Auth.TokenKey])
+		client.username = string(secret.Data[spec.Auth.UsernameKey])
+		client.password = string(secret.Data[spec.Auth.PasswordKey])
+		if transport.TLSClientConfig, err = httpTLSConfig(secret, &spec.Auth); err != nil {
+			return nil, &reconresult.ReconcileResult{
+				Message:     fmt.Sprintf("invalid http credentials in secret %s: %s", secretName, err),
+				Status:      mirrorsv1alpha2.MirrorStatusError,
+				EventType:   v1.EventTypeWarning,
+				EventReason: "HTTPAuthInvalid",
+			}
+		}
+	}
+	client.http = &http.Client{Transport: transport}
+
+	if spec.Signature != nil {
+		secretName := types.NamespacedName{
+			Namespace: spec.Signature.SecretRef.Namespace,
+			Name:      spec.Signature.SecretRef.Name,
+		}
+		secret, err := FetchSecret(ctx, b, secretName)
+		if err != nil {
+			return nil, err
+		}
+		if secret == nil || len(secret.Data[spec.Signature.Key]) == 0 {
+			return nil, &reconresult.ReconcileResult{
+				Message:     fmt.Sprintf("cannot find an hmac key under secret %s and key %s", secretName, spec.Signature.Key),
+				Status:      mirrorsv1alpha2.MirrorStatusPending,
+				EventType:   v1.EventTypeWarning,
+				EventReason: "HTTPSignatureKeyMissing",
+			}
+		}
+		client.hmacKey = secret.Data[spec.Signature.Key]
+	}
+
+	return client, nil
+}
+
+// httpTLSConfig returns a TLS configuration with a client certificate and CA certificates of a secret, nil without both
+func httpTLSConfig(secret *v1.Secret, auth *mirrorsv1alpha2.HTTPAuthSpec) (*tls.Config, error) {
+	cert, key, ca := secret.Data[auth.CertKey], secret.Data[auth.KeyKey], secret.Data[auth.CAKey]
+	if len(cert) == 0 && len(key) == 0 && len(ca) == 0 {
+		return nil, nil
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if len(cert) > 0 || len(key) > 0 {
+		certificate, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	if len(ca) > 0 {
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, errors.New("no CA certificates found")
+		}
+	}
+	return config, nil
+}
@@ -0,0 +1,175 @@
+package backend
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	mirrorsv1alpha2 "github.com/ktsstudio/mirrors/api/v1alpha2"
+	"github.com/ktsstudio/mirrors/pkg/reconresult"
+	"golang.org/x/time/rate"
+	"io"
+	v1 "k8s.io/api/core/v1"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func testHTTPDest(t *testing.T, server *httptest.Server, spec *mirrorsv1alpha2.HTTPSpec, hmacKey []byte) *HTTPSecretDest {
+	spec.URL = server.URL
+	spec.Default("default")
+
+	var mirror mirrorsv1alpha2.SecretMirror
+	mirror.Namespace, mirror.Name = "default", "db"
+	mirror.Spec.Destination.Type = mirrorsv1alpha2.DestTypeHTTP
+	mirror.Spec.Destination.HTTP = spec
+
+	client := &httpClient{
+		http:    server.Client(),
+		spec:    spec,
+		token:   "token",
+		hmacKey: hmacKey,
+	}
+	if spec.Template != "" {
+		bodyFormat, err := parseHTTPBodyTemplate(spec.Template)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client.bodyFormat = bodyFormat
+	}
+	return &HTTPSecretDest{
+		mirror:       &mirror,
+		client:       client,
+		writeLimiter: rate.NewLimiter(rate.Inf, 1),
+	}
+}
+
+func TestHTTPSecretDestSync(t *testing.T) {
+	hmacKey := []byte("hmac")
+	var requests int
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		body, _ := io.ReadAll(r.Body)
+		h := hmac.New(sha256.New, hmacKey)
+		h.Write(body)
+		if r.Method != http.MethodPut || r.Header.Get("Authorization") != "Bearer token" ||
+			r.Header.Get("X-Mirrors-Signature") != "sha256="+hex.EncodeToString(h.Sum(nil)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		bodies = append(bodies, string(body))
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	dest := testHTTPDest(t, server, &mirrorsv1alpha2.HTTPSpec{
+		Method:   http.MethodPut,
+		Template: `{"name":{{ json .SourceName }},"password":{{ json (b64enc .Data.password) }}}`,
+		Signature: &mirrorsv1alpha2.HTTPSignatureSpec{
+			SecretRef: v1.SecretReference{Name: "hmac"},
+		},
+	}, hmacKey)
+
+	var secret v1.Secret
+	secret.Namespace, secret.Name = "default", "db"
+	secret.Data = map[string][]byte{"password": []byte("s3cr3t")}
+	for i := 0; i < 2; i++ {
+		if err := dest.Sync(context.Background(), &secret); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// an unchanged secret is not sent again
+	if requests != 1 || len(bodies) != 1 {
+		t.Fatalf("unexpected %d requests, bodies %q", requests, bodies)
+	}
+	if bodies[0] != `{"name":"db","password":"czNjcjN0"}` {
+		t.Fatalf("unexpected body %s", bodies[0])
+	}
+	if dest.mirror.Status.HTTPDestination.LastStatusCode != http.StatusAccepted {
+		t.Fatalf("unexpected status %d", dest.mirror.Status.HTTPDestination.LastStatusCode)
+	}
+	plans, err := dest.Plan(context.Background(), &secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plans[0].Action != mirrorsv1alpha2.PlanActionNoop {
+		t.Fatalf("unexpected plan action %s", plans[0].Action)
+	}
+
+	secret.Data["password"] = []byte("rotated")
+	if err := dest.Sync(context.Background(), &secret); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 2 || len(bodies) != 2 {
+		t.Fatalf("changed secret is not sent: %d requests", requests)
+	}
+}
+
+func TestHTTPSecretDestRetry(t *testing.T) {
+	ctx := context.Background()
+	for _, test := range []struct {
+		statusCode int
+		retried    bool
+	}{
+		{statusCode: http.StatusInternalServerError, retried: true},
+		{statusCode: http.StatusServiceUnavailable, retried: true},
+		{statusCode: http.StatusTooManyRequests, retried: true},
+		{statusCode: http.StatusBadRequest},
+		{statusCode: http.StatusUnauthorized},
+		{statusCode: http.StatusNotFound},
+	} {
+		var requests int
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			w.WriteHeader(test.statusCode)
+		}))
+		dest := testHTTPDest(t, server, &mirrorsv1alpha2.HTTPSpec{}, nil)
+
+		err := dest.Sync(ctx, testSourceSecret("secret"))
+		server.Close()
+		result, ok := err.(*reconresult.ReconcileResult)
+		if !ok || result.EventReason != "HTTPDeliveryFailed" || result.Status != mirrorsv1alpha2.MirrorStatusError {
+			t.Fatalf("%d: expected a delivery failure, got %v", test.statusCode, err)
+		}
+		// the request is never repeated within a sync, a worker does not wait for a retry
+		if requests != 1 {
+			t.Fatalf("%d: unexpected %d requests", test.statusCode, requests)
+		}
+		if retried := result.RequeueAfter == httpRetryDelay; retried != test.retried {
+			t.Fatalf("%d: unexpected requeue after %s", test.statusCode, result.RequeueAfter)
+		}
+		if dest.mirror.Status.HTTPDestination.LastStatusCode != test.statusCode {
+			t.Fatalf("%d: unexpected last status %d", test.statusCode, dest.mirror.Status.HTTPDestination.LastStatusCode)
+		}
+		if len(dest.mirror.Status.HTTPDestination.ContentHashes) != 0 {
+			t.Fatalf("%d: a failed request is recorded as delivered", test.statusCode)
+		}
+	}
+}
+
+func TestHTTPSecretDestRetryLimit(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	dest := testHTTPDest(t, server, &mirrorsv1alpha2.HTTPSpec{}, nil)
+	// a network error is retried as well
+	server.Close()
+
+	for failures := int32(0); failures <= *dest.mirror.Spec.Destination.HTTP.MaxRetries; failures++ {
+		dest.mirror.Status.ConsecutiveFailures = failures
+		err := dest.Sync(ctx, testSourceSecret("secret"))
+		result, ok := err.(*reconresult.ReconcileResult)
+		if !ok || result.EventReason != "HTTPDeliveryFailed" {
+			t.Fatalf("expected a delivery failure, got %v", err)
+		}
+		// once retries are used up, the sync is retried on the schedule of the mirror
+		expected := httpRetryDelay
+		if failures == *dest.mirror.Spec.Destination.HTTP.MaxRetries {
+			expected = 0
+		}
+		if result.RequeueAfter != expected {
+			t.Fatalf("%d failures: unexpected requeue after %s", failures, result.RequeueAfter)
+		}
+	}
+}
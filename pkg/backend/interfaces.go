@@ -2,6 +2,7 @@ package backend
 
 import (
 	"context"
+	mirrorsv1alpha2 "github.com/ktsstudio/mirrors/api/v1alpha2"
 	v1 "k8s.io/api/core/v1"
 )
 
@@ -10,8 +11,33 @@ type SourceRetriever interface {
 	Retrieve(ctx context.Context) (*v1.Secret, error)
 }
 
+// MultiSourceRetriever is implemented by sources which may yield many secrets, each mirrored under its own name
+type MultiSourceRetriever interface {
+	SourceRetriever
+	RetrieveAll(ctx context.Context) ([]*v1.Secret, error)
+}
+
+// SourceCleaner is implemented by sources holding external resources, e.g. Vault leases
+type SourceCleaner interface {
+	// AfterSync releases resources superseded by a newer source secret. synced reports
+	// whether the newer secret has just been propagated to destinations
+	AfterSync(ctx context.Context, synced bool) error
+	// Cleanup releases all the resources of a deleted mirror
+	Cleanup(ctx context.Context) error
+}
+
+// SourcePlanner is implemented by sources whose retrieval has side effects, e.g. issuing certificates or
+// creating and renewing leases. A dry run reads source secrets with RetrieveForPlan, which has none
+type SourcePlanner interface {
+	// RetrieveForPlan returns the secrets a sync would mirror, or an error if they cannot be read without side effects
+	RetrieveForPlan(ctx context.Context) ([]*v1.Secret, error)
+}
+
 type DestSyncer interface {
 	Setup(ctx context.Context) error
 	Sync(ctx context.Context, secret *v1.Secret) error
+	Plan(ctx context.Context, secret *v1.Secret) ([]mirrorsv1alpha2.DestinationPlan, error)
+	// Prune removes copies of secrets named names which are no longer in the source
+	Prune(ctx context.Context, names []string) error
 	Cleanup(ctx context.Context) error
 }
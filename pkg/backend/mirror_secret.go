@@ -9,14 +9,19 @@ import (
 	"github.com/ktsstudio/mirrors/pkg/reconresult"
 	"github.com/panjf2000/ants/v2"
 	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/record"
+	"os"
+	"path/filepath"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -37,6 +42,9 @@ func (c *SecretMirrorContext) Init(ctx context.Context, name types.NamespacedNam
 
 	c.SecretMirror = &secretMirror
 	c.SecretMirror.Default()
+	if c.SecretMirror.Spec.PollPeriodSeconds == 0 {
+		c.SecretMirror.Spec.PollPeriodSeconds = c.backend.defaultPollPeriodSeconds
+	}
 
 	return nil
 }
@@ -71,6 +79,7 @@ func (c *SecretMirrorContext) SetupOrRunFinalizer(ctx context.Context) (bool, er
 				return false, err
 			}
 			if c.SecretMirror.Spec.DeletePolicy == mirrorsv1alpha2.DeletePolicyDelete {
+				c.cleanupSource(ctx)
 				logger.Info("deleted managed objects")
 			} else {
 				logger.Info("retaining all managed secrets")
@@ -99,13 +108,26 @@ func (c *SecretMirrorContext) SetStatus(ctx context.Context, status mirrorsv1alp
 		if c.SecretMirror.Status.LastSyncTime.IsZero() {
 			c.SecretMirror.Status.LastSyncTime = metav1.Unix(0, 0)
 		}
-	} else {
+	} else if status != mirrorsv1alpha2.MirrorStatusSuspended {
 		c.SecretMirror.Status.LastSyncTime = metav1.Now()
 	}
 	return c.backend.Status().Update(ctx, c.SecretMirror)
 }
 
-func (c *SecretMirrorContext) Sync(ctx context.Context) error {
+func (c *SecretMirrorContext) Sync(ctx context.Context) (err error) {
+	resumed := c.SecretMirror.Status.MirrorStatus == mirrorsv1alpha2.MirrorStatusSuspended
+	if c.SecretMirror.Spec.Suspend {
+		res := &reconresult.ReconcileResult{
+			Message: "mirror is suspended",
+			Status:  mirrorsv1alpha2.MirrorStatusSuspended,
+		}
+		if !resumed {
+			res.EventType = v1.EventTypeNormal
+			res.EventReason = "Suspended"
+		}
+		return res
+	}
+
 	if c.SecretMirror.Status.MirrorStatus == "" {
 		if err := c.SetStatus(ctx, mirrorsv1alpha2.MirrorStatusPending); err != nil {
 			return err
@@ -131,22 +153,76 @@ func (c *SecretMirrorContext) Sync(ctx context.Context) error {
 
 	// only check after we have set up everything (e.g. registered namespaces in nsKeeper)
 	now := time.Now()
-	nextSyncAt := c.SecretMirror.Status.LastSyncTime.Time.Add(c.SecretMirror.PollPeriodDuration())
-	if now.Before(nextSyncAt) {
+	nextSyncAt := c.SecretMirror.NextSyncAt(c.SecretMirror.Status.LastSyncTime.Time)
+	if c.SecretMirror.Status.ConsecutiveFailures > 0 && c.SecretMirror.Status.NextRetryTime != nil {
+		nextSyncAt = c.SecretMirror.Status.NextRetryTime.Time
+	}
+	syncRequest := c.SecretMirror.Annotations[syncRequestedAtAnnotation]
+	syncRequested := syncRequest != "" && syncRequest != c.SecretMirror.Status.LastHandledSyncRequest
+	// a requested sync does not wait for the next poll, but still backs off after failures
+	waitForSync := !syncRequested || c.SecretMirror.Status.ConsecutiveFailures > 0
+	if !resumed && waitForSync && now.Before(nextSyncAt) {
 		return &reconresult.ReconcileResult{
 			Message:      fmt.Sprintf("no need to sync. next sync at %s", nextSyncAt),
 			RequeueAfter: nextSyncAt.Sub(now),
 		}
 	}
+	if syncRequested {
+		log.FromContext(ctx).Info("sync requested", "syncRequestedAt", syncRequest)
+		// the request is handled once it succeeds, a failed one is retried
+		defer func() {
+			if syncSucceeded(err) {
+				c.SecretMirror.Status.LastHandledSyncRequest = syncRequest
+			}
+		}()
+	}
+
+	if c.SecretMirror.Spec.DryRun {
+		sourceSecrets, err := c.retrieveSourceSecretsForPlan(ctx, sourceRetriever)
+		if err != nil {
+			return err
+		}
+
+		var plan []mirrorsv1alpha2.DestinationPlan
+		for _, sourceSecret := range sourceSecrets {
+			secretPlan, err := destSyncer.Plan(ctx, sourceSecret)
+			if err != nil {
+				return err
+			}
+			plan = append(plan, secretPlan...)
+		}
+		c.SecretMirror.Status.Plan = plan
+		return &reconresult.ReconcileResult{
+			Message: fmt.Sprintf("dry run: planned changes for %d destinations", len(plan)),
+			Status:  mirrorsv1alpha2.MirrorStatusDryRun,
+		}
+	}
+	c.SecretMirror.Status.Plan = nil
 
-	sourceSecret, err := sourceRetriever.Retrieve(ctx)
+	sourceSecrets, err := c.retrieveSourceSecrets(ctx, sourceRetriever)
+	synced := false
+	defer func() {
+		c.afterSync(ctx, sourceRetriever, synced)
+	}()
 	if err != nil {
 		return err
 	}
 
-	if err := destSyncer.Sync(ctx, sourceSecret); err != nil {
-		return err
+	var syncErr error
+	for _, sourceSecret := range sourceSecrets {
+		if err := destSyncer.Sync(ctx, sourceSecret); err != nil && syncErr == nil {
+			syncErr = err
+		}
 	}
+	if c.SecretMirror.IsMultiSource() {
+		if err := c.pruneSecrets(ctx, destSyncer, sourceSecrets); err != nil && syncErr == nil {
+			syncErr = err
+		}
+	}
+	if syncErr != nil {
+		return syncErr
+	}
+	synced = true
 
 	metrics.MirrorSyncCount.With(prometheus.Labels{
 		"mirror":           getPrettyName(c.SecretMirror),
@@ -157,82 +233,197 @@ func (c *SecretMirrorContext) Sync(ctx context.Context) error {
 	return nil
 }
 
-func (c *SecretMirrorContext) makeSourceRetriever(ctx context.Context) (SourceRetriever, error) {
-	if c.SecretMirror.Spec.Source.Type == mirrorsv1alpha2.SourceTypeSecret {
-		return &KubernetesSecretSource{
-			Client: c.backend.Client,
-			Name: types.NamespacedName{
-				Namespace: c.SecretMirror.Namespace,
-				Name:      c.SecretMirror.Spec.Source.Name,
-			},
-		}, nil
-
-	} else if c.SecretMirror.Spec.Source.Type == mirrorsv1alpha2.SourceTypeVault {
-		vault, err := c.backend.makeVault(ctx, c.SecretMirror.Spec.Source.Vault)
+// syncSucceeded reports whether a result of Sync means that a mirror is up to date
+func syncSucceeded(err error) bool {
+	if err == nil {
+		return true
+	}
+	res, ok := err.(*reconresult.ReconcileResult)
+	return ok && (res.Status == mirrorsv1alpha2.MirrorStatusActive || res.Status == mirrorsv1alpha2.MirrorStatusDryRun)
+}
+
+// retrieveSourceSecrets returns the secrets to mirror: all of them for a multi-source mirror or a single one
+func (c *SecretMirrorContext) retrieveSourceSecrets(ctx context.Context, source SourceRetriever) ([]*v1.Secret, error) {
+	if !c.SecretMirror.IsMultiSource() {
+		sourceSecret, err := source.Retrieve(ctx)
 		if err != nil {
 			return nil, err
 		}
-		return &VaultSecretSource{
-			Client:        c.backend,
-			EventRecorder: c.backend.Recorder,
-			mirror:        c.SecretMirror,
-			vault:         vault,
-		}, nil
+		return []*v1.Secret{sourceSecret}, nil
+	}
 
+	multiSource, ok := source.(MultiSourceRetriever)
+	if !ok {
+		return nil, fmt.Errorf("source.type %s does not support many secrets", c.SecretMirror.Spec.Source.Type)
 	}
+	return multiSource.RetrieveAll(ctx)
+}
 
-	return nil, fmt.Errorf("source.type %s is unsupported", c.SecretMirror.Spec.Source.Type)
+// retrieveSourceSecretsForPlan returns the secrets to mirror for a dry run without side effects of a source
+func (c *SecretMirrorContext) retrieveSourceSecretsForPlan(ctx context.Context, source SourceRetriever) ([]*v1.Secret, error) {
+	if planner, ok := source.(SourcePlanner); ok {
+		return planner.RetrieveForPlan(ctx)
+	}
+	return c.retrieveSourceSecrets(ctx, source)
 }
 
-func (c *SecretMirrorContext) makeDestSyncer(ctx context.Context) (DestSyncer, error) {
-	if c.SecretMirror.Spec.Destination.Type == mirrorsv1alpha2.DestTypeNamespaces {
-		return &NamespacesDest{
-			Client:        c.backend,
-			EventRecorder: c.backend.Recorder,
-			mirror:        c.SecretMirror,
-			nsKeeper:      c.backend.nsKeeper,
-			pool:          c.backend.pool,
-		}, nil
-
-	} else if c.SecretMirror.Spec.Destination.Type == mirrorsv1alpha2.DestTypeVault {
-		vault, err := c.backend.makeVault(ctx, c.SecretMirror.Spec.Destination.Vault)
-		if err != nil {
-			return nil, err
+// pruneSecrets removes copies of the secrets which have disappeared from a source and records the mirrored ones
+func (c *SecretMirrorContext) pruneSecrets(ctx context.Context, dest DestSyncer, sourceSecrets []*v1.Secret) error {
+	names := make([]string, 0, len(sourceSecrets))
+	current := make(map[string]struct{}, len(sourceSecrets))
+	for _, sourceSecret := range sourceSecrets {
+		names = append(names, sourceSecret.Name)
+		current[sourceSecret.Name] = struct{}{}
+	}
+
+	var removed []string
+	for _, name := range c.SecretMirror.Status.MirroredSecrets {
+		if _, ok := current[name]; !ok {
+			removed = append(removed, name)
 		}
+	}
 
-		return &VaultSecretDest{
-			Client:        c.backend,
-			EventRecorder: c.backend.Recorder,
-			mirror:        c.SecretMirror,
-			vault:         vault,
-		}, nil
+	var err error
+	if len(removed) > 0 {
+		log.FromContext(ctx).Info("pruning secrets removed from source", "secrets", removed)
+		if err = dest.Prune(ctx, removed); err != nil {
+			// keep them to retry on the next sync
+			names = append(names, removed...)
+		}
+	}
+
+	sort.Strings(names)
+	if len(names) == 0 {
+		names = nil
 	}
+	c.SecretMirror.Status.MirroredSecrets = names
+	return err
+}
+
+// afterSync lets a source release resources superseded by the retrieved secret. Failures are retried on the next sync
+func (c *SecretMirrorContext) afterSync(ctx context.Context, source SourceRetriever, synced bool) {
+	if c.SecretMirror.Spec.DryRun {
+		// nothing has been synced, so leases have not been superseded
+		return
+	}
+	if cleaner, ok := source.(SourceCleaner); ok {
+		if err := cleaner.AfterSync(ctx, synced); err != nil {
+			log.FromContext(ctx).Error(err, "error releasing superseded source resources")
+		}
+	}
+}
 
-	return nil, fmt.Errorf("unknown destination type: %s", c.SecretMirror.Spec.Destination.Type)
+// cleanupSource releases external resources held by a source, e.g. Vault leases. It is best-effort,
+// so that an unavailable source does not block deletion of a mirror
+func (c *SecretMirrorContext) cleanupSource(ctx context.Context) {
+	logger := log.FromContext(ctx)
+
+	source, err := c.makeSourceRetriever(ctx)
+	if err != nil {
+		logger.Error(err, "error cleaning up source")
+		return
+	}
+	cleaner, ok := source.(SourceCleaner)
+	if !ok {
+		return
+	}
+	if err := cleaner.Cleanup(ctx); err != nil {
+		logger.Error(err, "error cleaning up source")
+		c.backend.Recorder.Eventf(c.SecretMirror, v1.EventTypeWarning, "SourceCleanupFailed",
+			"Failed to clean up source: %s", err)
+	}
 }
 
 /// Backend
 
+// Options tunes SecretMirrorBackend
+type Options struct {
+	// Size of a worker pool syncing secrets to destination namespaces. Default: DefaultWorkerPoolSize
+	WorkerPoolSize int
+
+	// Poll period of SecretMirrors which do not specify one. Default: mirrorsv1alpha2.DefaultPollPeriodSeconds
+	DefaultPollPeriodSeconds int64
+
+	// Maximum destination writes per second across all mirrors. Zero means unlimited
+	WriteQPS int
+
+	// Maximum burst of destination writes. Default: WriteQPS
+	WriteBurst int
+
+	// Cluster name available in destination Vault path templates as {{ .Cluster }}
+	ClusterName string
+
+	// Variables available in destination Vault path templates as {{ .Vars.<name> }}
+	PathVariables map[string]string
+
+	// Secret (<namespace>/<name>) with age identities of age sources which do not reference one
+	AgeIdentitySecret string
+}
+
 type VaultBackendMakerFunc func(addr string) (VaultBackend, error)
 type SecretMirrorBackend struct {
 	client.Client
-	Recorder          record.EventRecorder
-	nsKeeper          *nskeeper.NSKeeper
-	pool              *ants.Pool
-	vaultBackendMaker VaultBackendMakerFunc
+	apiReader                client.Reader
+	Recorder                 record.EventRecorder
+	nsKeeper                 *nskeeper.NSKeeper
+	pool                     *ants.Pool
+	writeLimiter             *rate.Limiter
+	vaultBackendMaker        VaultBackendMakerFunc
+	defaultPollPeriodSeconds int64
+	clusterName              string
+	pathVariables            map[string]string
+	awsCredentials           *awsCredentialsCache
+	gitRepositories          *gitRepositories
+	ageIdentitySecret        *types.NamespacedName
 }
 
-func MakeSecretMirrorBackend(cli client.Client, recorder record.EventRecorder, nsKeeper *nskeeper.NSKeeper, vaultBackendMaker VaultBackendMakerFunc) (*SecretMirrorBackend, error) {
-	pool, err := ants.NewPool(DefaultWorkerPoolSize)
+func MakeSecretMirrorBackend(cli client.Client, apiReader client.Reader, recorder record.EventRecorder, nsKeeper *nskeeper.NSKeeper, vaultBackendMaker VaultBackendMakerFunc, options Options) (*SecretMirrorBackend, error) {
+	poolSize := options.WorkerPoolSize
+	if poolSize <= 0 {
+		poolSize = DefaultWorkerPoolSize
+	}
+	defaultPollPeriodSeconds := options.DefaultPollPeriodSeconds
+	if defaultPollPeriodSeconds <= 0 {
+		defaultPollPeriodSeconds = mirrorsv1alpha2.DefaultPollPeriodSeconds
+	}
+
+	pool, err := ants.NewPool(poolSize)
 	if err != nil {
 		return nil, err
 	}
+
+	writeLimiter := rate.NewLimiter(rate.Inf, 0)
+	if options.WriteQPS > 0 {
+		burst := options.WriteBurst
+		if burst <= 0 {
+			burst = options.WriteQPS
+		}
+		writeLimiter = rate.NewLimiter(rate.Limit(options.WriteQPS), burst)
+	}
+
+	var ageIdentitySecret *types.NamespacedName
+	if options.AgeIdentitySecret != "" {
+		parts := strings.Split(options.AgeIdentitySecret, "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("age identity secret %s must be <namespace>/<name>", options.AgeIdentitySecret)
+		}
+		ageIdentitySecret = &types.NamespacedName{Namespace: parts[0], Name: parts[1]}
+	}
+
 	return &SecretMirrorBackend{
-		Client:            cli,
-		Recorder:          recorder,
-		nsKeeper:          nsKeeper,
-		pool:              pool,
-		vaultBackendMaker: vaultBackendMaker,
+		Client:                   cli,
+		apiReader:                apiReader,
+		Recorder:                 recorder,
+		nsKeeper:                 nsKeeper,
+		pool:                     pool,
+		writeLimiter:             writeLimiter,
+		vaultBackendMaker:        vaultBackendMaker,
+		defaultPollPeriodSeconds: defaultPollPeriodSeconds,
+		clusterName:              options.ClusterName,
+		pathVariables:            options.PathVariables,
+		awsCredentials:           makeAWSCredentialsCache(),
+		gitRepositories:          makeGitRepositories(filepath.Join(os.TempDir(), "mirrors-git")),
+		ageIdentitySecret:        ageIdentitySecret,
 	}, nil
 }
 
@@ -266,6 +457,11 @@ func (b *SecretMirrorBackend) Init(ctx context.Context, name types.NamespacedNam
 	return mirrorContext, nil
 }
 
+// WriteLimiter limits destination writes across all mirrors, registered destinations should wait on it before writing
+func (b *SecretMirrorBackend) WriteLimiter() *rate.Limiter {
+	return b.writeLimiter
+}
+
 func (b *SecretMirrorBackend) Cleanup() {
 	b.pool.Release()
 }
@@ -0,0 +1,166 @@
+package backend
+
+import (
+	"context"
+	mirrorsv1alpha2 "github.com/ktsstudio/mirrors/api/v1alpha2"
+	"github.com/ktsstudio/mirrors/pkg/nskeeper"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"testing"
+)
+
+// metadataClient serves namespace metadata lists of NSKeeper, which the fake client does not support
+type metadataClient struct {
+	client.Client
+}
+
+func (c metadataClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	metadataList, ok := list.(*metav1.PartialObjectMetadataList)
+	if !ok {
+		return c.Client.List(ctx, list, opts...)
+	}
+	var namespaces v1.NamespaceList
+	if err := c.Client.List(ctx, &namespaces, opts...); err != nil {
+		return err
+	}
+	for _, ns := range namespaces.Items {
+		metadataList.Items = append(metadataList.Items, metav1.PartialObjectMetadata{ObjectMeta: ns.ObjectMeta})
+	}
+	return nil
+}
+
+func testBackend(t *testing.T, options Options, objects ...client.Object) *SecretMirrorBackend {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := mirrorsv1alpha2.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	cli := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()
+
+	nsKeeper := nskeeper.MakeNSKeeper(metadataClient{cli})
+	nsKeeper.InitNamespaces(context.Background())
+
+	b, err := MakeSecretMirrorBackend(cli, cli, record.NewFakeRecorder(100), nsKeeper, nil, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(b.Cleanup)
+	return b
+}
+
+func TestDeleteSuspendedMirror(t *testing.T) {
+	ctx := context.Background()
+	now := metav1.Now()
+	mirror := &mirrorsv1alpha2.SecretMirror{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:         "default",
+			Name:              "db",
+			Finalizers:        []string{mirrorsFinalizerName},
+			DeletionTimestamp: &now,
+		},
+		Spec: mirrorsv1alpha2.SecretMirrorSpec{
+			Source:       mirrorsv1alpha2.SecretMirrorSource{Name: "db"},
+			Destination:  mirrorsv1alpha2.SecretMirrorDestination{Namespaces: []string{"apps-.*"}},
+			DeletePolicy: mirrorsv1alpha2.DeletePolicyDelete,
+			Suspend:      true,
+		},
+		Status: mirrorsv1alpha2.SecretMirrorStatus{MirrorStatus: mirrorsv1alpha2.MirrorStatusSuspended},
+	}
+	copied := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "apps-1",
+			Name:        "db",
+			Annotations: map[string]string{ownedByMirrorAnnotation: "default/db"},
+		},
+	}
+	b := testBackend(t, Options{}, mirror, copied,
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps-1"}},
+	)
+
+	// a fresh backend has no namespaces registered, as after a restart
+	mirrorContext, err := b.Init(ctx, types.NamespacedName{Namespace: "default", Name: "db"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mirrorContext != nil {
+		t.Fatal("reconciliation of a deleted mirror has not been stopped")
+	}
+
+	err = b.Get(ctx, types.NamespacedName{Namespace: "apps-1", Name: "db"}, &v1.Secret{})
+	if !apierrors.IsNotFound(err) {
+		t.Fatalf("copy of a deleted suspended mirror has not been deleted: %v", err)
+	}
+}
+
+func TestSyncRequestHandledOnSuccess(t *testing.T) {
+	ctx := context.Background()
+	name := types.NamespacedName{Namespace: "default", Name: "db"}
+	mirror := &mirrorsv1alpha2.SecretMirror{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   name.Namespace,
+			Name:        name.Name,
+			Annotations: map[string]string{syncRequestedAtAnnotation: "1"},
+		},
+		Spec: mirrorsv1alpha2.SecretMirrorSpec{
+			Source:      mirrorsv1alpha2.SecretMirrorSource{Name: "db"},
+			Destination: mirrorsv1alpha2.SecretMirrorDestination{Namespaces: []string{"apps-.*"}},
+		},
+		Status: mirrorsv1alpha2.SecretMirrorStatus{
+			MirrorStatus: mirrorsv1alpha2.MirrorStatusActive,
+			LastSyncTime: metav1.Now(),
+		},
+	}
+	b := testBackend(t, Options{}, mirror,
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps-1"}},
+	)
+
+	mirrorContext, err := b.Init(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mirrorContext.Sync(ctx); syncSucceeded(err) {
+		t.Fatalf("sync of a missing source secret has succeeded: %v", err)
+	}
+	if mirrorContext.SecretMirror.Status.LastHandledSyncRequest != "" {
+		t.Fatal("a failed sync request is recorded as handled")
+	}
+
+	if err := b.Create(ctx, testSourceSecret("secret")); err != nil {
+		t.Fatal(err)
+	}
+	if err := mirrorContext.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if mirrorContext.SecretMirror.Status.LastHandledSyncRequest != "1" {
+		t.Fatal("a successful sync request is not recorded as handled")
+	}
+}
+
+func TestDefaultPollPeriod(t *testing.T) {
+	ctx := context.Background()
+	mirror := &mirrorsv1alpha2.SecretMirror{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "db"}}
+	mirror.Default()
+	if mirror.Spec.PollPeriodSeconds != 0 {
+		t.Fatalf("poll period is defaulted to %d outside of the controller", mirror.Spec.PollPeriodSeconds)
+	}
+
+	b := testBackend(t, Options{DefaultPollPeriodSeconds: 60}, mirror)
+	mirrorContext, err := b.Init(ctx, types.NamespacedName{Namespace: "default", Name: "db"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mirrorContext.SecretMirror.Spec.PollPeriodSeconds != 60 {
+		t.Fatalf("unexpected poll period %d", mirrorContext.SecretMirror.Spec.PollPeriodSeconds)
+	}
+}
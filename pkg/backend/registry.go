@@ -0,0 +1,187 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	mirrorsv1alpha2 "github.com/ktsstudio/mirrors/api/v1alpha2"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// SourceFactory makes a SourceRetriever of a SecretMirror
+type SourceFactory func(ctx context.Context, b *SecretMirrorBackend, mirror *mirrorsv1alpha2.SecretMirror) (SourceRetriever, error)
+
+// DestFactory makes a DestSyncer of a SecretMirror
+type DestFactory func(ctx context.Context, b *SecretMirrorBackend, mirror *mirrorsv1alpha2.SecretMirror) (DestSyncer, error)
+
+// SourceRegistration describes a source type
+type SourceRegistration struct {
+	// Value of source.type
+	Type mirrorsv1alpha2.SourceType
+
+	// Sets defaults of the type-specific part of a spec. Optional
+	Default func(mirror *mirrorsv1alpha2.SecretMirror)
+
+	// Validates the type-specific part of a spec. Optional
+	Validate func(mirror *mirrorsv1alpha2.SecretMirror) error
+
+	Factory SourceFactory
+}
+
+// DestRegistration describes a destination type
+type DestRegistration struct {
+	// Value of destination.type
+	Type mirrorsv1alpha2.DestType
+
+	// Sets defaults of the type-specific part of a spec. Optional
+	Default func(mirror *mirrorsv1alpha2.SecretMirror)
+
+	// Validates the type-specific part of a spec. Optional
+	Validate func(mirror *mirrorsv1alpha2.SecretMirror) error
+
+	Factory DestFactory
+}
+
+// registry keeps source and destination types. Built-in types register themselves in init,
+// other ones may be added by blank-importing a package which calls RegisterSource or RegisterDest
+type registry struct {
+	mutex   sync.RWMutex
+	sources map[mirrorsv1alpha2.SourceType]SourceRegistration
+	dests   map[mirrorsv1alpha2.DestType]DestRegistration
+}
+
+var backends = &registry{
+	sources: make(map[mirrorsv1alpha2.SourceType]SourceRegistration),
+	dests:   make(map[mirrorsv1alpha2.DestType]DestRegistration),
+}
+
+func init() {
+	mirrorsv1alpha2.Backends = backends
+}
+
+// RegisterSource adds a source type. It panics if the type is already registered
+func RegisterSource(registration SourceRegistration) {
+	if registration.Type == "" || registration.Factory == nil {
+		panic("backend: source registration requires a type and a factory")
+	}
+
+	backends.mutex.Lock()
+	defer backends.mutex.Unlock()
+	if _, ok := backends.sources[registration.Type]; ok {
+		panic(fmt.Sprintf("backend: source type %s is already registered", registration.Type))
+	}
+	backends.sources[registration.Type] = registration
+}
+
+// RegisterDest adds a destination type. It panics if the type is already registered
+func RegisterDest(registration DestRegistration) {
+	if registration.Type == "" || registration.Factory == nil {
+		panic("backend: destination registration requires a type and a factory")
+	}
+
+	backends.mutex.Lock()
+	defer backends.mutex.Unlock()
+	if _, ok := backends.dests[registration.Type]; ok {
+		panic(fmt.Sprintf("backend: destination type %s is already registered", registration.Type))
+	}
+	backends.dests[registration.Type] = registration
+}
+
+// SourceTypes returns registered source types
+func SourceTypes() []string {
+	backends.mutex.RLock()
+	defer backends.mutex.RUnlock()
+
+	types := make([]string, 0, len(backends.sources))
+	for t := range backends.sources {
+		types = append(types, string(t))
+	}
+	sort.Strings(types)
+	return types
+}
+
+// DestTypes returns registered destination types
+func DestTypes() []string {
+	backends.mutex.RLock()
+	defer backends.mutex.RUnlock()
+
+	types := make([]string, 0, len(backends.dests))
+	for t := range backends.dests {
+		types = append(types, string(t))
+	}
+	sort.Strings(types)
+	return types
+}
+
+func (r *registry) source(t mirrorsv1alpha2.SourceType) (SourceRegistration, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	registration, ok := r.sources[t]
+	return registration, ok
+}
+
+func (r *registry) dest(t mirrorsv1alpha2.DestType) (DestRegistration, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	registration, ok := r.dests[t]
+	return registration, ok
+}
+
+// Default implements mirrorsv1alpha2.BackendValidator
+func (r *registry) Default(mirror *mirrorsv1alpha2.SecretMirror) {
+	if source, ok := r.source(mirror.Spec.Source.Type); ok && source.Default != nil {
+		source.Default(mirror)
+	}
+	if dest, ok := r.dest(mirror.Spec.Destination.Type); ok && dest.Default != nil {
+		dest.Default(mirror)
+	}
+}
+
+// Validate implements mirrorsv1alpha2.BackendValidator
+func (r *registry) Validate(mirror *mirrorsv1alpha2.SecretMirror) error {
+	source, ok := r.source(mirror.Spec.Source.Type)
+	if !ok {
+		return fmt.Errorf("source type must be one of the following: %s", quoteTypes(SourceTypes()))
+	}
+	dest, ok := r.dest(mirror.Spec.Destination.Type)
+	if !ok {
+		return fmt.Errorf("destination type must be one of the following: %s", quoteTypes(DestTypes()))
+	}
+
+	if source.Validate != nil {
+		if err := source.Validate(mirror); err != nil {
+			return err
+		}
+	}
+	if dest.Validate != nil {
+		if err := dest.Validate(mirror); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func quoteTypes(types []string) string {
+	quoted := make([]string, len(types))
+	for i, t := range types {
+		quoted[i] = "`" + t + "`"
+	}
+	return strings.Join(quoted, ", ")
+}
+
+func (c *SecretMirrorContext) makeSourceRetriever(ctx context.Context) (SourceRetriever, error) {
+	source, ok := backends.source(c.SecretMirror.Spec.Source.Type)
+	if !ok {
+		return nil, fmt.Errorf("source.type %s is unsupported", c.SecretMirror.Spec.Source.Type)
+	}
+	return source.Factory(ctx, c.backend, c.SecretMirror)
+}
+
+func (c *SecretMirrorContext) makeDestSyncer(ctx context.Context) (DestSyncer, error) {
+	dest, ok := backends.dest(c.SecretMirror.Spec.Destination.Type)
+	if !ok {
+		return nil, fmt.Errorf("unknown destination type: %s", c.SecretMirror.Spec.Destination.Type)
+	}
+	return dest.Factory(ctx, c.backend, c.SecretMirror)
+}
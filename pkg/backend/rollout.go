@@ -0,0 +1,156 @@
+package backend
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	mirrorsv1alpha2 "github.com/ktsstudio/mirrors/api/v1alpha2"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// maximum length of the name part of an annotation key
+const maxAnnotationNameLength = 63
+
+type workload struct {
+	kind     mirrorsv1alpha2.WorkloadKind
+	obj      client.Object
+	template *v1.PodTemplateSpec
+}
+
+// rolloutChecksumAnnotation returns a pod template annotation key holding a hash of a secret secretName
+func rolloutChecksumAnnotation(secretName string) string {
+	if len(secretName) > maxAnnotationNameLength {
+		h := sha256.Sum256([]byte(secretName))
+		suffix := hex.EncodeToString(h[:])[:8]
+		secretName = secretName[:maxAnnotationNameLength-len(suffix)-1] + "-" + suffix
+	}
+	return rolloutChecksumAnnotationPrefix + secretName
+}
+
+// podSpecReferencesSecret reports whether a pod reads a secret via env, envFrom or volumes
+func podSpecReferencesSecret(spec *v1.PodSpec, secretName string) bool {
+	containers := make([]v1.Container, 0, len(spec.InitContainers)+len(spec.Containers))
+	containers = append(containers, spec.InitContainers...)
+	containers = append(containers, spec.Containers...)
+	for _, c := range containers {
+		for _, env := range c.Env {
+			if env.ValueFrom != nil && env.ValueFrom.SecretKeyRef != nil && env.ValueFrom.SecretKeyRef.Name == secretName {
+				return true
+			}
+		}
+		for _, envFrom := range c.EnvFrom {
+			if envFrom.SecretRef != nil && envFrom.SecretRef.Name == secretName {
+				return true
+			}
+		}
+	}
+
+	for _, volume := range spec.Volumes {
+		if volume.Secret != nil && volume.Secret.SecretName == secretName {
+			return true
+		}
+		if volume.Projected != nil {
+			for _, source := range volume.Projected.Sources {
+				if source.Secret != nil && source.Secret.Name == secretName {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (d *NamespacesDest) listWorkloads(ctx context.Context, namespace string) ([]workload, error) {
+	var result []workload
+
+	deployments := &appsv1.DeploymentList{}
+	if err := d.apiReader.List(ctx, deployments, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	for i := range deployments.Items {
+		item := &deployments.Items[i]
+		result = append(result, workload{mirrorsv1alpha2.WorkloadKindDeployment, item, &item.Spec.Template})
+	}
+
+	statefulSets := &appsv1.StatefulSetList{}
+	if err := d.apiReader.List(ctx, statefulSets, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	for i := range statefulSets.Items {
+		item := &statefulSets.Items[i]
+		result = append(result, workload{mirrorsv1alpha2.WorkloadKindStatefulSet, item, &item.Spec.Template})
+	}
+
+	daemonSets := &appsv1.DaemonSetList{}
+	if err := d.apiReader.List(ctx, daemonSets, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	for i := range daemonSets.Items {
+		item := &daemonSets.Items[i]
+		result = append(result, workload{mirrorsv1alpha2.WorkloadKindDaemonSet, item, &item.Spec.Template})
+	}
+
+	return result, nil
+}
+
+func (d *NamespacesDest) isRolloutListed(w workload) bool {
+	for _, ref := range d.mirror.Spec.Rollout.Workloads {
+		if ref.Kind == w.kind && ref.Name == w.obj.GetName() {
+			return true
+		}
+	}
+	return false
+}
+
+// rollout restarts workloads consuming a changed secret by patching a checksum annotation of their pod template
+func (d *NamespacesDest) rollout(ctx context.Context, secret *v1.Secret, checksum string) error {
+	logger := log.FromContext(ctx)
+
+	workloads, err := d.listWorkloads(ctx, secret.Namespace)
+	if err != nil {
+		return err
+	}
+
+	annotation := rolloutChecksumAnnotation(secret.Name)
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"annotations": map[string]string{
+						annotation: checksum,
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, w := range workloads {
+		if w.template.Annotations[annotation] == checksum {
+			continue
+		}
+		if !d.isRolloutListed(w) && !podSpecReferencesSecret(&w.template.Spec, secret.Name) {
+			continue
+		}
+
+		if err := d.writeLimiter.Wait(ctx); err != nil {
+			return err
+		}
+		if err := d.Patch(ctx, w.obj, client.RawPatch(types.MergePatchType, patch)); err != nil {
+			return fmt.Errorf("error restarting %s %s/%s: %w", w.kind, w.obj.GetNamespace(), w.obj.GetName(), err)
+		}
+
+		logger.Info(fmt.Sprintf("restarted %s %s/%s", w.kind, w.obj.GetNamespace(), w.obj.GetName()))
+		d.Eventf(d.mirror, v1.EventTypeNormal, "RolloutTriggered", "Restarted %s %s/%s",
+			w.kind, w.obj.GetNamespace(), w.obj.GetName())
+	}
+	return nil
+}
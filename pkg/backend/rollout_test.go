@@ -0,0 +1,204 @@
+package backend
+
+import (
+	"context"
+	mirrorsv1alpha2 "github.com/ktsstudio/mirrors/api/v1alpha2"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strings"
+	"testing"
+)
+
+// patchCountingClient counts patches of workloads
+type patchCountingClient struct {
+	client.Client
+	patched []string
+}
+
+func (c *patchCountingClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	c.patched = append(c.patched, obj.GetName())
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func TestPodSpecReferencesSecret(t *testing.T) {
+	secretEnv := func(name string) []v1.EnvVar {
+		return []v1.EnvVar{{Name: "PASSWORD", ValueFrom: &v1.EnvVarSource{
+			SecretKeyRef: &v1.SecretKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: name}, Key: "password"},
+		}}}
+	}
+	tests := []struct {
+		name       string
+		spec       v1.PodSpec
+		references bool
+	}{
+		{
+			name:       "env",
+			spec:       v1.PodSpec{Containers: []v1.Container{{Env: secretEnv("db")}}},
+			references: true,
+		},
+		{
+			name: "envFrom",
+			spec: v1.PodSpec{Containers: []v1.Container{{EnvFrom: []v1.EnvFromSource{
+				{SecretRef: &v1.SecretEnvSource{LocalObjectReference: v1.LocalObjectReference{Name: "db"}}},
+			}}}},
+			references: true,
+		},
+		{
+			name: "volume",
+			spec: v1.PodSpec{Volumes: []v1.Volume{
+				{Name: "db", VolumeSource: v1.VolumeSource{Secret: &v1.SecretVolumeSource{SecretName: "db"}}},
+			}},
+			references: true,
+		},
+		{
+			name: "projected volume",
+			spec: v1.PodSpec{Volumes: []v1.Volume{{Name: "all", VolumeSource: v1.VolumeSource{Projected: &v1.ProjectedVolumeSource{
+				Sources: []v1.VolumeProjection{
+					{ConfigMap: &v1.ConfigMapProjection{LocalObjectReference: v1.LocalObjectReference{Name: "db"}}},
+					{Secret: &v1.SecretProjection{LocalObjectReference: v1.LocalObjectReference{Name: "db"}}},
+				},
+			}}}}},
+			references: true,
+		},
+		{
+			name:       "init container",
+			spec:       v1.PodSpec{InitContainers: []v1.Container{{Env: secretEnv("db")}}, Containers: []v1.Container{{}}},
+			references: true,
+		},
+		{
+			name: "other secrets",
+			spec: v1.PodSpec{
+				Containers: []v1.Container{{
+					Env: secretEnv("cache"),
+					EnvFrom: []v1.EnvFromSource{
+						{ConfigMapRef: &v1.ConfigMapEnvSource{LocalObjectReference: v1.LocalObjectReference{Name: "db"}}},
+					},
+				}},
+				Volumes: []v1.Volume{
+					{Name: "cache", VolumeSource: v1.VolumeSource{Secret: &v1.SecretVolumeSource{SecretName: "cache"}}},
+					{Name: "config", VolumeSource: v1.VolumeSource{ConfigMap: &v1.ConfigMapVolumeSource{
+						LocalObjectReference: v1.LocalObjectReference{Name: "db"},
+					}}},
+				},
+			},
+		},
+		{
+			name: "no references",
+			spec: v1.PodSpec{Containers: []v1.Container{{Env: []v1.EnvVar{{Name: "DB", Value: "db"}}}}},
+		},
+	}
+	for _, test := range tests {
+		if references := podSpecReferencesSecret(&test.spec, "db"); references != test.references {
+			t.Errorf("%s: expected %v, got %v", test.name, test.references, references)
+		}
+	}
+}
+
+func TestRolloutChecksumAnnotation(t *testing.T) {
+	if annotation := rolloutChecksumAnnotation("db"); annotation != "checksum.mirrors.kts.studio/db" {
+		t.Fatalf("unexpected annotation %s", annotation)
+	}
+
+	long := rolloutChecksumAnnotation(strings.Repeat("a", 100))
+	other := rolloutChecksumAnnotation(strings.Repeat("a", 99) + "b")
+	name := strings.TrimPrefix(long, rolloutChecksumAnnotationPrefix)
+	if len(name) != maxAnnotationNameLength || long == other {
+		t.Fatalf("long secret names are not shortened to unique annotations: %s, %s", long, other)
+	}
+}
+
+func TestRolloutOnDataChange(t *testing.T) {
+	ctx := context.Background()
+	podTemplate := func(spec v1.PodSpec) v1.PodTemplateSpec {
+		spec.Containers = append(spec.Containers, v1.Container{Name: "app", Image: "app"})
+		return v1.PodTemplateSpec{Spec: spec}
+	}
+	consumer := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "apps", Name: "consumer"},
+		Spec: appsv1.DeploymentSpec{Template: podTemplate(v1.PodSpec{Volumes: []v1.Volume{
+			{Name: "db", VolumeSource: v1.VolumeSource{Secret: &v1.SecretVolumeSource{SecretName: "db"}}},
+		}})},
+	}
+	unrelated := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "apps", Name: "unrelated"},
+		Spec:       appsv1.StatefulSetSpec{Template: podTemplate(v1.PodSpec{})},
+	}
+	listed := &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "apps", Name: "listed"},
+		Spec:       appsv1.DaemonSetSpec{Template: podTemplate(v1.PodSpec{})},
+	}
+	oldData := map[string][]byte{"password": []byte("old")}
+	copied := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "apps",
+			Name:      "db",
+			Annotations: map[string]string{
+				ownedByMirrorAnnotation: "default/db",
+				contentHashAnnotation:   hashSecretData(oldData),
+			},
+		},
+		Data: oldData,
+	}
+
+	mirror := testMirror(mirrorsv1alpha2.DriftPolicyRevert, "")
+	mirror.Spec.Rollout = &mirrorsv1alpha2.RolloutSpec{Workloads: []mirrorsv1alpha2.RolloutWorkloadSpec{
+		{Kind: mirrorsv1alpha2.WorkloadKindDaemonSet, Name: "listed"},
+		// a listed workload of another kind is not restarted
+		{Kind: mirrorsv1alpha2.WorkloadKindDeployment, Name: "unrelated"},
+	}}
+	d, recorder := testNamespacesDest(mirror, consumer, unrelated, listed, copied)
+	d.apiReader = d.Client
+	patches := &patchCountingClient{Client: d.Client}
+	d.Client = patches
+	dest := types.NamespacedName{Namespace: "apps", Name: "db"}
+
+	source := testSourceSecret("new")
+	if err := d.syncOneToNamespace(ctx, source, dest); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(patches.patched, ",") != "consumer,listed" {
+		t.Fatalf("unexpected restarted workloads %q", patches.patched)
+	}
+	if events := eventReasons(recorder); len(events) != 2 {
+		t.Fatalf("expected two RolloutTriggered events, got %q", events)
+	}
+
+	annotation := rolloutChecksumAnnotation("db")
+	checksum := hashSecretData(source.Data)
+	var deployment appsv1.Deployment
+	if err := d.Get(ctx, types.NamespacedName{Namespace: "apps", Name: "consumer"}, &deployment); err != nil {
+		t.Fatal(err)
+	}
+	if deployment.Spec.Template.Annotations[annotation] != checksum {
+		t.Fatalf("unexpected pod template annotations %v", deployment.Spec.Template.Annotations)
+	}
+	if len(deployment.Spec.Template.Spec.Volumes) != 1 || len(deployment.Spec.Template.Spec.Containers) != 1 {
+		t.Fatalf("pod template has been changed by the patch: %+v", deployment.Spec.Template.Spec)
+	}
+	var statefulSet appsv1.StatefulSet
+	if err := d.Get(ctx, types.NamespacedName{Namespace: "apps", Name: "unrelated"}, &statefulSet); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := statefulSet.Spec.Template.Annotations[annotation]; ok {
+		t.Fatal("a workload not referencing the secret has been restarted")
+	}
+
+	// unchanged data restarts nothing
+	patches.patched = nil
+	if err := d.syncOneToNamespace(ctx, source, dest); err != nil {
+		t.Fatal(err)
+	}
+	var updated v1.Secret
+	if err := d.Get(ctx, dest, &updated); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.rollout(ctx, &updated, checksum); err != nil {
+		t.Fatal(err)
+	}
+	if len(patches.patched) != 0 {
+		t.Fatalf("workloads are restarted with unchanged data: %q", patches.patched)
+	}
+}
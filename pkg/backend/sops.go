@@ -0,0 +1,324 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"filippo.io/age"
+	"fmt"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	mirrorsv1alpha2 "github.com/ktsstudio/mirrors/api/v1alpha2"
+	"go.mozilla.org/sops/v3"
+	sopsaes "go.mozilla.org/sops/v3/aes"
+	sopsage "go.mozilla.org/sops/v3/age"
+	"go.mozilla.org/sops/v3/keyservice"
+	sopspgp "go.mozilla.org/sops/v3/pgp"
+	sopsdotenv "go.mozilla.org/sops/v3/stores/dotenv"
+	sopsjson "go.mozilla.org/sops/v3/stores/json"
+	sopsyaml "go.mozilla.org/sops/v3/stores/yaml"
+	"go.mozilla.org/sops/v3/version"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Files encrypted with SOPS (https://github.com/getsops/sops) are read and written with the stores and
+// the cipher of go.mozilla.org/sops/v3. Data keys are encrypted and decrypted by sopsKeyService with the keys
+// of a Secret instead of a gpg keyring, SOPS_AGE_KEY_FILE or a cloud KMS
+
+const sopsUnencryptedSuffix = "_unencrypted"
+
+// sopsKeys decrypt data keys of SOPS files and are recipients of exported files
+type sopsKeys struct {
+	age []age.Identity
+	pgp openpgp.EntityList
+}
+
+func parseSOPSKeys(ageKeys, pgpKeys []byte) (*sopsKeys, error) {
+	keys := &sopsKeys{}
+	if len(ageKeys) > 0 {
+		identities, err := parseAgeIdentities(ageKeys)
+		if err != nil {
+			return nil, err
+		}
+		keys.age = identities
+	}
+	if len(pgpKeys) > 0 {
+		keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(pgpKeys))
+		if err != nil {
+			return nil, fmt.Errorf("malformed PGP keyring: %w", err)
+		}
+		keys.pgp = keyring
+	}
+	if len(keys.age) == 0 && len(keys.pgp) == 0 {
+		return nil, errors.New("no age identities or PGP keys found")
+	}
+	return keys, nil
+}
+
+func (k *sopsKeys) services() []keyservice.KeyServiceClient {
+	return []keyservice.KeyServiceClient{keyservice.NewCustomLocalClient(sopsKeyService{keys: k})}
+}
+
+// sopsKeyService encrypts and decrypts data keys with age identities and a PGP keyring held in memory.
+// Data keys encrypted only for other kinds of keys (cloud KMS, Vault transit) cannot be decrypted
+type sopsKeyService struct {
+	keys *sopsKeys
+}
+
+func (s sopsKeyService) Encrypt(_ context.Context, req *keyservice.EncryptRequest) (*keyservice.EncryptResponse, error) {
+	var ciphertext string
+	var err error
+	switch key := req.Key.KeyType.(type) {
+	case *keyservice.Key_AgeKey:
+		ciphertext, err = ageEncrypt(req.Plaintext, []string{key.AgeKey.Recipient})
+	case *keyservice.Key_PgpKey:
+		entity := s.pgpEntity(key.PgpKey.Fingerprint)
+		if entity == nil {
+			return nil, fmt.Errorf("PGP key %s not found", key.PgpKey.Fingerprint)
+		}
+		ciphertext, err = pgpEncrypt(req.Plaintext, entity)
+	default:
+		return nil, fmt.Errorf("unsupported SOPS key type %T", key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &keyservice.EncryptResponse{Ciphertext: []byte(ciphertext)}, nil
+}
+
+func (s sopsKeyService) Decrypt(_ context.Context, req *keyservice.DecryptRequest) (*keyservice.DecryptResponse, error) {
+	var plaintext []byte
+	var err error
+	switch key := req.Key.KeyType.(type) {
+	case *keyservice.Key_AgeKey:
+		if len(s.keys.age) == 0 {
+			return nil, errors.New("no age identities")
+		}
+		plaintext, err = ageDecrypt(req.Ciphertext, s.keys.age)
+	case *keyservice.Key_PgpKey:
+		plaintext, err = pgpDecrypt(string(req.Ciphertext), s.keys.pgp)
+	default:
+		return nil, fmt.Errorf("unsupported SOPS key type %T", key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &keyservice.DecryptResponse{Plaintext: plaintext}, nil
+}
+
+func (s sopsKeyService) pgpEntity(fingerprint string) *openpgp.Entity {
+	for _, entity := range s.keys.pgp {
+		if strings.EqualFold(fmt.Sprintf("%X", entity.PrimaryKey.Fingerprint), fingerprint) {
+			return entity
+		}
+	}
+	return nil
+}
+
+func sopsStore(format mirrorsv1alpha2.GitFileFormat) sops.Store {
+	switch format {
+	case mirrorsv1alpha2.GitFileFormatJSON:
+		return &sopsjson.Store{}
+	case mirrorsv1alpha2.GitFileFormatDotenv:
+		return &sopsdotenv.Store{}
+	default:
+		return &sopsyaml.Store{}
+	}
+}
+
+// sopsDecrypt decrypts a SOPS file and verifies its MAC. Nested keys are joined with ., lists are JSON-encoded
+func sopsDecrypt(data []byte, format mirrorsv1alpha2.GitFileFormat, keys *sopsKeys) (map[string][]byte, error) {
+	tree, err := sopsStore(format).LoadEncryptedFile(data)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := tree.Metadata.GetDataKeyWithKeyServices(keys.services())
+	if err != nil {
+		return nil, err
+	}
+
+	cipher := sopsaes.NewCipher()
+	mac, err := tree.Decrypt(dataKey, cipher)
+	if err != nil {
+		return nil, err
+	}
+	originalMAC, err := cipher.Decrypt(tree.Metadata.MessageAuthenticationCode, dataKey,
+		tree.Metadata.LastModified.Format(time.RFC3339))
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt MAC: %w", err)
+	}
+	if originalMAC != mac {
+		return nil, errors.New("MAC mismatch, the file has been tampered with")
+	}
+
+	result := make(map[string][]byte)
+	for _, branch := range tree.Branches {
+		sopsFlattenData(branch, "", result)
+	}
+	return result, nil
+}
+
+// sopsEncrypt encrypts data for the keys and the extra age recipients
+func sopsEncrypt(data map[string][]byte, format mirrorsv1alpha2.GitFileFormat, keys *sopsKeys, ageRecipients []string) ([]byte, error) {
+	var group sops.KeyGroup
+	for _, recipient := range sopsAgeRecipients(keys, ageRecipients) {
+		group = append(group, &sopsage.MasterKey{Recipient: recipient})
+	}
+	for _, entity := range keys.pgp {
+		group = append(group, sopspgp.NewMasterKeyFromFingerprint(fmt.Sprintf("%X", entity.PrimaryKey.Fingerprint)))
+	}
+	if len(group) == 0 {
+		return nil, errors.New("no age recipients or PGP keys to encrypt for")
+	}
+
+	branch := make(sops.TreeBranch, 0, len(data))
+	for _, k := range sortedKeys(data) {
+		if !utf8.Valid(data[k]) {
+			return nil, fmt.Errorf("value of key %s is not valid UTF-8", k)
+		}
+		if format == mirrorsv1alpha2.GitFileFormatDotenv && strings.ContainsAny(k, "=\n") {
+			return nil, fmt.Errorf("key %q cannot be written to a dotenv file", k)
+		}
+		branch = append(branch, sops.TreeItem{Key: k, Value: string(data[k])})
+	}
+
+	tree := sops.Tree{
+		Branches: sops.TreeBranches{branch},
+		Metadata: sops.Metadata{
+			KeyGroups:         []sops.KeyGroup{group},
+			UnencryptedSuffix: sopsUnencryptedSuffix,
+			Version:           version.Version,
+		},
+	}
+	dataKey, errs := tree.GenerateDataKeyWithKeyServices(keys.services())
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("cannot encrypt the data key: %v", errs)
+	}
+
+	cipher := sopsaes.NewCipher()
+	mac, err := tree.Encrypt(dataKey, cipher)
+	if err != nil {
+		return nil, err
+	}
+	tree.Metadata.LastModified = time.Now().UTC()
+	tree.Metadata.MessageAuthenticationCode, err = cipher.Encrypt(mac, dataKey,
+		tree.Metadata.LastModified.Format(time.RFC3339))
+	if err != nil {
+		return nil, err
+	}
+	return sopsStore(format).EmitEncryptedFile(tree)
+}
+
+func pgpDecrypt(message string, keyring openpgp.EntityList) ([]byte, error) {
+	if len(keyring) == 0 {
+		return nil, errors.New("no PGP keys")
+	}
+	block, err := armor.Decode(strings.NewReader(message))
+	if err != nil {
+		return nil, err
+	}
+	md, err := openpgp.ReadMessage(block.Body, keyring, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(md.UnverifiedBody)
+}
+
+func pgpEncrypt(plaintext []byte, entity *openpgp.Entity) (string, error) {
+	var out bytes.Buffer
+	armored, err := armor.Encode(&out, "PGP MESSAGE", nil)
+	if err != nil {
+		return "", err
+	}
+	w, err := openpgp.Encrypt(armored, []*openpgp.Entity{entity}, nil, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	if err := armored.Close(); err != nil {
+		return "", err
+	}
+	out.WriteString("\n")
+	return out.String(), nil
+}
+
+// sopsPlain turns a tree into values serializable as JSON. Comments are dropped
+func sopsPlain(value interface{}) interface{} {
+	switch v := value.(type) {
+	case sops.TreeBranch:
+		out := make(map[string]interface{}, len(v))
+		for _, item := range v {
+			if key, ok := item.Key.(string); ok {
+				out[key] = sopsPlain(item.Value)
+			}
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			if _, ok := item.(sops.Comment); !ok {
+				out = append(out, sopsPlain(item))
+			}
+		}
+		return out
+	case []byte:
+		return string(v)
+	}
+	return value
+}
+
+func sopsFlattenData(branch sops.TreeBranch, prefix string, out map[string][]byte) {
+	for _, item := range branch {
+		key, ok := item.Key.(string)
+		if !ok {
+			// a comment
+			continue
+		}
+		key = prefix + key
+		switch v := item.Value.(type) {
+		case sops.TreeBranch:
+			sopsFlattenData(v, key+".", out)
+		case string:
+			out[key] = []byte(v)
+		case []byte:
+			out[key] = v
+		case nil:
+			out[key] = []byte{}
+		case bool:
+			out[key] = []byte(strconv.FormatBool(v))
+		default:
+			raw, _ := json.Marshal(sopsPlain(v))
+			out[key] = raw
+		}
+	}
+}
+
+// sopsAgeRecipients returns sorted unique recipients of the identities and the extra recipients
+func sopsAgeRecipients(keys *sopsKeys, extra []string) []string {
+	set := make(map[string]bool)
+	for _, identity := range keys.age {
+		if recipient, ok := ageRecipient(identity); ok {
+			set[recipient] = true
+		}
+	}
+	for _, recipient := range extra {
+		set[recipient] = true
+	}
+	recipients := make([]string, 0, len(set))
+	for recipient := range set {
+		recipients = append(recipients, recipient)
+	}
+	sort.Strings(recipients)
+	return recipients
+}
@@ -0,0 +1,187 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"filippo.io/age"
+	"fmt"
+	mirrorsv1alpha2 "github.com/ktsstudio/mirrors/api/v1alpha2"
+	"github.com/ktsstudio/mirrors/pkg/reconresult"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"time"
+)
+
+func init() {
+	RegisterSource(SourceRegistration{
+		Type: mirrorsv1alpha2.SourceTypeAge,
+		Default: func(mirror *mirrorsv1alpha2.SecretMirror) {
+			if mirror.Spec.Source.Age == nil {
+				mirror.Spec.Source.Age = &mirrorsv1alpha2.AgeSpec{}
+			}
+			mirror.Spec.Source.Age.Default()
+		},
+		Validate: func(mirror *mirrorsv1alpha2.SecretMirror) error {
+			if err := validateKubernetesSecretSource(mirror); err != nil {
+				return err
+			}
+			if mirror.Spec.Source.Age == nil {
+				return nil
+			}
+			if mirror.Spec.Source.Selector != nil && mirror.Spec.Source.Age.Kind == mirrorsv1alpha2.AgeSourceKindConfigMap {
+				return errors.New("source.selector is not supported with age.kind ConfigMap")
+			}
+			return mirror.Spec.Source.Age.Validate()
+		},
+		Factory: func(ctx context.Context, b *SecretMirrorBackend, mirror *mirrorsv1alpha2.SecretMirror) (SourceRetriever, error) {
+			spec := mirror.Spec.Source.Age
+			if spec == nil {
+				spec = &mirrorsv1alpha2.AgeSpec{}
+				spec.Default()
+			}
+			identities, err := b.ageIdentities(ctx, mirror.Namespace, spec)
+			if err != nil {
+				return nil, err
+			}
+			return &AgeSecretSource{
+				KubernetesSecretSource: KubernetesSecretSource{
+					Client: b.Client,
+					Name: types.NamespacedName{
+						Namespace: mirror.Namespace,
+						Name:      mirror.Spec.Source.Name,
+					},
+					Selector: mirror.Spec.Source.Selector,
+				},
+				Kind:       spec.Kind,
+				identities: identities,
+			}, nil
+		},
+	})
+}
+
+// AgeSecretSource reads a Secret or a ConfigMap with age-encrypted values, e.g. committed to git,
+// and decrypts them. Values which are not age-encrypted are copied as is
+type AgeSecretSource struct {
+	KubernetesSecretSource
+	Kind       mirrorsv1alpha2.AgeSourceKind
+	identities []age.Identity
+}
+
+func (s *AgeSecretSource) Retrieve(ctx context.Context) (*v1.Secret, error) {
+	if s.Kind != mirrorsv1alpha2.AgeSourceKindConfigMap {
+		secret, err := s.KubernetesSecretSource.Retrieve(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return s.decrypt(secret)
+	}
+
+	var configMap v1.ConfigMap
+	if err := s.Get(ctx, s.Name, &configMap); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return nil, err
+		}
+
+		return nil, &reconresult.ReconcileResult{
+			Message:      fmt.Sprintf("configmap %s not found, waiting to appear", s.Name),
+			RequeueAfter: 30 * time.Second,
+			Status:       mirrorsv1alpha2.MirrorStatusPending,
+			EventType:    v1.EventTypeWarning,
+			EventReason:  "NoConfigMap",
+		}
+	}
+
+	var secret v1.Secret
+	configMap.ObjectMeta.DeepCopyInto(&secret.ObjectMeta)
+	secret.Data = make(map[string][]byte, len(configMap.Data)+len(configMap.BinaryData))
+	for k, v := range configMap.Data {
+		secret.Data[k] = []byte(v)
+	}
+	for k, v := range configMap.BinaryData {
+		secret.Data[k] = v
+	}
+	return s.decrypt(&secret)
+}
+
+func (s *AgeSecretSource) RetrieveAll(ctx context.Context) ([]*v1.Secret, error) {
+	secrets, err := s.KubernetesSecretSource.RetrieveAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range secrets {
+		if secrets[i], err = s.decrypt(secrets[i]); err != nil {
+			return nil, err
+		}
+	}
+	return secrets, nil
+}
+
+// decrypt returns a copy of a secret with age-encrypted values decrypted. Errors never include values
+func (s *AgeSecretSource) decrypt(secret *v1.Secret) (*v1.Secret, error) {
+	decrypted := secret.DeepCopy()
+	for k, v := range decrypted.Data {
+		if !isAgeEncrypted(v) {
+			continue
+		}
+		plaintext, err := ageDecrypt(v, s.identities)
+		if err != nil {
+			return nil, &reconresult.ReconcileResult{
+				Message:     fmt.Sprintf("cannot decrypt key %s of %s/%s: %s", k, secret.Namespace, secret.Name, err),
+				Status:      mirrorsv1alpha2.MirrorStatusError,
+				EventType:   v1.EventTypeWarning,
+				EventReason: "AgeDecryptFailed",
+			}
+		}
+		decrypted.Data[k] = plaintext
+	}
+	return decrypted, nil
+}
+
+// isAgeEncrypted reports whether a value is an armored or a binary age file
+func isAgeEncrypted(value []byte) bool {
+	return isAgeArmored(value) || bytes.HasPrefix(value, []byte(ageIntro+"\n"))
+}
+
+// ageIdentities returns identities of source.age.identitySecretRef or of the controller-wide identity Secret
+func (b *SecretMirrorBackend) ageIdentities(ctx context.Context, namespace string, spec *mirrorsv1alpha2.AgeSpec) ([]age.Identity, error) {
+	var secretName types.NamespacedName
+	switch {
+	case spec.IdentitySecretRef != nil:
+		secretName = types.NamespacedName{Namespace: namespace, Name: spec.IdentitySecretRef.Name}
+	case b.ageIdentitySecret != nil:
+		secretName = *b.ageIdentitySecret
+	default:
+		return nil, &reconresult.ReconcileResult{
+			Message:     "no age identity: set source.age.identitySecretRef or --age-identity-secret of the controller",
+			Status:      mirrorsv1alpha2.MirrorStatusError,
+			EventType:   v1.EventTypeWarning,
+			EventReason: "AgeIdentityMissing",
+		}
+	}
+
+	secret, err := FetchSecret(ctx, b, secretName)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil {
+		return nil, &reconresult.ReconcileResult{
+			Message:     fmt.Sprintf("secret %s with age identities not found", secretName),
+			Status:      mirrorsv1alpha2.MirrorStatusPending,
+			EventType:   v1.EventTypeWarning,
+			EventReason: "AgeIdentityMissing",
+		}
+	}
+
+	identities, err := parseAgeIdentities(secret.Data[spec.IdentityKey])
+	if err != nil {
+		return nil, &reconresult.ReconcileResult{
+			Message:     fmt.Sprintf("invalid age identities in secret %s under key %s: %s", secretName, spec.IdentityKey, err),
+			Status:      mirrorsv1alpha2.MirrorStatusError,
+			EventType:   v1.EventTypeWarning,
+			EventReason: "AgeIdentityInvalid",
+		}
+	}
+	return identities, nil
+}
@@ -0,0 +1,177 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	mirrorsv1alpha2 "github.com/ktsstudio/mirrors/api/v1alpha2"
+	"github.com/ktsstudio/mirrors/pkg/reconresult"
+	v1 "k8s.io/api/core/v1"
+	"strings"
+	"time"
+)
+
+// key holding a value of a secret or a parameter which is not a JSON object
+const awsValueKey = "value"
+
+func init() {
+	RegisterSource(SourceRegistration{
+		Type: mirrorsv1alpha2.SourceTypeAWS,
+		Default: func(mirror *mirrorsv1alpha2.SecretMirror) {
+			if mirror.Spec.Source.AWS != nil {
+				mirror.Spec.Source.AWS.Default(mirror.Namespace)
+			}
+		},
+		Validate: func(mirror *mirrorsv1alpha2.SecretMirror) error {
+			if mirror.Spec.Source.AWS == nil {
+				return errors.New("source.aws must be specified")
+			}
+			return mirror.Spec.Source.AWS.Validate()
+		},
+		Factory: func(ctx context.Context, b *SecretMirrorBackend, mirror *mirrorsv1alpha2.SecretMirror) (SourceRetriever, error) {
+			client, err := b.makeAWS(ctx, mirror.Spec.Source.AWS)
+			if err != nil {
+				return nil, err
+			}
+			return &AWSSecretSource{
+				mirror: mirror,
+				aws:    client,
+			}, nil
+		},
+	})
+}
+
+type AWSSecretSource struct {
+	mirror *mirrorsv1alpha2.SecretMirror
+	aws    *awsClient
+}
+
+func (s *AWSSecretSource) Setup(ctx context.Context) error {
+	_ = ctx
+	return nil
+}
+
+func (s *AWSSecretSource) Retrieve(ctx context.Context) (*v1.Secret, error) {
+	spec := s.mirror.Spec.Source.AWS
+
+	var name string
+	var data map[string][]byte
+	var err error
+	if spec.Service == mirrorsv1alpha2.AWSServiceParameterStore {
+		name = spec.ParameterPath
+		if spec.IsParameterPrefix() {
+			data, err = getAWSParametersByPath(ctx, s.aws, spec.ParameterPath)
+		} else {
+			data, err = getAWSParameter(ctx, s.aws, spec.ParameterPath)
+		}
+	} else {
+		name = spec.SecretID
+		data, err = getAWSSecretValue(ctx, s.aws, spec.SecretID)
+	}
+	if err != nil {
+		return nil, awsError(fmt.Sprintf("Error reading %s from aws", name), err)
+	}
+
+	if len(data) == 0 {
+		return nil, &reconresult.ReconcileResult{
+			Message:      fmt.Sprintf("no data need to be synced, aws: %s", name),
+			RequeueAfter: time.Until(s.mirror.NextSyncAt(time.Now())),
+			Status:       mirrorsv1alpha2.MirrorStatusActive,
+		}
+	}
+
+	var sourceSecret v1.Secret
+	sourceSecret.Namespace = "<aws>"
+	sourceSecret.Name = name
+	sourceSecret.Data = data
+	return &sourceSecret, nil
+}
+
+// awsError reports an AWS API error. Missing secrets and parameters leave a mirror pending
+func awsError(message string, err error) error {
+	var status mirrorsv1alpha2.MirrorStatus = mirrorsv1alpha2.MirrorStatusError
+	if isAWSErrorCode(err, "ResourceNotFoundException") || isAWSErrorCode(err, "ParameterNotFound") {
+		status = mirrorsv1alpha2.MirrorStatusPending
+	}
+	return &reconresult.ReconcileResult{
+		Message:     fmt.Sprintf("%s: %s", message, err),
+		Status:      status,
+		EventType:   v1.EventTypeWarning,
+		EventReason: "AWSError",
+	}
+}
+
+// explodeAWSValue turns a JSON object into keys. Any other value is stored under the `value` key
+func explodeAWSValue(value []byte) map[string][]byte {
+	var object map[string]interface{}
+	if err := json.Unmarshal(value, &object); err != nil || object == nil {
+		return map[string][]byte{awsValueKey: value}
+	}
+
+	data := make(map[string][]byte, len(object))
+	for k, v := range object {
+		if str, ok := v.(string); ok {
+			data[k] = []byte(str)
+			continue
+		}
+		raw, _ := json.Marshal(v)
+		data[k] = raw
+	}
+	return data
+}
+
+func getAWSSecretValue(ctx context.Context, client *awsClient, secretID string) (map[string][]byte, error) {
+	out, err := client.secretsManager.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(secretID),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if out.SecretString != nil {
+		return explodeAWSValue([]byte(*out.SecretString)), nil
+	}
+	if out.SecretBinary != nil {
+		return map[string][]byte{awsValueKey: out.SecretBinary}, nil
+	}
+	return nil, nil
+}
+
+func getAWSParameter(ctx context.Context, client *awsClient, name string) (map[string][]byte, error) {
+	out, err := client.ssm.GetParameter(ctx, &ssm.GetParameterInput{
+		Name:           aws.String(name),
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.Parameter == nil {
+		return nil, nil
+	}
+	return explodeAWSValue([]byte(aws.ToString(out.Parameter.Value))), nil
+}
+
+// getAWSParametersByPath reads every parameter under path. Keys are parameter names relative to path with / replaced by .
+func getAWSParametersByPath(ctx context.Context, client *awsClient, path string) (map[string][]byte, error) {
+	data := make(map[string][]byte)
+	paginator := ssm.NewGetParametersByPathPaginator(client.ssm, &ssm.GetParametersByPathInput{
+		Path:           aws.String(path),
+		Recursive:      aws.Bool(true),
+		WithDecryption: aws.Bool(true),
+	})
+	for paginator.HasMorePages() {
+		out, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range out.Parameters {
+			key := strings.ReplaceAll(strings.TrimPrefix(aws.ToString(p.Name), path), "/", ".")
+			data[key] = []byte(aws.ToString(p.Value))
+		}
+	}
+	return data, nil
+}
@@ -0,0 +1,72 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	mirrorsv1alpha2 "github.com/ktsstudio/mirrors/api/v1alpha2"
+	"github.com/ktsstudio/mirrors/pkg/reconresult"
+	v1 "k8s.io/api/core/v1"
+	"time"
+)
+
+func init() {
+	RegisterSource(SourceRegistration{
+		Type: mirrorsv1alpha2.SourceTypeConsul,
+		Default: func(mirror *mirrorsv1alpha2.SecretMirror) {
+			if mirror.Spec.Source.Consul != nil {
+				mirror.Spec.Source.Consul.Default(mirror.Namespace)
+			}
+		},
+		Validate: func(mirror *mirrorsv1alpha2.SecretMirror) error {
+			if mirror.Spec.Source.Consul == nil {
+				return errors.New("source.consul must be specified")
+			}
+			return mirror.Spec.Source.Consul.Validate()
+		},
+		Factory: func(ctx context.Context, b *SecretMirrorBackend, mirror *mirrorsv1alpha2.SecretMirror) (SourceRetriever, error) {
+			consul, err := b.makeConsul(ctx, mirror.Spec.Source.Consul)
+			if err != nil {
+				return nil, err
+			}
+			return &ConsulSecretSource{
+				mirror: mirror,
+				consul: consul,
+			}, nil
+		},
+	})
+}
+
+type ConsulSecretSource struct {
+	mirror *mirrorsv1alpha2.SecretMirror
+	consul *consulClient
+}
+
+func (s *ConsulSecretSource) Setup(ctx context.Context) error {
+	_ = ctx
+	return nil
+}
+
+func (s *ConsulSecretSource) Retrieve(ctx context.Context) (*v1.Secret, error) {
+	prefix := consulKeyPrefix(s.mirror.Spec.Source.Consul.Prefix)
+
+	pairs, err := s.consul.list(ctx, prefix)
+	if err != nil {
+		return nil, consulError(fmt.Sprintf("Error reading %s from consul", prefix), err)
+	}
+	data, _ := consulData(pairs, prefix)
+
+	if len(data) == 0 {
+		return nil, &reconresult.ReconcileResult{
+			Message:      fmt.Sprintf("no data need to be synced, consul prefix: %s", prefix),
+			RequeueAfter: time.Until(s.mirror.NextSyncAt(time.Now())),
+			Status:       mirrorsv1alpha2.MirrorStatusActive,
+		}
+	}
+
+	var sourceSecret v1.Secret
+	sourceSecret.Namespace = "<consul>"
+	sourceSecret.Name = prefix
+	sourceSecret.Data = data
+	return &sourceSecret, nil
+}
@@ -0,0 +1,124 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	mirrorsv1alpha2 "github.com/ktsstudio/mirrors/api/v1alpha2"
+	"github.com/ktsstudio/mirrors/pkg/reconresult"
+	v1 "k8s.io/api/core/v1"
+	"time"
+)
+
+// annotation of a source secret with a commit it has been read from
+const gitCommitAnnotation = "mirrors.kts.studio/git-commit"
+
+func init() {
+	RegisterSource(SourceRegistration{
+		Type: mirrorsv1alpha2.SourceTypeGit,
+		Default: func(mirror *mirrorsv1alpha2.SecretMirror) {
+			if mirror.Spec.Source.Git != nil {
+				mirror.Spec.Source.Git.Default(mirror.Namespace)
+			}
+		},
+		Validate: func(mirror *mirrorsv1alpha2.SecretMirror) error {
+			if mirror.Spec.Source.Git == nil {
+				return errors.New("source.git must be specified")
+			}
+			return mirror.Spec.Source.Git.Validate()
+		},
+		Factory: func(ctx context.Context, b *SecretMirrorBackend, mirror *mirrorsv1alpha2.SecretMirror) (SourceRetriever, error) {
+			auth, err := b.gitAuth(ctx, mirror.Spec.Source.Git)
+			if err != nil {
+				return nil, err
+			}
+			keys, err := b.sopsKeys(ctx, &mirror.Spec.Source.Git.SOPS)
+			if err != nil {
+				return nil, err
+			}
+			return &GitSecretSource{
+				mirror: mirror,
+				repos:  b.gitRepositories,
+				auth:   auth,
+				keys:   keys,
+			}, nil
+		},
+	})
+}
+
+type GitSecretSource struct {
+	mirror *mirrorsv1alpha2.SecretMirror
+	repos  *gitRepositories
+	auth   transport.AuthMethod
+	keys   *sopsKeys
+}
+
+func (s *GitSecretSource) Setup(ctx context.Context) error {
+	_ = ctx
+	return nil
+}
+
+func (s *GitSecretSource) Retrieve(ctx context.Context) (*v1.Secret, error) {
+	spec := s.mirror.Spec.Source.Git
+
+	path, unlock := s.repos.lock(spec.URL)
+	defer unlock()
+
+	repo, err := gitFetch(ctx, path, spec.URL, s.auth)
+	if err != nil {
+		return nil, gitError(fmt.Sprintf("Error fetching %s", spec.URL), err)
+	}
+	commit, err := gitResolve(repo, spec.Revision)
+	if err != nil {
+		return nil, gitError(fmt.Sprintf("Error resolving %s", spec.Revision), err)
+	}
+	if commit == nil {
+		return nil, &reconresult.ReconcileResult{
+			Message:     fmt.Sprintf("revision %s not found in %s", spec.Revision, spec.URL),
+			Status:      mirrorsv1alpha2.MirrorStatusPending,
+			EventType:   v1.EventTypeWarning,
+			EventReason: "GitRevisionNotFound",
+		}
+	}
+
+	contents, err := gitReadFile(commit, spec.Path)
+	if err != nil {
+		return nil, gitError(fmt.Sprintf("Error reading %s", spec.Path), err)
+	}
+	if contents == nil {
+		return nil, &reconresult.ReconcileResult{
+			Message:     fmt.Sprintf("file %s not found at revision %s", spec.Path, spec.Revision),
+			Status:      mirrorsv1alpha2.MirrorStatusPending,
+			EventType:   v1.EventTypeWarning,
+			EventReason: "GitFileNotFound",
+		}
+	}
+
+	data, err := sopsDecrypt(contents, spec.Format, s.keys)
+	if err != nil {
+		return nil, &reconresult.ReconcileResult{
+			Message:     fmt.Sprintf("Error decrypting %s at %s: %s", spec.Path, commit.Hash, err),
+			Status:      mirrorsv1alpha2.MirrorStatusError,
+			EventType:   v1.EventTypeWarning,
+			EventReason: "SOPSDecryptFailed",
+		}
+	}
+
+	if len(data) == 0 {
+		return nil, &reconresult.ReconcileResult{
+			Message:      fmt.Sprintf("no data need to be synced, git: %s", spec.Path),
+			RequeueAfter: time.Until(s.mirror.NextSyncAt(time.Now())),
+			Status:       mirrorsv1alpha2.MirrorStatusActive,
+		}
+	}
+
+	var sourceSecret v1.Secret
+	sourceSecret.Namespace = "<git>"
+	sourceSecret.Name = spec.Path
+	sourceSecret.Annotations = map[string]string{
+		gitCommitAnnotation: commit.Hash.String(),
+	}
+	sourceSecret.Data = data
+	return &sourceSecret, nil
+}
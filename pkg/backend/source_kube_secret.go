@@ -7,14 +7,43 @@ import (
 	"github.com/ktsstudio/mirrors/pkg/reconresult"
 	v1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sort"
 	"time"
 )
 
+func init() {
+	RegisterSource(SourceRegistration{
+		Type:     mirrorsv1alpha2.SourceTypeSecret,
+		Validate: validateKubernetesSecretSource,
+		Factory: func(ctx context.Context, b *SecretMirrorBackend, mirror *mirrorsv1alpha2.SecretMirror) (SourceRetriever, error) {
+			return &KubernetesSecretSource{
+				Client: b.Client,
+				Name: types.NamespacedName{
+					Namespace: mirror.Namespace,
+					Name:      mirror.Spec.Source.Name,
+				},
+				Selector: mirror.Spec.Source.Selector,
+			}, nil
+		},
+	})
+}
+
+func validateKubernetesSecretSource(mirror *mirrorsv1alpha2.SecretMirror) error {
+	if mirror.Spec.Source.Selector != nil {
+		if _, err := metav1.LabelSelectorAsSelector(mirror.Spec.Source.Selector); err != nil {
+			return fmt.Errorf("source.selector is invalid: %s", err)
+		}
+	}
+	return nil
+}
+
 type KubernetesSecretSource struct {
 	client.Client
-	Name types.NamespacedName
+	Name     types.NamespacedName
+	Selector *metav1.LabelSelector
 }
 
 func (s *KubernetesSecretSource) Setup(ctx context.Context) error {
@@ -40,3 +69,31 @@ func (s *KubernetesSecretSource) Retrieve(ctx context.Context) (*v1.Secret, erro
 
 	return &sourceSecret, nil
 }
+
+// RetrieveAll lists secrets matching Selector in the namespace of a mirror. Copies made by mirrors are skipped
+func (s *KubernetesSecretSource) RetrieveAll(ctx context.Context) ([]*v1.Secret, error) {
+	selector, err := metav1.LabelSelectorAsSelector(s.Selector)
+	if err != nil {
+		return nil, err
+	}
+
+	var secrets v1.SecretList
+	if err := s.List(ctx, &secrets,
+		client.InNamespace(s.Name.Namespace),
+		client.MatchingLabelsSelector{Selector: selector},
+	); err != nil {
+		return nil, err
+	}
+
+	result := make([]*v1.Secret, 0, len(secrets.Items))
+	for i := range secrets.Items {
+		if _, ok := secrets.Items[i].Annotations[ownedByMirrorAnnotation]; ok {
+			continue
+		}
+		result = append(result, &secrets.Items[i])
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result, nil
+}
@@ -2,6 +2,7 @@ package backend
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/hashicorp/vault/api"
 	mirrorsv1alpha2 "github.com/ktsstudio/mirrors/api/v1alpha2"
@@ -9,11 +10,58 @@ import (
 	"github.com/ktsstudio/mirrors/pkg/reconresult"
 	"github.com/prometheus/client_golang/prometheus"
 	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sort"
+	"strings"
+	"time"
 )
 
+// a lease which failed to renew is dropped once less than 1/leaseExpiryMarginDivisor of its duration is left
+const leaseExpiryMarginDivisor = 10
+
+func init() {
+	RegisterSource(SourceRegistration{
+		Type: mirrorsv1alpha2.SourceTypeVault,
+		Default: func(mirror *mirrorsv1alpha2.SecretMirror) {
+			if mirror.Spec.Source.Vault != nil {
+				mirror.Spec.Source.Vault.DefaultSource(mirror.Namespace)
+			}
+		},
+		Validate: validateVaultSource,
+		Factory: func(ctx context.Context, b *SecretMirrorBackend, mirror *mirrorsv1alpha2.SecretMirror) (SourceRetriever, error) {
+			vault, err := b.makeVault(ctx, mirror.Spec.Source.Vault)
+			if err != nil {
+				return nil, err
+			}
+			return &VaultSecretSource{
+				Client:        b,
+				EventRecorder: b.Recorder,
+				mirror:        mirror,
+				vault:         vault,
+			}, nil
+		},
+	})
+}
+
+func validateVaultSource(mirror *mirrorsv1alpha2.SecretMirror) error {
+	spec := mirror.Spec.Source.Vault
+	if spec == nil {
+		return errors.New("source.vault must be specified")
+	}
+	if spec.PKI != nil {
+		if err := spec.PKI.Validate(); err != nil {
+			return err
+		}
+	}
+	if spec.Transit != nil && spec.Transit.Key == "" {
+		return errors.New("source.vault.transit.key must be specified")
+	}
+	return spec.ValidateValues()
+}
+
 type VaultSecretSource struct {
 	client.Client
 	record.EventRecorder
@@ -27,6 +75,10 @@ func (s *VaultSecretSource) Setup(ctx context.Context) error {
 }
 
 func (s *VaultSecretSource) Retrieve(ctx context.Context) (*v1.Secret, error) {
+	if s.mirror.Spec.Source.Vault.PKI != nil {
+		return s.issueCertificate(ctx)
+	}
+
 	path := s.mirror.Spec.Source.Vault.Path
 
 	data, err := s.retrieveVaultSecret(ctx, s.vault, path)
@@ -37,7 +89,7 @@ func (s *VaultSecretSource) Retrieve(ctx context.Context) (*v1.Secret, error) {
 	if data == nil {
 		return nil, &reconresult.ReconcileResult{
 			Message:      fmt.Sprintf("no data need to be synced, vaultPath: %s", path),
-			RequeueAfter: s.mirror.PollPeriodDuration(),
+			RequeueAfter: time.Until(s.mirror.NextSyncAt(time.Now())),
 			Status:       mirrorsv1alpha2.MirrorStatusActive,
 		}
 	}
@@ -50,37 +102,186 @@ func (s *VaultSecretSource) Retrieve(ctx context.Context) (*v1.Secret, error) {
 	return &sourceSecret, nil
 }
 
-func (s *VaultSecretSource) retrieveVaultSecret(ctx context.Context, vault VaultBackend, path string) (map[string][]byte, error) {
+// RetrieveForPlan reads source secrets for a dry run. Certificates are never issued and leases are neither
+// renewed nor kept: dynamic credentials fetched by a read are revoked right away and the dry run is refused
+func (s *VaultSecretSource) RetrieveForPlan(ctx context.Context) ([]*v1.Secret, error) {
+	if s.mirror.IsMultiSource() {
+		return s.RetrieveAll(ctx)
+	}
+
+	spec := s.mirror.Spec.Source.Vault
+	if spec.PKI != nil {
+		return nil, vaultDryRunUnsupported("source.vault.pki issues a new certificate on every read")
+	}
+	if lease := s.mirror.Status.VaultSource; lease != nil && lease.LeaseID != "" {
+		return nil, vaultDryRunUnsupported("source.vault.path holds dynamic credentials under a lease")
+	}
+
+	vaultSecret, err := s.vault.ReadSecret(spec.Path)
+	if err != nil {
+		return nil, err
+	}
+	if vaultSecret == nil {
+		return nil, &reconresult.ReconcileResult{
+			Message:      fmt.Sprintf("no data need to be synced, vaultPath: %s", spec.Path),
+			RequeueAfter: time.Until(s.mirror.NextSyncAt(time.Now())),
+			Status:       mirrorsv1alpha2.MirrorStatusActive,
+		}
+	}
+	if vaultSecret.LeaseID != "" {
+		if err := s.vault.RevokeLease(vaultSecret.LeaseID); err != nil {
+			log.FromContext(ctx).Error(err, "error revoking a lease of a dry run", "lease-id", vaultSecret.LeaseID)
+		}
+		return nil, vaultDryRunUnsupported("source.vault.path holds dynamic credentials under a lease")
+	}
+
+	data, err := s.readData(vaultSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	var sourceSecret v1.Secret
+	sourceSecret.Data = data
+	sourceSecret.Namespace = "<vault>"
+	sourceSecret.Name = spec.Path
+	return []*v1.Secret{&sourceSecret}, nil
+}
+
+func vaultDryRunUnsupported(reason string) error {
+	return &reconresult.ReconcileResult{
+		Message:     fmt.Sprintf("dry run is not supported: %s", reason),
+		Status:      mirrorsv1alpha2.MirrorStatusError,
+		EventType:   v1.EventTypeWarning,
+		EventReason: "DryRunUnsupported",
+	}
+}
+
+// RetrieveAll reads every secret under source.vault.prefix. Secrets are named after their path relative to the prefix
+func (s *VaultSecretSource) RetrieveAll(ctx context.Context) ([]*v1.Secret, error) {
 	logger := log.FromContext(ctx)
 
-	if s.mirror.Status.VaultSource != nil && s.mirror.Status.VaultSource.LeaseID != "" {
-		leaseResult, err := vault.RenewLease(s.mirror.Status.VaultSource.LeaseID, s.mirror.Status.VaultSource.LeaseDuration)
+	prefix := strings.Trim(s.mirror.Spec.Source.Vault.Prefix, "/")
+	paths, err := s.listVaultPaths(kvMetadataPath(prefix), "", s.mirror.Spec.Source.Vault.Recursive)
+	if err != nil {
+		return nil, err
+	}
+
+	secrets := make([]*v1.Secret, 0, len(paths))
+	names := make(map[string]string, len(paths))
+	for _, relPath := range paths {
+		path := prefix + "/" + relPath
+		name, ok := secretNameFromPath(relPath)
+		if !ok {
+			s.Eventf(s.mirror, v1.EventTypeWarning, "InvalidSecretName",
+				"Vault path %s cannot be turned into a secret name, skipping", path)
+			continue
+		}
+		if otherPath, ok := names[name]; ok {
+			s.Eventf(s.mirror, v1.EventTypeWarning, "InvalidSecretName",
+				"Vault paths %s and %s map to the same secret name %s, skipping the latter", otherPath, path, name)
+			continue
+		}
+		names[name] = path
+
+		vaultSecret, err := s.vault.ReadSecret(path)
 		if err != nil {
-			logger.Info("error while renewing lease - will refetch secret", "err", err, "lease-id", s.mirror.Status.VaultSource.LeaseID)
-			s.mirror.Status.VaultSource = nil
+			return nil, err
+		}
+		if vaultSecret == nil {
+			logger.Info("vault secret disappeared while listing", "path", path)
+			continue
+		}
+		data, err := s.readData(vaultSecret)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", path, err)
+		}
+
+		var sourceSecret v1.Secret
+		sourceSecret.Namespace = "<vault>"
+		sourceSecret.Name = name
+		sourceSecret.Annotations = map[string]string{
+			vaultPathAnnotation: path,
+		}
+		sourceSecret.Data = data
+		secrets = append(secrets, &sourceSecret)
+	}
+
+	if len(secrets) == 0 && !s.mirror.Spec.Source.Vault.AllowEmptyPrefix {
+		// pruning would delete every copy
+		return nil, &reconresult.ReconcileResult{
+			Message: fmt.Sprintf("no secrets found under vault prefix %s, set source.vault.allowEmptyPrefix "+
+				"to delete all the copies", prefix),
+			Status:      mirrorsv1alpha2.MirrorStatusError,
+			EventType:   v1.EventTypeWarning,
+			EventReason: "VaultPrefixEmpty",
+		}
+	}
+
+	return secrets, nil
+}
+
+// listVaultPaths returns paths of all secrets under listPath (relative to it) sorted by name
+func (s *VaultSecretSource) listVaultPaths(listPath, relPath string, recursive bool) ([]string, error) {
+	secret, err := s.vault.List(listPath + "/" + relPath)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, nil
+	}
+
+	keys, _ := secret.Data["keys"].([]interface{})
+	var result []string
+	for _, key := range keys {
+		key, ok := key.(string)
+		if !ok {
+			continue
+		}
+		if strings.HasSuffix(key, "/") {
+			if !recursive {
+				continue
+			}
+			nested, err := s.listVaultPaths(listPath, relPath+key, recursive)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, nested...)
+			continue
+		}
+		result = append(result, relPath+key)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+func (s *VaultSecretSource) retrieveVaultSecret(ctx context.Context, vault VaultBackend, path string) (map[string][]byte, error) {
+	logger := log.FromContext(ctx)
+
+	if lease := s.mirror.Status.VaultSource; lease != nil && lease.LeaseID != "" {
+		now := time.Now()
+		if lease.LeaseRenewAt != nil && now.Before(lease.LeaseRenewAt.Time) {
+			// lease is still fresh - nothing has changed since the last fetch
+			return nil, nil
+		}
 
-			statusCode := "-"
-			if err, ok := err.(*api.ResponseError); ok {
-				statusCode = fmt.Sprintf("%d", err.StatusCode)
+		if lease.MaxTTLReached {
+			logger.Info("lease is approaching its max ttl - will refetch secret", "lease-id", lease.LeaseID)
+			s.Eventf(s.mirror, v1.EventTypeNormal, "VaultLeaseMaxTTL",
+				"Lease %s cannot be renewed past its max ttl, fetching new credentials", lease.LeaseID)
+			s.mirror.Status.VaultSource = nil
+			s.supersedeLease(lease, now)
+		} else if err := s.renewLease(ctx, vault, lease, now); err != nil {
+			if s.mirror.Status.VaultSource == nil {
+				logger.Info("error while renewing lease - will refetch secret", "err", err, "lease-id", lease.LeaseID)
+				s.supersedeLease(lease, now)
+			} else {
+				logger.Info("error while renewing lease - will retry", "err", err, "lease-id", lease.LeaseID,
+					"retryAt", s.mirror.Status.VaultSource.LeaseRenewAt)
 			}
-			metrics.VaultLeaseRenewErrorCount.With(prometheus.Labels{
-				"mirror":    getPrettyName(s.mirror),
-				"vault":     vault.Addr(),
-				"http_code": statusCode,
-			}).Inc()
-		} else {
-			s.Eventf(s.mirror, v1.EventTypeNormal, "VaultLeaseRenew", "Renewed lease successfully")
-			metrics.VaultLeaseRenewOkCount.With(prometheus.Labels{
-				"mirror": getPrettyName(s.mirror),
-				"vault":  vault.Addr(),
-			}).Inc()
-			logger.Info("successfully renewed vault lease", "leaseId", s.mirror.Status.VaultSource.LeaseID)
-			s.mirror.Status.VaultSource.LeaseID = leaseResult.LeaseID
-			s.mirror.Status.VaultSource.LeaseDuration = leaseResult.LeaseDuration
 		}
 
 		if s.mirror.Status.VaultSource != nil {
-			// no need to fetch data as we prolonged a lease successfully
+			// no need to fetch data as we prolonged a lease successfully or the lease is still valid
 			return nil, nil
 		}
 	}
@@ -94,14 +295,186 @@ func (s *VaultSecretSource) retrieveVaultSecret(ctx context.Context, vault Vault
 	}
 
 	if vaultSecret.Renewable {
-		if s.mirror.Status.VaultSource == nil {
-			s.mirror.Status.VaultSource = &mirrorsv1alpha2.VaultSourceStatusSpec{}
+		s.mirror.Status.VaultSource = &mirrorsv1alpha2.VaultSourceStatusSpec{
+			LeaseID:       vaultSecret.LeaseID,
+			LeaseDuration: vaultSecret.LeaseDuration,
 		}
-		s.mirror.Status.VaultSource.LeaseID = vaultSecret.LeaseID
-		s.mirror.Status.VaultSource.LeaseDuration = vaultSecret.LeaseDuration
+		s.scheduleRenewal(time.Now(), time.Duration(vaultSecret.LeaseDuration)*time.Second)
 
 		s.Eventf(s.mirror, v1.EventTypeNormal, "VaultNewCreds", "Fetched new credentials under the lease %s", vaultSecret.LeaseID)
 	}
 
-	return extractVaultSecretData(vaultSecret)
+	return s.readData(vaultSecret)
+}
+
+// readData returns data of a source secret, decrypted with a Transit key if configured
+func (s *VaultSecretSource) readData(vaultSecret *api.Secret) (map[string][]byte, error) {
+	transit := s.mirror.Spec.Source.Vault.Transit
+	if transit == nil {
+		return extractVaultSecretData(vaultSecret, s.mirror.Spec.Source.Vault, s.mirror.Spec.Source.Vault.Encoding)
+	}
+
+	// ciphertexts are stored as is
+	data, err := extractVaultSecretData(vaultSecret, s.mirror.Spec.Source.Vault, mirrorsv1alpha2.VaultEncodingRaw)
+	if err != nil {
+		return nil, err
+	}
+	return transitDecrypt(s.vault, transit, data)
+}
+
+// renewLease extends a lease by its original duration. If renewal fails the lease is kept
+// and retried later unless it is about to expire - then it is dropped so that new credentials are fetched
+func (s *VaultSecretSource) renewLease(ctx context.Context, vault VaultBackend, lease *mirrorsv1alpha2.VaultSourceStatusSpec, now time.Time) error {
+	logger := log.FromContext(ctx)
+
+	leaseResult, err := vault.RenewLease(lease.LeaseID, lease.LeaseDuration)
+	if err != nil {
+		statusCode := "-"
+		if err, ok := err.(*api.ResponseError); ok {
+			statusCode = fmt.Sprintf("%d", err.StatusCode)
+		}
+		metrics.VaultLeaseRenewErrorCount.With(prometheus.Labels{
+			"mirror":    getPrettyName(s.mirror),
+			"vault":     vault.Addr(),
+			"http_code": statusCode,
+		}).Inc()
+
+		var remaining time.Duration
+		if lease.LeaseExpiresAt != nil {
+			remaining = lease.LeaseExpiresAt.Time.Sub(now)
+		}
+		if remaining <= time.Duration(lease.LeaseDuration)*time.Second/leaseExpiryMarginDivisor {
+			if remaining > 0 {
+				s.Eventf(s.mirror, v1.EventTypeWarning, "VaultLeaseRenewFailed",
+					"Failed to renew lease %s expiring at %s, fetching new credentials: %s",
+					lease.LeaseID, lease.LeaseExpiresAt.Time.Format(time.RFC3339), err)
+			}
+			s.mirror.Status.VaultSource = nil
+			return err
+		}
+
+		s.scheduleRenewal(now, remaining)
+		lease.LeaseRenewError = err.Error()
+		s.Eventf(s.mirror, v1.EventTypeWarning, "VaultLeaseRenewFailed",
+			"Failed to renew lease %s, retrying at %s: %s",
+			lease.LeaseID, lease.LeaseRenewAt.Time.Format(time.RFC3339), err)
+		return err
+	}
+
+	s.Eventf(s.mirror, v1.EventTypeNormal, "VaultLeaseRenew", "Renewed lease successfully")
+	metrics.VaultLeaseRenewOkCount.With(prometheus.Labels{
+		"mirror": getPrettyName(s.mirror),
+		"vault":  vault.Addr(),
+	}).Inc()
+	logger.Info("successfully renewed vault lease", "leaseId", lease.LeaseID, "leaseDuration", leaseResult.LeaseDuration)
+
+	if leaseResult.LeaseID != "" {
+		lease.LeaseID = leaseResult.LeaseID
+	}
+	lease.LeaseRenewError = ""
+	// Vault caps renewals at the max ttl of a lease, so a shorter lease means there is nothing more to renew
+	lease.MaxTTLReached = leaseResult.LeaseDuration < lease.LeaseDuration
+	s.scheduleRenewal(now, time.Duration(leaseResult.LeaseDuration)*time.Second)
+	return nil
+}
+
+// scheduleRenewal records expiry of a lease with the remaining ttl and when to renew it
+func (s *VaultSecretSource) scheduleRenewal(now time.Time, ttl time.Duration) {
+	expiresAt := metav1.NewTime(now.Add(ttl))
+	renewAt := metav1.NewTime(now.Add(s.mirror.Spec.Source.Vault.LeaseRenewAfter(ttl)))
+	s.mirror.Status.VaultSource.LeaseExpiresAt = &expiresAt
+	s.mirror.Status.VaultSource.LeaseRenewAt = &renewAt
+}
+
+// supersedeLease remembers a lease replaced by new credentials, so that it is revoked once they are synced
+func (s *VaultSecretSource) supersedeLease(lease *mirrorsv1alpha2.VaultSourceStatusSpec, now time.Time) {
+	if lease.LeaseExpiresAt != nil && !now.Before(lease.LeaseExpiresAt.Time) {
+		// already expired - nothing to revoke
+		return
+	}
+	s.mirror.Status.SupersededLeases = append(s.mirror.Status.SupersededLeases, mirrorsv1alpha2.SupersededLeaseSpec{
+		LeaseID:        lease.LeaseID,
+		LeaseExpiresAt: lease.LeaseExpiresAt,
+	})
+}
+
+// AfterSync schedules revocation of superseded leases once new credentials are synced
+// and revokes the ones past their grace period
+func (s *VaultSecretSource) AfterSync(ctx context.Context, synced bool) error {
+	logger := log.FromContext(ctx)
+
+	now := time.Now()
+	var errs []string
+	remaining := s.mirror.Status.SupersededLeases[:0]
+	for _, lease := range s.mirror.Status.SupersededLeases {
+		if lease.LeaseExpiresAt != nil && !now.Before(lease.LeaseExpiresAt.Time) {
+			logger.Info("superseded lease has expired", "lease-id", lease.LeaseID)
+			continue
+		}
+
+		if lease.RevokeAt == nil {
+			if synced {
+				revokeAt := metav1.NewTime(now.Add(s.mirror.Spec.Source.Vault.LeaseRevokeGracePeriod()))
+				lease.RevokeAt = &revokeAt
+			}
+			remaining = append(remaining, lease)
+			continue
+		}
+
+		if now.Before(lease.RevokeAt.Time) {
+			remaining = append(remaining, lease)
+			continue
+		}
+
+		if err := s.vault.RevokeLease(lease.LeaseID); err != nil {
+			s.Eventf(s.mirror, v1.EventTypeWarning, "VaultLeaseRevokeFailed",
+				"Failed to revoke superseded lease %s: %s", lease.LeaseID, err)
+			errs = append(errs, err.Error())
+
+			retryAt := metav1.NewTime(now.Add(reconresult.DefaultRequeueAfter))
+			lease.RevokeAt = &retryAt
+			remaining = append(remaining, lease)
+			continue
+		}
+
+		s.Eventf(s.mirror, v1.EventTypeNormal, "VaultLeaseRevoked", "Revoked superseded lease %s", lease.LeaseID)
+		logger.Info("revoked superseded vault lease", "lease-id", lease.LeaseID)
+	}
+
+	if len(remaining) == 0 {
+		remaining = nil
+	}
+	s.mirror.Status.SupersededLeases = remaining
+
+	if len(errs) > 0 {
+		return fmt.Errorf("error revoking superseded leases: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Cleanup revokes the current and all the superseded leases
+func (s *VaultSecretSource) Cleanup(ctx context.Context) error {
+	logger := log.FromContext(ctx)
+
+	var leaseIDs []string
+	if s.mirror.Status.VaultSource != nil && s.mirror.Status.VaultSource.LeaseID != "" {
+		leaseIDs = append(leaseIDs, s.mirror.Status.VaultSource.LeaseID)
+	}
+	for _, lease := range s.mirror.Status.SupersededLeases {
+		leaseIDs = append(leaseIDs, lease.LeaseID)
+	}
+
+	var errs []string
+	for _, leaseID := range leaseIDs {
+		if err := s.vault.RevokeLease(leaseID); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		logger.Info("revoked vault lease", "lease-id", leaseID)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("error revoking leases: %s", strings.Join(errs, "; "))
+	}
+	return nil
 }
@@ -0,0 +1,155 @@
+package backend
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	mirrorsv1alpha2 "github.com/ktsstudio/mirrors/api/v1alpha2"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"strings"
+	"time"
+)
+
+// key of a CA certificate in a kubernetes.io/tls secret, as used by cert-manager and ingress controllers
+const tlsCAKey = "ca.crt"
+
+// issueCertificate issues a certificate via <mount>/issue/<role> once the current one has lived
+// its share of the lifetime. While the current certificate is fresh, it is read back from a Secret
+// owned by the mirror, so a failed sync, a new namespace or a drifted copy still gets it
+func (s *VaultSecretSource) issueCertificate(ctx context.Context) (*v1.Secret, error) {
+	logger := log.FromContext(ctx)
+	pki := s.mirror.Spec.Source.Vault.PKI
+	path := fmt.Sprintf("%s/issue/%s", strings.Trim(pki.Mount, "/"), pki.Role)
+
+	now := time.Now()
+	if status := s.mirror.Status.VaultSource; status != nil && status.LeaseRenewAt != nil && now.Before(status.LeaseRenewAt.Time) {
+		current, err := FetchSecret(ctx, s, s.certificateSecretName())
+		if err != nil {
+			return nil, err
+		}
+		if current != nil && current.Annotations[vaultCertificateSerialAnnotation] == status.CertificateSerial {
+			return certificateSourceSecret(path, current.Data), nil
+		}
+		logger.Info("current vault certificate is not stored, issuing a new one", "serial", status.CertificateSerial)
+	}
+
+	request := map[string]interface{}{
+		"common_name": pki.CommonName,
+	}
+	if len(pki.AltNames) > 0 {
+		request["alt_names"] = strings.Join(pki.AltNames, ",")
+	}
+	if len(pki.IPSANs) > 0 {
+		request["ip_sans"] = strings.Join(pki.IPSANs, ",")
+	}
+	if pki.TTL != "" {
+		request["ttl"] = pki.TTL
+	}
+
+	vaultSecret, err := s.vault.Write(path, request)
+	if err != nil {
+		return nil, err
+	}
+	if vaultSecret == nil || vaultSecret.Data == nil {
+		return nil, fmt.Errorf("vault returned no certificate from %s", path)
+	}
+
+	certificate, _ := vaultSecret.Data["certificate"].(string)
+	privateKey, _ := vaultSecret.Data["private_key"].(string)
+	issuingCA, _ := vaultSecret.Data["issuing_ca"].(string)
+	serial, _ := vaultSecret.Data["serial_number"].(string)
+	if certificate == "" || privateKey == "" {
+		return nil, fmt.Errorf("vault returned no certificate or private key from %s", path)
+	}
+
+	notAfter, err := certificateNotAfter(certificate)
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string][]byte{
+		v1.TLSCertKey:       []byte(certificate),
+		v1.TLSPrivateKeyKey: []byte(privateKey),
+	}
+	if issuingCA != "" {
+		data[tlsCAKey] = []byte(issuingCA)
+	}
+	if err := s.storeCertificate(ctx, serial, data); err != nil {
+		return nil, fmt.Errorf("cannot store issued certificate: %w", err)
+	}
+
+	renewAt := metav1.NewTime(now.Add(s.mirror.Spec.Source.Vault.LeaseRenewAfter(notAfter.Sub(now))))
+	notAfterTime := metav1.NewTime(notAfter)
+	s.mirror.Status.VaultSource = &mirrorsv1alpha2.VaultSourceStatusSpec{
+		LeaseRenewAt:        &renewAt,
+		CertificateSerial:   serial,
+		CertificateNotAfter: &notAfterTime,
+	}
+
+	logger.Info("issued vault certificate", "serial", serial, "notAfter", notAfter)
+	s.Eventf(s.mirror, v1.EventTypeNormal, "VaultCertIssued", "Issued certificate %s valid until %s",
+		serial, notAfter.Format(time.RFC3339))
+
+	return certificateSourceSecret(path, data), nil
+}
+
+// certificateSecretName names a Secret in the namespace of the mirror holding its current certificate
+func (s *VaultSecretSource) certificateSecretName() types.NamespacedName {
+	return types.NamespacedName{Namespace: s.mirror.Namespace, Name: s.mirror.Name + vaultCertificateSecretSuffix}
+}
+
+// storeCertificate keeps an issued certificate until it is re-issued. The Secret is owned by the mirror
+// and is garbage collected with it
+func (s *VaultSecretSource) storeCertificate(ctx context.Context, serial string, data map[string][]byte) error {
+	name := s.certificateSecretName()
+	secret, err := FetchSecret(ctx, s, name)
+	if err != nil {
+		return err
+	}
+	create := secret == nil
+	if create {
+		secret = &v1.Secret{}
+		secret.Namespace, secret.Name = name.Namespace, name.Name
+		secret.Type = v1.SecretTypeTLS
+		secret.OwnerReferences = []metav1.OwnerReference{
+			*metav1.NewControllerRef(s.mirror, mirrorsv1alpha2.GroupVersion.WithKind("SecretMirror")),
+		}
+	}
+	if secret.Annotations == nil {
+		secret.Annotations = make(map[string]string)
+	}
+	secret.Annotations[vaultCertificateSerialAnnotation] = serial
+	secret.Data = data
+
+	if create {
+		return s.Create(ctx, secret)
+	}
+	return s.Update(ctx, secret)
+}
+
+func certificateSourceSecret(path string, data map[string][]byte) *v1.Secret {
+	var sourceSecret v1.Secret
+	sourceSecret.Namespace = "<vault>"
+	sourceSecret.Name = path
+	sourceSecret.Type = v1.SecretTypeTLS
+	sourceSecret.Data = data
+	return &sourceSecret
+}
+
+// certificateNotAfter returns expiration time of a PEM-encoded certificate
+func certificateNotAfter(certificate string) (time.Time, error) {
+	block, _ := pem.Decode([]byte(certificate))
+	if block == nil {
+		return time.Time{}, errors.New("unable to decode PEM certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return cert.NotAfter, nil
+}
@@ -0,0 +1,132 @@
+package backend
+
+import (
+	"encoding/base64"
+	"fmt"
+	mirrorsv1alpha2 "github.com/ktsstudio/mirrors/api/v1alpha2"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// prefix of a Transit ciphertext, followed by a key version and a base64 encoded value: vault:v1:...
+const transitCiphertextPrefix = "vault:v"
+
+// isTransitCiphertext reports whether value has a format of a Transit ciphertext
+func isTransitCiphertext(value string) bool {
+	if !strings.HasPrefix(value, transitCiphertextPrefix) {
+		return false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(value, transitCiphertextPrefix), ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return false
+	}
+	if _, err := strconv.ParseUint(parts[0], 10, 32); err != nil {
+		return false
+	}
+	_, err := base64.StdEncoding.DecodeString(parts[1])
+	return err == nil
+}
+
+// transitBatch runs a batch operation (encrypt or decrypt) of a Transit key over inputs,
+// returning the field named outputField of every result in order
+func transitBatch(vault VaultBackend, transit *mirrorsv1alpha2.VaultTransitSpec, operation string,
+	inputs []map[string]interface{}, outputField string) ([]string, error) {
+
+	path := fmt.Sprintf("%s/%s/%s", strings.Trim(transit.Mount, "/"), operation, transit.Key)
+	batchInput := make([]interface{}, len(inputs))
+	for i := range inputs {
+		batchInput[i] = inputs[i]
+	}
+	resp, err := vault.Write(path, map[string]interface{}{
+		"batch_input": batchInput,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp == nil || resp.Data == nil {
+		return nil, fmt.Errorf("empty response from %s", path)
+	}
+
+	results, _ := resp.Data["batch_results"].([]interface{})
+	if len(results) != len(inputs) {
+		return nil, fmt.Errorf("%s returned %d results for %d inputs", path, len(results), len(inputs))
+	}
+
+	outputs := make([]string, len(results))
+	for i, result := range results {
+		result, _ := result.(map[string]interface{})
+		if errMsg, _ := result["error"].(string); errMsg != "" {
+			return nil, fmt.Errorf("%s: %s", path, errMsg)
+		}
+		output, ok := result[outputField].(string)
+		if !ok {
+			return nil, fmt.Errorf("%s returned no %s", path, outputField)
+		}
+		outputs[i] = output
+	}
+	return outputs, nil
+}
+
+func sortedKeys(data map[string][]byte) []string {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// transitEncrypt encrypts every value of data with a Transit key. Keys are left as is
+func transitEncrypt(vault VaultBackend, transit *mirrorsv1alpha2.VaultTransitSpec, data map[string][]byte) (map[string]interface{}, error) {
+	keys := sortedKeys(data)
+	if len(keys) == 0 {
+		return map[string]interface{}{}, nil
+	}
+
+	inputs := make([]map[string]interface{}, len(keys))
+	for i, k := range keys {
+		inputs[i] = map[string]interface{}{
+			"plaintext": base64.StdEncoding.EncodeToString(data[k]),
+		}
+	}
+	ciphertexts, err := transitBatch(vault, transit, "encrypt", inputs, "ciphertext")
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]interface{}, len(keys))
+	for i, k := range keys {
+		result[k] = ciphertexts[i]
+	}
+	return result, nil
+}
+
+// transitDecrypt decrypts every value of data with a Transit key
+func transitDecrypt(vault VaultBackend, transit *mirrorsv1alpha2.VaultTransitSpec, data map[string][]byte) (map[string][]byte, error) {
+	keys := sortedKeys(data)
+	if len(keys) == 0 {
+		return data, nil
+	}
+
+	inputs := make([]map[string]interface{}, len(keys))
+	for i, k := range keys {
+		inputs[i] = map[string]interface{}{
+			"ciphertext": string(data[k]),
+		}
+	}
+	plaintexts, err := transitBatch(vault, transit, "decrypt", inputs, "plaintext")
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string][]byte, len(keys))
+	for i, k := range keys {
+		value, err := base64.StdEncoding.DecodeString(plaintexts[i])
+		if err != nil {
+			return nil, fmt.Errorf("error decoding decrypted value of %s: %w", k, err)
+		}
+		result[k] = value
+	}
+	return result, nil
+}
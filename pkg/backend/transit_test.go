@@ -0,0 +1,145 @@
+package backend
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	vault "github.com/hashicorp/vault/api"
+	mirrorsv1alpha2 "github.com/ktsstudio/mirrors/api/v1alpha2"
+	"strings"
+	"testing"
+)
+
+// fakeTransit serves batch encrypt and decrypt of a Transit key, failing items whose plaintext is "fail"
+func fakeTransit(t *testing.T) *fakeVault {
+	return &fakeVault{write: func(path string, data map[string]interface{}) (*vault.Secret, error) {
+		batch, _ := data["batch_input"].([]interface{})
+		results := make([]interface{}, len(batch))
+		for i, item := range batch {
+			item := item.(map[string]interface{})
+			switch path {
+			case "transit/encrypt/team-x":
+				plaintext, _ := base64.StdEncoding.DecodeString(item["plaintext"].(string))
+				if string(plaintext) == "fail" {
+					results[i] = map[string]interface{}{"error": "encryption failed"}
+					continue
+				}
+				results[i] = map[string]interface{}{"ciphertext": "vault:v1:" + item["plaintext"].(string)}
+			case "transit/decrypt/team-x":
+				ciphertext := item["ciphertext"].(string)
+				if !strings.HasPrefix(ciphertext, "vault:v1:") {
+					results[i] = map[string]interface{}{"error": "cipher: message authentication failed"}
+					continue
+				}
+				results[i] = map[string]interface{}{"plaintext": strings.TrimPrefix(ciphertext, "vault:v1:")}
+			default:
+				t.Fatalf("unexpected write to %s", path)
+			}
+		}
+		return &vault.Secret{Data: map[string]interface{}{"batch_results": results}}, nil
+	}}
+}
+
+func testTransit() *mirrorsv1alpha2.VaultTransitSpec {
+	return &mirrorsv1alpha2.VaultTransitSpec{Mount: "transit", Key: "team-x"}
+}
+
+func TestTransitRoundTrip(t *testing.T) {
+	v := fakeTransit(t)
+	data := map[string][]byte{"user": []byte("app"), "password": {0, 1, 2, 255}}
+
+	encrypted, err := transitEncrypt(v, testTransit(), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertexts := make(map[string][]byte, len(encrypted))
+	for k, value := range encrypted {
+		if !isTransitCiphertext(value.(string)) {
+			t.Fatalf("%s is not encrypted: %q", k, value)
+		}
+		ciphertexts[k] = []byte(value.(string))
+	}
+
+	decrypted, err := transitDecrypt(v, testTransit(), ciphertexts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decrypted["user"]) != "app" || string(decrypted["password"]) != string(data["password"]) {
+		t.Fatalf("unexpected decrypted data %q", decrypted)
+	}
+}
+
+func TestTransitItemError(t *testing.T) {
+	v := fakeTransit(t)
+
+	_, err := transitEncrypt(v, testTransit(), map[string][]byte{"a": []byte("ok"), "b": []byte("fail")})
+	if err == nil || !strings.Contains(err.Error(), "encryption failed") {
+		t.Fatalf("expected an error of a failed item, got %v", err)
+	}
+
+	_, err = transitDecrypt(v, testTransit(), map[string][]byte{"a": []byte("vault:v1:b2s="), "b": []byte("vault:v2:b2s=")})
+	if err == nil || !strings.Contains(err.Error(), "message authentication failed") {
+		t.Fatalf("expected an error of a failed item, got %v", err)
+	}
+
+	v.write = func(path string, data map[string]interface{}) (*vault.Secret, error) {
+		return &vault.Secret{Data: map[string]interface{}{"batch_results": []interface{}{}}}, nil
+	}
+	if _, err := transitDecrypt(v, testTransit(), map[string][]byte{"a": []byte("vault:v1:b2s=")}); err == nil {
+		t.Fatal("expected an error of a missing result")
+	}
+}
+
+func TestIsTransitCiphertext(t *testing.T) {
+	for value, expected := range map[string]bool{
+		"vault:v1:b2s=":  true,
+		"vault:v12:b2s=": true,
+		"vault:v1:":      false,
+		"vault:vx:b2s=":  false,
+		"vault:v1:%%%":   false,
+		"b2s=":           false,
+		"plaintext":      false,
+	} {
+		if actual := isTransitCiphertext(value); actual != expected {
+			t.Errorf("isTransitCiphertext(%q) = %v, expected %v", value, actual, expected)
+		}
+	}
+}
+
+func TestVaultDestReadTransitData(t *testing.T) {
+	ctx := context.Background()
+	var mirror mirrorsv1alpha2.SecretMirror
+	mirror.Spec.Destination.Vault = &mirrorsv1alpha2.VaultSpec{Transit: testTransit()}
+	v := fakeTransit(t)
+	d := &VaultSecretDest{mirror: &mirror, vault: v}
+	kv := func(data map[string]interface{}) *vault.Secret {
+		return &vault.Secret{Data: map[string]interface{}{"data": data}}
+	}
+
+	data, err := d.readData(ctx, kv(map[string]interface{}{"password": "vault:v1:b2s="}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data["password"]) != "ok" {
+		t.Fatalf("unexpected data %q", data)
+	}
+
+	// written before transit has been enabled
+	data, err = d.readData(ctx, kv(map[string]interface{}{"password": "vault:v1:b2s=", "user": "app"}))
+	if err != nil || data != nil {
+		t.Fatalf("expected plaintext data to be treated as missing, got %q %v", data, err)
+	}
+
+	// encrypted with another key
+	if _, err := d.readData(ctx, kv(map[string]interface{}{"password": "vault:v2:b2s="})); err == nil {
+		t.Fatal("expected an error of a failed decryption")
+	}
+
+	denied := errors.New("permission denied")
+	v.write = func(path string, data map[string]interface{}) (*vault.Secret, error) {
+		return nil, denied
+	}
+	if _, err := d.readData(ctx, kv(map[string]interface{}{"password": "vault:v1:b2s="})); !errors.Is(err, denied) {
+		t.Fatalf("expected a vault error, got %v", err)
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"unicode/utf8"
 )
 
 type VaultBackend interface {
@@ -19,9 +20,12 @@ type VaultBackend interface {
 	SetToken(token string)
 	LoginAppRole(appRolePath, roleID, secretID string) error
 	ReadSecret(path string) (*vault.Secret, error)
+	List(path string) (*vault.Secret, error)
 	RetrieveData(path string) (map[string]interface{}, error)
 	WriteData(path string, data map[string]interface{}) error
+	Write(path string, data map[string]interface{}) (*vault.Secret, error)
 	RenewLease(leaseId string, increment int) (*vault.Secret, error)
+	RevokeLease(leaseId string) error
 }
 
 func authVaultBackend(ctx context.Context, cli client.Client, vault VaultBackend, auth *mirrorsv1alpha2.VaultAuthSpec) error {
@@ -110,7 +114,23 @@ func authVaultBackend(ctx context.Context, cli client.Client, vault VaultBackend
 	return nil
 }
 
-func extractVaultSecretData(secret *vault.Secret) (map[string][]byte, error) {
+// vaultCustomMetadata returns KV v2 custom metadata returned along with secret data
+func vaultCustomMetadata(secret *vault.Secret) map[string]interface{} {
+	metadata, _ := secret.Data["metadata"].(map[string]interface{})
+	customMetadata, _ := metadata["custom_metadata"].(map[string]interface{})
+	return customMetadata
+}
+
+// recordedVaultEncoding returns the encoding recorded by mirrors when a secret was written
+func recordedVaultEncoding(secret *vault.Secret) mirrorsv1alpha2.VaultEncoding {
+	encoding, _ := vaultCustomMetadata(secret)[vaultEncodingMetadataKey].(string)
+	return mirrorsv1alpha2.VaultEncoding(encoding)
+}
+
+// extractVaultSecretData decodes values of a Vault secret. With auto encoding values are decoded according to
+// the encoding recorded on write or, for secrets written by someone else, if they look like base64.
+// Non-string values are handled according to spec.StructuredValues
+func extractVaultSecretData(secret *vault.Secret, spec *mirrorsv1alpha2.VaultSpec, encoding mirrorsv1alpha2.VaultEncoding) (map[string][]byte, error) {
 	var vaultData map[string]interface{}
 
 	if data, ok := secret.Data["data"]; ok {
@@ -121,22 +141,54 @@ func extractVaultSecretData(secret *vault.Secret) (map[string][]byte, error) {
 		vaultData = secret.Data
 	}
 
-	data := make(map[string][]byte, len(vaultData))
-	for k, v := range vaultData {
-		stringValue, ok := v.(string)
-		if !ok {
-			return nil, fmt.Errorf("vault key %s contains non-string value", k)
-		}
-		var value []byte
-		// try to decode base64 strings
-		decodedValue, err := base64.StdEncoding.DecodeString(stringValue)
-		if err == nil {
-			// indeed a base64 string
-			value = decodedValue
-		} else {
-			value = []byte(stringValue)
+	if encoding == mirrorsv1alpha2.VaultEncodingAuto || encoding == "" {
+		encoding = recordedVaultEncoding(secret)
+	}
+
+	decode := func(k, stringValue string) ([]byte, error) {
+		switch encoding {
+		case mirrorsv1alpha2.VaultEncodingRaw:
+			return []byte(stringValue), nil
+		case mirrorsv1alpha2.VaultEncodingBase64:
+			decodedValue, err := base64.StdEncoding.DecodeString(stringValue)
+			if err != nil {
+				return nil, fmt.Errorf("vault key %s is not a valid base64 string: %w", k, err)
+			}
+			return decodedValue, nil
+		default:
+			// try to decode base64 strings
+			decodedValue, err := base64.StdEncoding.DecodeString(stringValue)
+			if err == nil {
+				// indeed a base64 string
+				return decodedValue, nil
+			}
+			return []byte(stringValue), nil
 		}
-		data[k] = value
+	}
+
+	data := make(map[string][]byte, len(vaultData))
+	if err := collectVaultValues(data, "", vaultData, spec, decode); err != nil {
+		return nil, err
 	}
 	return data, nil
 }
+
+// encodeVaultData encodes values to be written to Vault, returning the encoding actually used.
+// auto writes base64 so that the values are read back correctly by older versions of mirrors
+func encodeVaultData(data map[string][]byte, encoding mirrorsv1alpha2.VaultEncoding) (map[string]interface{}, mirrorsv1alpha2.VaultEncoding, error) {
+	result := make(map[string]interface{}, len(data))
+	if encoding == mirrorsv1alpha2.VaultEncodingRaw {
+		for k, v := range data {
+			if !utf8.Valid(v) {
+				return nil, "", fmt.Errorf("value of key %s is not a valid UTF-8 string, use base64 encoding", k)
+			}
+			result[k] = string(v)
+		}
+		return result, mirrorsv1alpha2.VaultEncodingRaw, nil
+	}
+
+	for k, v := range data {
+		result[k] = base64.StdEncoding.EncodeToString(v)
+	}
+	return result, mirrorsv1alpha2.VaultEncodingBase64, nil
+}
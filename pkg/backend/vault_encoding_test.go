@@ -0,0 +1,149 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	vault "github.com/hashicorp/vault/api"
+	mirrorsv1alpha2 "github.com/ktsstudio/mirrors/api/v1alpha2"
+	"github.com/ktsstudio/mirrors/pkg/reconresult"
+	"golang.org/x/time/rate"
+	"testing"
+)
+
+// kvSecret returns a KV v2 secret as read from Vault, with an encoding recorded in custom metadata if not empty
+func kvSecret(data map[string]interface{}, encoding mirrorsv1alpha2.VaultEncoding) *vault.Secret {
+	metadata := map[string]interface{}{}
+	if encoding != "" {
+		metadata["custom_metadata"] = map[string]interface{}{vaultEncodingMetadataKey: string(encoding)}
+	}
+	return &vault.Secret{Data: map[string]interface{}{"data": data, "metadata": metadata}}
+}
+
+func TestVaultEncodingRoundTrip(t *testing.T) {
+	spec := &mirrorsv1alpha2.VaultSpec{StructuredValues: mirrorsv1alpha2.VaultStructuredValuesError}
+	data := map[string][]byte{
+		"plain": []byte("secret"),
+		// a raw value which happens to be valid base64 of "secret"
+		"base64like": []byte("c2VjcmV0"),
+		"empty":      {},
+	}
+
+	for _, tt := range []struct {
+		write, read, recorded mirrorsv1alpha2.VaultEncoding
+	}{
+		{mirrorsv1alpha2.VaultEncodingAuto, mirrorsv1alpha2.VaultEncodingAuto, mirrorsv1alpha2.VaultEncodingBase64},
+		{mirrorsv1alpha2.VaultEncodingAuto, mirrorsv1alpha2.VaultEncodingBase64, mirrorsv1alpha2.VaultEncodingBase64},
+		{mirrorsv1alpha2.VaultEncodingBase64, mirrorsv1alpha2.VaultEncodingAuto, mirrorsv1alpha2.VaultEncodingBase64},
+		{mirrorsv1alpha2.VaultEncodingBase64, mirrorsv1alpha2.VaultEncodingBase64, mirrorsv1alpha2.VaultEncodingBase64},
+		{mirrorsv1alpha2.VaultEncodingRaw, mirrorsv1alpha2.VaultEncodingAuto, mirrorsv1alpha2.VaultEncodingRaw},
+		{mirrorsv1alpha2.VaultEncodingRaw, mirrorsv1alpha2.VaultEncodingRaw, mirrorsv1alpha2.VaultEncodingRaw},
+	} {
+		t.Run(string(tt.write)+"-"+string(tt.read), func(t *testing.T) {
+			encoded, encoding, err := encodeVaultData(data, tt.write)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if encoding != tt.recorded {
+				t.Fatalf("expected %s encoding to be recorded, got %s", tt.recorded, encoding)
+			}
+
+			decoded, err := extractVaultSecretData(kvSecret(encoded, encoding), spec, tt.read)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dataDiffer(data, decoded) {
+				t.Fatalf("expected %q, got %q", data, decoded)
+			}
+		})
+	}
+}
+
+func TestVaultEncodingRaw(t *testing.T) {
+	if _, _, err := encodeVaultData(map[string][]byte{"key": {0xff, 0xfe}}, mirrorsv1alpha2.VaultEncodingRaw); err == nil {
+		t.Fatal("expected an error of a binary value with raw encoding")
+	}
+
+	encoded, _, err := encodeVaultData(map[string][]byte{"key": []byte("c2VjcmV0")}, mirrorsv1alpha2.VaultEncodingRaw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encoded["key"] != "c2VjcmV0" {
+		t.Fatalf("raw value is changed on write: %q", encoded["key"])
+	}
+}
+
+func TestVaultEncodingAutoGuess(t *testing.T) {
+	spec := &mirrorsv1alpha2.VaultSpec{StructuredValues: mirrorsv1alpha2.VaultStructuredValuesError}
+	// written by someone else: values which look like base64 are decoded, others are kept
+	decoded, err := extractVaultSecretData(kvSecret(map[string]interface{}{
+		"base64": "c2VjcmV0",
+		"plain":  "not base64!",
+	}, ""), spec, mirrorsv1alpha2.VaultEncodingAuto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decoded["base64"]) != "secret" || string(decoded["plain"]) != "not base64!" {
+		t.Fatalf("unexpected values %q", decoded)
+	}
+
+	// a recorded raw encoding keeps a base64-looking value as is
+	decoded, err = extractVaultSecretData(kvSecret(map[string]interface{}{"base64": "c2VjcmV0"}, mirrorsv1alpha2.VaultEncodingRaw),
+		spec, mirrorsv1alpha2.VaultEncodingAuto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decoded["base64"]) != "c2VjcmV0" {
+		t.Fatalf("raw value has been decoded: %q", decoded["base64"])
+	}
+
+	// an explicit base64 encoding rejects other values
+	if _, err := extractVaultSecretData(kvSecret(map[string]interface{}{"plain": "not base64!"}, ""),
+		spec, mirrorsv1alpha2.VaultEncodingBase64); err == nil {
+		t.Fatal("expected an error of a non-base64 value")
+	}
+}
+
+func TestVaultDestRecordEncodingFailure(t *testing.T) {
+	ctx := context.Background()
+	var mirror mirrorsv1alpha2.SecretMirror
+	mirror.Spec.Destination.Vault = &mirrorsv1alpha2.VaultSpec{
+		Path:             "secret/data/db",
+		Encoding:         mirrorsv1alpha2.VaultEncodingRaw,
+		StructuredValues: mirrorsv1alpha2.VaultStructuredValuesError,
+	}
+
+	metadataErr := errors.New("permission denied")
+	v := &fakeVault{secrets: map[string]*vault.Secret{
+		"secret/data/db": kvSecret(map[string]interface{}{"password": "b2xk"}, mirrorsv1alpha2.VaultEncodingBase64),
+	}}
+	v.write = func(path string, data map[string]interface{}) (*vault.Secret, error) {
+		if path != "secret/metadata/db" {
+			t.Fatalf("unexpected write to %s", path)
+		}
+		if encoding := data["custom_metadata"].(map[string]interface{})[vaultEncodingMetadataKey]; encoding != "raw" {
+			t.Fatalf("unexpected encoding %v", encoding)
+		}
+		return nil, metadataErr
+	}
+	d := &VaultSecretDest{mirror: &mirror, vault: v, writeLimiter: rate.NewLimiter(rate.Inf, 1), path: "secret/data/db"}
+
+	// values are not written with an encoding which cannot be recorded, the sync is retried
+	err := d.Sync(ctx, testSourceSecret("new"))
+	if result, ok := err.(*reconresult.ReconcileResult); !ok || result.Status != mirrorsv1alpha2.MirrorStatusError {
+		t.Fatalf("expected an error result, got %v", err)
+	}
+	if stored := v.secrets["secret/data/db"]; recordedVaultEncoding(stored) != mirrorsv1alpha2.VaultEncodingBase64 ||
+		stored.Data["data"].(map[string]interface{})["password"] != "b2xk" {
+		t.Fatalf("secret has been changed by a failed sync: %v", stored.Data)
+	}
+
+	// raw values under a recorded base64 encoding, as left by a failed write of the values, are overwritten
+	metadataErr = nil
+	v.secrets["secret/data/db"] = kvSecret(map[string]interface{}{"password": "not base64!"}, mirrorsv1alpha2.VaultEncodingBase64)
+	if err := d.Sync(ctx, testSourceSecret("new")); err != nil {
+		t.Fatal(err)
+	}
+	if password := v.secrets["secret/data/db"].Data["data"].(map[string]interface{})["password"]; password != "new" {
+		t.Fatalf("unexpected password %v", password)
+	}
+}
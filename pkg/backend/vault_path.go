@@ -0,0 +1,50 @@
+package backend
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// VaultPathData holds variables available in a destination Vault path template
+type VaultPathData struct {
+	// Namespace of a SecretMirror
+	Namespace string
+	// Name of a SecretMirror
+	Name string
+	// Name of a source secret
+	SourceName string
+	// Cluster name set on the controller
+	Cluster string
+	// Arbitrary variables set on the controller, e.g. {{ .Vars.region }}
+	Vars map[string]string
+}
+
+// renderVaultPath executes a path template. Referencing an unset variable is an error, and so is
+// an empty path segment, e.g. of an empty cluster name or a field which missingkey=error does not cover
+func renderVaultPath(pathTemplate string, data VaultPathData) (string, error) {
+	tpl, err := template.New("path").Option("missingkey=error").Parse(pathTemplate)
+	if err != nil {
+		return "", err
+	}
+
+	var path strings.Builder
+	if err := tpl.Execute(&path, data); err != nil {
+		return "", err
+	}
+
+	rendered := path.String()
+	segments := rendered
+	if strings.HasPrefix(pathTemplate, "/") {
+		segments = strings.TrimPrefix(segments, "/")
+	}
+	if strings.HasSuffix(pathTemplate, "/") {
+		segments = strings.TrimSuffix(segments, "/")
+	}
+	for _, segment := range strings.Split(segments, "/") {
+		if strings.TrimSpace(segment) == "" {
+			return "", fmt.Errorf("path %q has an empty segment, a variable in %q is probably empty", rendered, pathTemplate)
+		}
+	}
+	return rendered, nil
+}
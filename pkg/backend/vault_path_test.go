@@ -0,0 +1,56 @@
+package backend
+
+import "testing"
+
+func TestRenderVaultPath(t *testing.T) {
+	data := VaultPathData{
+		Namespace:  "team-a",
+		Name:       "db",
+		SourceName: "db-credentials",
+		Cluster:    "prod",
+		Vars:       map[string]string{"region": "eu-west", "empty": ""},
+	}
+	tests := []struct {
+		template string
+		expected string
+		err      bool
+	}{
+		{template: "secret/data/db", expected: "secret/data/db"},
+		{template: "/secret/data/db", expected: "/secret/data/db"},
+		{template: "secret/data/{{ .Cluster }}/{{ .Namespace }}/{{ .Name }}", expected: "secret/data/prod/team-a/db"},
+		{template: "secret/data/{{ .Vars.region }}/{{ .SourceName }}", expected: "secret/data/eu-west/db-credentials"},
+		{template: "secret/data/{{ .Cluster }}-{{ .Vars.empty }}/db", expected: "secret/data/prod-/db"},
+		// unset map keys
+		{template: "secret/data/{{ .Vars.zone }}/db", err: true},
+		// empty fields and variables
+		{template: "secret/data/{{ .Vars.empty }}/db", err: true},
+		{template: "secret/data/db/{{ .Vars.empty }}", err: true},
+		{template: "{{ .Vars.empty }}/secret/data/db", err: true},
+		{template: "secret/data/{{ .Vars.empty }} /db", err: true},
+		{template: "secret//data/db", err: true},
+		// unknown fields and invalid templates
+		{template: "secret/data/{{ .Unknown }}", err: true},
+		{template: "secret/data/{{ .Name", err: true},
+	}
+
+	for _, test := range tests {
+		path, err := renderVaultPath(test.template, data)
+		if test.err {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %q", test.template, path)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %s", test.template, err)
+		} else if path != test.expected {
+			t.Errorf("%q: expected %q, got %q", test.template, test.expected, path)
+		}
+	}
+
+	// empty fields of a struct are not caught by missingkey=error
+	data.Cluster = ""
+	if path, err := renderVaultPath("secret/data/{{ .Cluster }}/db", data); err == nil {
+		t.Errorf("expected an error of an empty cluster name, got %q", path)
+	}
+}
@@ -0,0 +1,237 @@
+package backend
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	vault "github.com/hashicorp/vault/api"
+	mirrorsv1alpha2 "github.com/ktsstudio/mirrors/api/v1alpha2"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"math/big"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"testing"
+	"time"
+)
+
+func testCertificate(t *testing.T, notAfter time.Time) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "db.example.com"},
+		NotBefore:    notAfter.Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func testPKISource(v *fakeVault) *VaultSecretSource {
+	s, _ := testVaultSource(v, &mirrorsv1alpha2.VaultSpec{
+		PKI: &mirrorsv1alpha2.VaultPKISpec{
+			Role:       "web",
+			CommonName: "db.example.com",
+			AltNames:   []string{"db", "db.default"},
+			TTL:        "10h",
+		},
+	})
+	return s
+}
+
+func TestCertificateNotAfter(t *testing.T) {
+	notAfter := time.Now().Add(10 * time.Hour).Truncate(time.Second).UTC()
+	actual, err := certificateNotAfter(testCertificate(t, notAfter))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !actual.Equal(notAfter) {
+		t.Fatalf("expected %s, got %s", notAfter, actual)
+	}
+
+	if _, err := certificateNotAfter("not a certificate"); err == nil {
+		t.Fatal("expected an error of a non-PEM input")
+	}
+	invalid := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("garbage")}))
+	if _, err := certificateNotAfter(invalid); err == nil {
+		t.Fatal("expected an error of an invalid certificate")
+	}
+}
+
+func TestVaultIssueCertificate(t *testing.T) {
+	ctx := context.Background()
+	notAfter := time.Now().Add(10 * time.Hour).Truncate(time.Second)
+	certificate := testCertificate(t, notAfter)
+
+	var issued []string
+	v := &fakeVault{write: func(path string, data map[string]interface{}) (*vault.Secret, error) {
+		issued = append(issued, path)
+		if data["common_name"] != "db.example.com" || data["alt_names"] != "db,db.default" || data["ttl"] != "10h" {
+			t.Fatalf("unexpected issue request %v", data)
+		}
+		return &vault.Secret{Data: map[string]interface{}{
+			"certificate":   certificate,
+			"private_key":   "key",
+			"issuing_ca":    "ca",
+			"serial_number": "01:02",
+		}}, nil
+	}}
+	s := testPKISource(v)
+
+	secret, err := s.Retrieve(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issued) != 1 || issued[0] != "pki/issue/web" {
+		t.Fatalf("unexpected issue requests %q", issued)
+	}
+	if secret.Type != v1.SecretTypeTLS || string(secret.Data[v1.TLSCertKey]) != certificate ||
+		string(secret.Data[v1.TLSPrivateKeyKey]) != "key" || string(secret.Data[tlsCAKey]) != "ca" {
+		t.Fatalf("unexpected certificate secret %v %q", secret.Type, secret.Data)
+	}
+
+	status := s.mirror.Status.VaultSource
+	if status == nil || status.CertificateSerial != "01:02" || !status.CertificateNotAfter.Time.Equal(notAfter) {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	// re-issued at the default 2/3 of the lifetime
+	renewIn := time.Until(status.LeaseRenewAt.Time)
+	if renewIn < 6*time.Hour || renewIn > 7*time.Hour {
+		t.Fatalf("unexpected re-issue in %s", renewIn)
+	}
+
+	var stored v1.Secret
+	if err := s.Get(ctx, types.NamespacedName{Namespace: "default", Name: "db.vault-pki"}, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.Annotations[vaultCertificateSerialAnnotation] != "01:02" || len(stored.OwnerReferences) != 1 ||
+		stored.OwnerReferences[0].Kind != "SecretMirror" || stored.OwnerReferences[0].Name != "db" {
+		t.Fatalf("unexpected stored certificate %v %v", stored.Annotations, stored.OwnerReferences)
+	}
+
+	// a fresh certificate is not re-issued, the current one is synced again
+	secret, err = s.Retrieve(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issued) != 1 {
+		t.Fatalf("a fresh certificate has been re-issued: %q", issued)
+	}
+	if secret.Type != v1.SecretTypeTLS || string(secret.Data[v1.TLSCertKey]) != certificate {
+		t.Fatalf("unexpected current certificate secret %v %q", secret.Type, secret.Data)
+	}
+
+	// a fresh certificate which is not stored is re-issued
+	if err := s.Delete(ctx, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Retrieve(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(issued) != 2 {
+		t.Fatalf("a lost certificate has not been re-issued: %q", issued)
+	}
+
+	// a certificate past the threshold is re-issued
+	renewAt := metav1.NewTime(time.Now().Add(-time.Minute))
+	s.mirror.Status.VaultSource.LeaseRenewAt = &renewAt
+	if _, err := s.Retrieve(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(issued) != 3 {
+		t.Fatalf("a due certificate has not been re-issued: %q", issued)
+	}
+}
+
+// failingCreateClient fails to create secrets a number of times
+type failingCreateClient struct {
+	client.Client
+	failures int
+}
+
+func (c *failingCreateClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	if c.failures > 0 {
+		c.failures--
+		return errors.New("create failed")
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func TestVaultCertificateDeliveredAfterFailedSync(t *testing.T) {
+	ctx := context.Background()
+	certificate := testCertificate(t, time.Now().Add(10*time.Hour))
+	var issued int
+	s := testPKISource(&fakeVault{write: func(string, map[string]interface{}) (*vault.Secret, error) {
+		issued++
+		return &vault.Secret{Data: map[string]interface{}{
+			"certificate":   certificate,
+			"private_key":   "key",
+			"serial_number": "01:02",
+		}}, nil
+	}})
+	s.mirror.Spec.Source.Name = "db-tls"
+	d, _ := testNamespacesDest(s.mirror)
+	d.Client = &failingCreateClient{Client: d.Client, failures: 1}
+	name := types.NamespacedName{Namespace: "apps", Name: "db-tls"}
+
+	secret, err := s.Retrieve(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.syncOneToNamespace(ctx, secret, name); err == nil {
+		t.Fatal("expected the first sync to fail")
+	}
+	if err := s.AfterSync(ctx, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// the next reconcile delivers the certificate which is not due for re-issue yet
+	secret, err = s.Retrieve(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.syncOneToNamespace(ctx, secret, name); err != nil {
+		t.Fatal(err)
+	}
+	if issued != 1 {
+		t.Fatalf("certificate has been issued %d times", issued)
+	}
+	var copied v1.Secret
+	if err := d.Get(ctx, name, &copied); err != nil {
+		t.Fatal(err)
+	}
+	if copied.Type != v1.SecretTypeTLS || string(copied.Data[v1.TLSCertKey]) != certificate {
+		t.Fatalf("certificate has not been delivered: %v %q", copied.Type, copied.Data)
+	}
+}
+
+func TestVaultIssueCertificateInvalidResponse(t *testing.T) {
+	for name, data := range map[string]map[string]interface{}{
+		"no data":        nil,
+		"no private key": {"certificate": "cert"},
+		"invalid cert":   {"certificate": "cert", "private_key": "key"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := testPKISource(&fakeVault{write: func(path string, _ map[string]interface{}) (*vault.Secret, error) {
+				return &vault.Secret{Data: data}, nil
+			}})
+			if _, err := s.issueCertificate(context.Background()); err == nil {
+				t.Fatal("expected an error")
+			}
+			if s.mirror.Status.VaultSource != nil {
+				t.Fatalf("status is updated on a failed issue: %+v", s.mirror.Status.VaultSource)
+			}
+		})
+	}
+}
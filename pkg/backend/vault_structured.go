@@ -0,0 +1,88 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	mirrorsv1alpha2 "github.com/ktsstudio/mirrors/api/v1alpha2"
+	"sort"
+	"strings"
+)
+
+// collectVaultValues puts values of a Vault secret into data under prefix. Strings are decoded with decode,
+// nested objects are flattened into keys joined with a separator or serialized as JSON like other
+// non-string values, depending on spec.StructuredValues
+func collectVaultValues(data map[string][]byte, prefix string, values map[string]interface{},
+	spec *mirrorsv1alpha2.VaultSpec, decode func(k, stringValue string) ([]byte, error)) error {
+
+	for k, v := range values {
+		key := prefix + k
+		if _, ok := data[key]; ok {
+			return fmt.Errorf("vault key %s is ambiguous after flattening", key)
+		}
+
+		switch v := v.(type) {
+		case string:
+			value, err := decode(key, v)
+			if err != nil {
+				return err
+			}
+			data[key] = value
+			continue
+		case map[string]interface{}:
+			if spec.StructuredValues == mirrorsv1alpha2.VaultStructuredValuesFlatten {
+				if err := collectVaultValues(data, key+spec.FlattenSeparator, v, spec, decode); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		switch spec.StructuredValues {
+		case mirrorsv1alpha2.VaultStructuredValuesJSON, mirrorsv1alpha2.VaultStructuredValuesFlatten:
+			value, err := json.Marshal(v)
+			if err != nil {
+				return fmt.Errorf("unable to serialize vault key %s: %w", key, err)
+			}
+			data[key] = value
+		default:
+			return fmt.Errorf("vault key %s contains non-string value", key)
+		}
+	}
+	return nil
+}
+
+// unflattenVaultData rebuilds nested objects from keys joined with separator, the inverse of flattening
+func unflattenVaultData(data map[string]interface{}, separator string) (map[string]interface{}, error) {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	result := make(map[string]interface{})
+	for _, k := range keys {
+		parts := strings.Split(k, separator)
+		node := result
+		for i, part := range parts[:len(parts)-1] {
+			child, ok := node[part]
+			if !ok {
+				nested := make(map[string]interface{})
+				node[part] = nested
+				node = nested
+				continue
+			}
+			nested, ok := child.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("key %s conflicts with key %s", k, strings.Join(parts[:i+1], separator))
+			}
+			node = nested
+		}
+
+		last := parts[len(parts)-1]
+		if _, ok := node[last]; ok {
+			return nil, fmt.Errorf("key %s conflicts with a nested key", k)
+		}
+		node[last] = data[k]
+	}
+	return result, nil
+}
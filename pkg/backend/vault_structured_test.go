@@ -0,0 +1,199 @@
+package backend
+
+import (
+	"encoding/json"
+	mirrorsv1alpha2 "github.com/ktsstudio/mirrors/api/v1alpha2"
+	"reflect"
+	"testing"
+)
+
+func rawDecode(k, stringValue string) ([]byte, error) {
+	return []byte(stringValue), nil
+}
+
+func TestCollectVaultValues(t *testing.T) {
+	values := map[string]interface{}{
+		"user": "app",
+		"db": map[string]interface{}{
+			"host": "localhost",
+			"port": json.Number("5432"),
+			"tls": map[string]interface{}{
+				"enabled": true,
+			},
+		},
+		"hosts": []interface{}{"a", "b"},
+		"none":  nil,
+	}
+
+	tests := []struct {
+		name     string
+		policy   mirrorsv1alpha2.VaultStructuredValues
+		values   map[string]interface{}
+		expected map[string]string
+		err      bool
+	}{
+		{
+			name:     "strings",
+			policy:   mirrorsv1alpha2.VaultStructuredValuesError,
+			values:   map[string]interface{}{"user": "app", "password": "secret"},
+			expected: map[string]string{"user": "app", "password": "secret"},
+		},
+		{
+			name:   "error on nested values",
+			policy: mirrorsv1alpha2.VaultStructuredValuesError,
+			values: values,
+			err:    true,
+		},
+		{
+			name:   "error on a scalar",
+			policy: mirrorsv1alpha2.VaultStructuredValuesError,
+			values: map[string]interface{}{"port": json.Number("5432")},
+			err:    true,
+		},
+		{
+			name:   "json",
+			policy: mirrorsv1alpha2.VaultStructuredValuesJSON,
+			values: values,
+			expected: map[string]string{
+				"user":  "app",
+				"db":    `{"host":"localhost","port":5432,"tls":{"enabled":true}}`,
+				"hosts": `["a","b"]`,
+				"none":  "null",
+			},
+		},
+		{
+			name:   "flatten",
+			policy: mirrorsv1alpha2.VaultStructuredValuesFlatten,
+			values: values,
+			expected: map[string]string{
+				"user":           "app",
+				"db.host":        "localhost",
+				"db.port":        "5432",
+				"db.tls.enabled": "true",
+				"hosts":          `["a","b"]`,
+				"none":           "null",
+			},
+		},
+		{
+			name:   "flatten collision",
+			policy: mirrorsv1alpha2.VaultStructuredValuesFlatten,
+			values: map[string]interface{}{
+				"db.host": "a",
+				"db":      map[string]interface{}{"host": "b"},
+			},
+			err: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := &mirrorsv1alpha2.VaultSpec{StructuredValues: tt.policy, FlattenSeparator: "."}
+			data := make(map[string][]byte)
+			err := collectVaultValues(data, "", tt.values, spec, rawDecode)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected an error, got %q", data)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			actual := make(map[string]string, len(data))
+			for k, v := range data {
+				actual[k] = string(v)
+			}
+			if !reflect.DeepEqual(actual, tt.expected) {
+				t.Fatalf("expected %q, got %q", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestUnflattenVaultData(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     map[string]interface{}
+		expected map[string]interface{}
+		err      bool
+	}{
+		{
+			name:     "flat",
+			data:     map[string]interface{}{"user": "app"},
+			expected: map[string]interface{}{"user": "app"},
+		},
+		{
+			name: "nested",
+			data: map[string]interface{}{"user": "app", "db.host": "localhost", "db.tls.enabled": "true"},
+			expected: map[string]interface{}{
+				"user": "app",
+				"db": map[string]interface{}{
+					"host": "localhost",
+					"tls":  map[string]interface{}{"enabled": "true"},
+				},
+			},
+		},
+		{
+			name: "value and nested key",
+			data: map[string]interface{}{"db": "a", "db.host": "b"},
+			err:  true,
+		},
+		{
+			name: "deep value and nested key",
+			data: map[string]interface{}{"db.tls": "a", "db.tls.enabled": "b"},
+			err:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, err := unflattenVaultData(tt.data, ".")
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", actual)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(actual, tt.expected) {
+				t.Fatalf("expected %v, got %v", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestFlattenRoundTrip(t *testing.T) {
+	for _, separator := range []string{".", "__"} {
+		spec := &mirrorsv1alpha2.VaultSpec{
+			StructuredValues: mirrorsv1alpha2.VaultStructuredValuesFlatten,
+			FlattenSeparator: separator,
+		}
+		values := map[string]interface{}{
+			"user": "app",
+			"db": map[string]interface{}{
+				"host": "localhost",
+				"tls":  map[string]interface{}{"ca": "cert"},
+			},
+		}
+
+		data := make(map[string][]byte)
+		if err := collectVaultValues(data, "", values, spec, rawDecode); err != nil {
+			t.Fatal(err)
+		}
+		flat := make(map[string]interface{}, len(data))
+		for k, v := range data {
+			flat[k] = string(v)
+		}
+
+		actual, err := unflattenVaultData(flat, separator)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(actual, values) {
+			t.Fatalf("separator %q: expected %v, got %v", separator, values, actual)
+		}
+	}
+}
@@ -0,0 +1,501 @@
+package backend
+
+import (
+	"context"
+	vault "github.com/hashicorp/vault/api"
+	mirrorsv1alpha2 "github.com/ktsstudio/mirrors/api/v1alpha2"
+	"github.com/ktsstudio/mirrors/pkg/reconresult"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeVault is a VaultBackend serving secrets from memory
+type fakeVault struct {
+	secrets map[string]*vault.Secret
+	// handles Write, e.g. of Transit or PKI endpoints
+	write func(path string, data map[string]interface{}) (*vault.Secret, error)
+	// handles RenewLease, an error by default
+	renew func(leaseID string, increment int) (*vault.Secret, error)
+	// returned by RevokeLease
+	revokeErr error
+
+	reads   []string
+	renewed []string
+	revoked []string
+}
+
+func (v *fakeVault) Addr() string                                            { return "https://vault.test" }
+func (v *fakeVault) Token() string                                           { return "token" }
+func (v *fakeVault) SetToken(token string)                                   {}
+func (v *fakeVault) LoginAppRole(appRolePath, roleID, secretID string) error { return nil }
+
+func (v *fakeVault) ReadSecret(path string) (*vault.Secret, error) {
+	v.reads = append(v.reads, path)
+	return v.secrets[path], nil
+}
+
+func (v *fakeVault) List(path string) (*vault.Secret, error) {
+	return v.secrets[path], nil
+}
+
+func (v *fakeVault) RetrieveData(path string) (map[string]interface{}, error) {
+	secret := v.secrets[path]
+	if secret == nil {
+		return nil, nil
+	}
+	return secret.Data, nil
+}
+
+func (v *fakeVault) WriteData(path string, data map[string]interface{}) error {
+	if v.secrets == nil {
+		v.secrets = make(map[string]*vault.Secret)
+	}
+	v.secrets[path] = &vault.Secret{Data: data}
+	return nil
+}
+
+func (v *fakeVault) Write(path string, data map[string]interface{}) (*vault.Secret, error) {
+	if v.write == nil {
+		return nil, v.WriteData(path, data)
+	}
+	return v.write(path, data)
+}
+
+func (v *fakeVault) RenewLease(leaseID string, increment int) (*vault.Secret, error) {
+	v.renewed = append(v.renewed, leaseID)
+	if v.renew == nil {
+		return nil, &vault.ResponseError{StatusCode: 500}
+	}
+	return v.renew(leaseID, increment)
+}
+
+func (v *fakeVault) RevokeLease(leaseID string) error {
+	v.revoked = append(v.revoked, leaseID)
+	return v.revokeErr
+}
+
+func testVaultSource(v *fakeVault, spec *mirrorsv1alpha2.VaultSpec) (*VaultSecretSource, *record.FakeRecorder) {
+	var mirror mirrorsv1alpha2.SecretMirror
+	mirror.Namespace, mirror.Name = "default", "db"
+	mirror.Spec.Source.Type = mirrorsv1alpha2.SourceTypeVault
+	mirror.Spec.Source.Vault = spec
+	mirror.Spec.Source.Vault.Auth.Token = &mirrorsv1alpha2.VaultTokenAuthSpec{SecretRef: v1.SecretReference{Name: "vault"}}
+	mirror.Spec.Source.Vault.DefaultSource(mirror.Namespace)
+	recorder := record.NewFakeRecorder(100)
+	return &VaultSecretSource{
+		Client:        fake.NewClientBuilder().Build(),
+		EventRecorder: recorder,
+		mirror:        &mirror,
+		vault:         v,
+	}, recorder
+}
+
+func TestVaultRetrieveForPlan(t *testing.T) {
+	ctx := context.Background()
+	v := &fakeVault{secrets: map[string]*vault.Secret{
+		"secret/data/db": {Data: map[string]interface{}{
+			"data": map[string]interface{}{"password": "secret"},
+		}},
+		"database/creds/app": {
+			LeaseID:       "database/creds/app/1",
+			LeaseDuration: 3600,
+			Renewable:     true,
+			Data:          map[string]interface{}{"username": "v-app", "password": "generated"},
+		},
+	}}
+
+	s, _ := testVaultSource(v, &mirrorsv1alpha2.VaultSpec{Path: "secret/data/db"})
+	secrets, err := s.RetrieveForPlan(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secrets) != 1 || string(secrets[0].Data["password"]) != "secret" {
+		t.Fatalf("unexpected secrets %v", secrets)
+	}
+
+	// dynamic credentials fetched by a dry run are revoked and never kept in status
+	s, _ = testVaultSource(v, &mirrorsv1alpha2.VaultSpec{Path: "database/creds/app"})
+	if _, err := s.RetrieveForPlan(ctx); !isDryRunUnsupported(err) {
+		t.Fatalf("expected a dry run of dynamic credentials to be refused, got %v", err)
+	}
+	if len(v.revoked) != 1 || v.revoked[0] != "database/creds/app/1" || s.mirror.Status.VaultSource != nil {
+		t.Fatalf("lease of a dry run has been kept: revoked %q, status %v", v.revoked, s.mirror.Status.VaultSource)
+	}
+
+	// an existing lease is neither renewed nor replaced
+	v.reads = nil
+	s.mirror.Status.VaultSource = &mirrorsv1alpha2.VaultSourceStatusSpec{LeaseID: "database/creds/app/0", LeaseDuration: 3600}
+	if _, err := s.RetrieveForPlan(ctx); !isDryRunUnsupported(err) {
+		t.Fatalf("expected a dry run of a leased secret to be refused, got %v", err)
+	}
+	if len(v.reads) != 0 || len(v.renewed) != 0 {
+		t.Fatalf("a dry run has read %q and renewed %q", v.reads, v.renewed)
+	}
+
+	// a certificate is never issued
+	s, _ = testVaultSource(v, &mirrorsv1alpha2.VaultSpec{PKI: &mirrorsv1alpha2.VaultPKISpec{Role: "web", CommonName: "web.test"}})
+	if _, err := s.RetrieveForPlan(ctx); !isDryRunUnsupported(err) {
+		t.Fatalf("expected a dry run of a pki source to be refused, got %v", err)
+	}
+}
+
+func isDryRunUnsupported(err error) bool {
+	res, ok := err.(*reconresult.ReconcileResult)
+	return ok && res.EventReason == "DryRunUnsupported"
+}
+
+func testLeasedVaultSource(v *fakeVault, renewAt, expiresAt time.Time) *VaultSecretSource {
+	s, _ := testVaultSource(v, &mirrorsv1alpha2.VaultSpec{Path: "database/creds/app"})
+	renewAtTime, expiresAtTime := metav1.NewTime(renewAt), metav1.NewTime(expiresAt)
+	s.mirror.Status.VaultSource = &mirrorsv1alpha2.VaultSourceStatusSpec{
+		LeaseID:        "database/creds/app/1",
+		LeaseDuration:  3600,
+		LeaseRenewAt:   &renewAtTime,
+		LeaseExpiresAt: &expiresAtTime,
+	}
+	return s
+}
+
+func newVaultCreds() map[string]*vault.Secret {
+	return map[string]*vault.Secret{
+		"database/creds/app": {
+			LeaseID:       "database/creds/app/2",
+			LeaseDuration: 3600,
+			Renewable:     true,
+			Data:          map[string]interface{}{"username": "v-app-2", "password": "generated"},
+		},
+	}
+}
+
+func TestVaultLeaseRenewal(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	// not due yet
+	v := &fakeVault{secrets: newVaultCreds()}
+	s := testLeasedVaultSource(v, now.Add(time.Minute), now.Add(time.Hour))
+	if _, err := s.Retrieve(ctx); !syncSucceeded(err) {
+		t.Fatal(err)
+	}
+	if len(v.renewed) != 0 || len(v.reads) != 0 {
+		t.Fatalf("a fresh lease has been renewed %q or read %q", v.renewed, v.reads)
+	}
+
+	// due - renewed by its duration
+	v.renew = func(leaseID string, increment int) (*vault.Secret, error) {
+		return &vault.Secret{LeaseID: leaseID, LeaseDuration: increment}, nil
+	}
+	s = testLeasedVaultSource(v, now.Add(-time.Second), now.Add(time.Hour))
+	s.mirror.Status.VaultSource.LeaseRenewError = "previous error"
+	if _, err := s.Retrieve(ctx); !syncSucceeded(err) {
+		t.Fatal(err)
+	}
+	lease := s.mirror.Status.VaultSource
+	if len(v.renewed) != 1 || len(v.reads) != 0 || lease.LeaseRenewError != "" || lease.MaxTTLReached {
+		t.Fatalf("lease has not been renewed: renewed %q, read %q, status %+v", v.renewed, v.reads, lease)
+	}
+	if renewIn := time.Until(lease.LeaseRenewAt.Time); renewIn < 39*time.Minute || renewIn > 40*time.Minute {
+		t.Fatalf("renewal is scheduled in %s instead of 66%% of the lease duration", renewIn)
+	}
+
+	// renewed for less than its duration - the max ttl has been reached
+	v.renew = func(leaseID string, increment int) (*vault.Secret, error) {
+		return &vault.Secret{LeaseID: leaseID, LeaseDuration: 600}, nil
+	}
+	s = testLeasedVaultSource(v, now.Add(-time.Second), now.Add(time.Hour))
+	if _, err := s.Retrieve(ctx); !syncSucceeded(err) {
+		t.Fatal(err)
+	}
+	if !s.mirror.Status.VaultSource.MaxTTLReached {
+		t.Fatal("max ttl of a lease is not detected")
+	}
+}
+
+func TestVaultLeaseRenewalFailure(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	// plenty of time left - the lease is kept and the failure reported
+	v := &fakeVault{secrets: newVaultCreds()}
+	s := testLeasedVaultSource(v, now.Add(-time.Second), now.Add(time.Hour))
+	recorder := s.EventRecorder.(*record.FakeRecorder)
+	if _, err := s.Retrieve(ctx); !syncSucceeded(err) {
+		t.Fatal(err)
+	}
+	lease := s.mirror.Status.VaultSource
+	if lease == nil || lease.LeaseID != "database/creds/app/1" || len(v.reads) != 0 {
+		t.Fatalf("a lease failed to renew has been dropped early: %+v, read %q", lease, v.reads)
+	}
+	if lease.LeaseRenewError == "" || !lease.LeaseRenewAt.Time.After(now) {
+		t.Fatalf("renewal failure is not recorded or retried: %+v", lease)
+	}
+	if events := eventReasons(recorder); len(events) != 1 || !strings.Contains(events[0], "VaultLeaseRenewFailed") {
+		t.Fatalf("expected a VaultLeaseRenewFailed event, got %q", events)
+	}
+
+	// about to expire - new credentials are fetched and the old lease is revoked after sync
+	v = &fakeVault{secrets: newVaultCreds()}
+	s = testLeasedVaultSource(v, now.Add(-time.Second), now.Add(5*time.Minute))
+	secret, err := s.Retrieve(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(secret.Data["username"]) != "v-app-2" || s.mirror.Status.VaultSource.LeaseID != "database/creds/app/2" {
+		t.Fatalf("new credentials have not been fetched: %q, %+v", secret.Data, s.mirror.Status.VaultSource)
+	}
+	superseded := s.mirror.Status.SupersededLeases
+	if len(superseded) != 1 || superseded[0].LeaseID != "database/creds/app/1" {
+		t.Fatalf("expiring lease is not superseded: %+v", superseded)
+	}
+}
+
+func TestVaultSupersededLeases(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	at := func(d time.Duration) *metav1.Time {
+		t := metav1.NewTime(now.Add(d))
+		return &t
+	}
+
+	v := &fakeVault{}
+	s, recorder := testVaultSource(v, &mirrorsv1alpha2.VaultSpec{Path: "database/creds/app"})
+	s.mirror.Status.SupersededLeases = []mirrorsv1alpha2.SupersededLeaseSpec{
+		{LeaseID: "pending", LeaseExpiresAt: at(time.Hour)},
+		{LeaseID: "expired", LeaseExpiresAt: at(-time.Second), RevokeAt: at(-time.Minute)},
+		{LeaseID: "waiting", LeaseExpiresAt: at(time.Hour), RevokeAt: at(time.Minute)},
+		{LeaseID: "due", LeaseExpiresAt: at(time.Hour), RevokeAt: at(-time.Second)},
+	}
+
+	// new credentials have not been synced - nothing is scheduled for revocation yet
+	if err := s.AfterSync(ctx, false); err != nil {
+		t.Fatal(err)
+	}
+	leases := s.mirror.Status.SupersededLeases
+	if len(leases) != 2 || leases[0].LeaseID != "pending" || leases[0].RevokeAt != nil || leases[1].LeaseID != "waiting" {
+		t.Fatalf("unexpected superseded leases %+v", leases)
+	}
+	if len(v.revoked) != 1 || v.revoked[0] != "due" {
+		t.Fatalf("unexpected revoked leases %q", v.revoked)
+	}
+	if events := eventReasons(recorder); len(events) != 1 || !strings.Contains(events[0], "VaultLeaseRevoked") {
+		t.Fatalf("expected a VaultLeaseRevoked event, got %q", events)
+	}
+
+	// synced - revoked after the grace period
+	if err := s.AfterSync(ctx, true); err != nil {
+		t.Fatal(err)
+	}
+	revokeIn := time.Until(s.mirror.Status.SupersededLeases[0].RevokeAt.Time)
+	if revokeIn < 59*time.Second || revokeIn > time.Minute {
+		t.Fatalf("revocation is scheduled in %s instead of the grace period", revokeIn)
+	}
+
+	// a failed revocation is retried
+	v.revoked, v.revokeErr = nil, &vault.ResponseError{StatusCode: 503}
+	s.mirror.Status.SupersededLeases = []mirrorsv1alpha2.SupersededLeaseSpec{
+		{LeaseID: "due", LeaseExpiresAt: at(time.Hour), RevokeAt: at(-time.Second)},
+	}
+	if err := s.AfterSync(ctx, true); err == nil {
+		t.Fatal("failed revocation is not reported")
+	}
+	leases = s.mirror.Status.SupersededLeases
+	if len(leases) != 1 || !leases[0].RevokeAt.Time.After(now) {
+		t.Fatalf("failed revocation is not retried: %+v", leases)
+	}
+}
+
+func TestVaultDryRunKeepsLeases(t *testing.T) {
+	ctx := context.Background()
+	expiresAt, revokeAt := metav1.NewTime(time.Now().Add(time.Hour)), metav1.NewTime(time.Now().Add(-time.Second))
+	mirror := &mirrorsv1alpha2.SecretMirror{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "db"},
+		Spec: mirrorsv1alpha2.SecretMirrorSpec{
+			Source: mirrorsv1alpha2.SecretMirrorSource{
+				Type: mirrorsv1alpha2.SourceTypeVault,
+				Name: "db",
+				Vault: &mirrorsv1alpha2.VaultSpec{
+					Addr: "https://vault.test",
+					Path: "secret/data/db",
+					Auth: mirrorsv1alpha2.VaultAuthSpec{
+						Token: &mirrorsv1alpha2.VaultTokenAuthSpec{SecretRef: v1.SecretReference{Name: "vault"}},
+					},
+				},
+			},
+			Destination: mirrorsv1alpha2.SecretMirrorDestination{Namespaces: []string{"apps-.*"}},
+			DryRun:      true,
+		},
+		Status: mirrorsv1alpha2.SecretMirrorStatus{
+			SupersededLeases: []mirrorsv1alpha2.SupersededLeaseSpec{
+				{LeaseID: "due", LeaseExpiresAt: &expiresAt, RevokeAt: &revokeAt},
+			},
+		},
+	}
+	v := &fakeVault{secrets: map[string]*vault.Secret{
+		"secret/data/db": {Data: map[string]interface{}{"data": map[string]interface{}{"password": "secret"}}},
+	}}
+	b := testBackend(t, Options{}, mirror,
+		&v1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "vault"}, Data: map[string][]byte{"token": []byte("token")}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps-1"}},
+	)
+	b.vaultBackendMaker = func(addr string) (VaultBackend, error) {
+		return v, nil
+	}
+
+	mirrorContext, err := b.Init(ctx, types.NamespacedName{Namespace: "default", Name: "db"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = mirrorContext.Sync(ctx)
+	if res, ok := err.(*reconresult.ReconcileResult); !ok || res.Status != mirrorsv1alpha2.MirrorStatusDryRun {
+		t.Fatalf("unexpected result of a dry run %v", err)
+	}
+	if len(v.revoked) != 0 || len(mirrorContext.SecretMirror.Status.SupersededLeases) != 1 {
+		t.Fatalf("a dry run has revoked leases %q", v.revoked)
+	}
+}
+
+func TestVaultDestDefaults(t *testing.T) {
+	mirror := &mirrorsv1alpha2.SecretMirror{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "db"}}
+	mirror.Spec.Source.Type = mirrorsv1alpha2.SourceTypeVault
+	mirror.Spec.Source.Vault = &mirrorsv1alpha2.VaultSpec{Path: "database/creds/app"}
+	mirror.Spec.Source.Vault.Auth.Token = &mirrorsv1alpha2.VaultTokenAuthSpec{}
+	mirror.Spec.Destination.Type = mirrorsv1alpha2.DestTypeVault
+	mirror.Spec.Destination.Vault = &mirrorsv1alpha2.VaultSpec{Path: "secret/data/db"}
+	mirror.Spec.Destination.Vault.Auth.Token = &mirrorsv1alpha2.VaultTokenAuthSpec{}
+	mirror.Default()
+
+	source, dest := mirror.Spec.Source.Vault, mirror.Spec.Destination.Vault
+	if source.LeaseRenewPercent == 0 || source.LeaseRevokeGracePeriodSeconds == nil {
+		t.Fatalf("lease settings of a source are not defaulted: %+v", source)
+	}
+	if dest.LeaseRenewPercent != 0 || dest.LeaseRevokeGracePeriodSeconds != nil {
+		t.Fatalf("lease settings are defaulted in a destination: %+v", dest)
+	}
+}
+
+func TestKVMetadataPath(t *testing.T) {
+	tests := map[string]string{
+		"secret/data/team-x":        "secret/metadata/team-x",
+		"secret/data/team-x/nested": "secret/metadata/team-x/nested",
+		"secret/data":               "secret/metadata",
+		"kv/team-x":                 "kv/team-x",
+		"kv":                        "kv",
+		"kv/team/data/x":            "kv/team/data/x",
+	}
+	for path, expected := range tests {
+		if actual := kvMetadataPath(path); actual != expected {
+			t.Errorf("kvMetadataPath(%q) = %q, expected %q", path, actual, expected)
+		}
+	}
+}
+
+func TestSecretNameFromPath(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+		ok       bool
+	}{
+		{path: "db", expected: "db", ok: true},
+		{path: "team/db", expected: "team-db", ok: true},
+		{path: "/team/nested/db/", expected: "team-nested-db", ok: true},
+		{path: "Team_A/DB.creds", expected: "team-a-db.creds", ok: true},
+		{path: "-db-", expected: "db", ok: true},
+		{path: "", ok: false},
+		{path: "/", ok: false},
+		{path: "___", ok: false},
+		{path: "db..creds", ok: false},
+		{path: strings.Repeat("a", 254), ok: false},
+	}
+	for _, test := range tests {
+		name, ok := secretNameFromPath(test.path)
+		if ok != test.ok || (ok && name != test.expected) {
+			t.Errorf("secretNameFromPath(%q) = %q, %v, expected %q, %v", test.path, name, ok, test.expected, test.ok)
+		}
+	}
+}
+
+func vaultList(keys ...string) *vault.Secret {
+	values := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		values = append(values, key)
+	}
+	return &vault.Secret{Data: map[string]interface{}{"keys": values}}
+}
+
+func vaultKV(password string) *vault.Secret {
+	return &vault.Secret{Data: map[string]interface{}{"data": map[string]interface{}{"password": password}}}
+}
+
+func TestVaultRetrieveAll(t *testing.T) {
+	ctx := context.Background()
+	v := &fakeVault{secrets: map[string]*vault.Secret{
+		"secret/metadata/team-x/":               vaultList("db", "db-", "cache", "___", "nested/"),
+		"secret/metadata/team-x/nested/":        vaultList("db", "deeper/"),
+		"secret/metadata/team-x/nested/deeper/": vaultList("api"),
+		"secret/data/team-x/db":                 vaultKV("db"),
+		"secret/data/team-x/cache":              vaultKV("cache"),
+		"secret/data/team-x/nested/db":          vaultKV("nested-db"),
+		"secret/data/team-x/nested/deeper/api":  vaultKV("api"),
+	}}
+
+	names := func(secrets []*v1.Secret) []string {
+		var result []string
+		for _, secret := range secrets {
+			result = append(result, secret.Name)
+		}
+		return result
+	}
+
+	s, _ := testVaultSource(v, &mirrorsv1alpha2.VaultSpec{Prefix: "secret/data/team-x"})
+	secrets, err := s.RetrieveAll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := strings.Join(names(secrets), ","); actual != "cache,db" {
+		t.Fatalf("unexpected secrets %s", actual)
+	}
+
+	s, recorder := testVaultSource(v, &mirrorsv1alpha2.VaultSpec{Prefix: "/secret/data/team-x/", Recursive: true})
+	secrets, err = s.RetrieveAll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := strings.Join(names(secrets), ","); actual != "cache,db,nested-db,nested-deeper-api" {
+		t.Fatalf("unexpected secrets %s", actual)
+	}
+	if string(secrets[3].Data["password"]) != "api" || secrets[3].Annotations[vaultPathAnnotation] != "secret/data/team-x/nested/deeper/api" {
+		t.Fatalf("unexpected nested secret %+v", secrets[3])
+	}
+	// ___ is not a valid name, db- maps to the name of db
+	if events := eventReasons(recorder); len(events) != 2 ||
+		!strings.Contains(events[0], "InvalidSecretName") || !strings.Contains(events[1], "InvalidSecretName") {
+		t.Fatalf("expected two InvalidSecretName events, got %q", events)
+	}
+}
+
+func TestVaultRetrieveAllEmpty(t *testing.T) {
+	ctx := context.Background()
+	v := &fakeVault{secrets: map[string]*vault.Secret{
+		"secret/metadata/team-y/": vaultList(),
+	}}
+
+	for _, prefix := range []string{"secret/data/team-x", "secret/data/team-y"} {
+		s, _ := testVaultSource(v, &mirrorsv1alpha2.VaultSpec{Prefix: prefix})
+		_, err := s.RetrieveAll(ctx)
+		if res, ok := err.(*reconresult.ReconcileResult); !ok || res.EventReason != "VaultPrefixEmpty" {
+			t.Fatalf("%s: an empty prefix is not an error: %v", prefix, err)
+		}
+
+		s.mirror.Spec.Source.Vault.AllowEmptyPrefix = true
+		secrets, err := s.RetrieveAll(ctx)
+		if err != nil || len(secrets) != 0 {
+			t.Fatalf("%s: unexpected result of an allowed empty prefix: %v, %v", prefix, secrets, err)
+		}
+	}
+}
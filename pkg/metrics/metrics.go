@@ -29,6 +29,17 @@ var (
 		},
 	)
 
+	MirrorDriftCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mirrors_drift_detected_total",
+			Help: "Number of mirrored secrets found modified outside of a mirror",
+		},
+		[]string{
+			"mirror",
+			"namespace",
+		},
+	)
+
 	VaultLeaseRenewOkCount = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "mirrors_vault_lease_renew_ok_total",
@@ -57,6 +68,7 @@ func init() {
 	metrics.Registry.MustRegister(
 		MirrorSyncCount,
 		MirrorNSCurrentCount,
+		MirrorDriftCount,
 		VaultLeaseRenewOkCount,
 		VaultLeaseRenewErrorCount,
 	)
@@ -2,83 +2,133 @@ package nskeeper
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	"regexp"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sort"
 	"sync"
 	"time"
 )
 
+// NamespaceGVK is the kind NSKeeper and the namespace controller watch as metadata only
+var NamespaceGVK = schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}
+
 type mirrorRegex struct {
 	Name    types.NamespacedName
 	Regexps []*regexp.Regexp
+
+	// memoized set of namespaces matching any of Regexps
+	matches map[string]struct{}
+}
+
+func (m *mirrorRegex) match(ns string) bool {
+	for _, regex := range m.Regexps {
+		if regex.MatchString(ns) {
+			return true
+		}
+	}
+	return false
 }
 
+func (m *mirrorRegex) sameRegexps(regexps []*regexp.Regexp) bool {
+	if len(m.Regexps) != len(regexps) {
+		return false
+	}
+	for i := range regexps {
+		if m.Regexps[i].String() != regexps[i].String() {
+			return false
+		}
+	}
+	return true
+}
+
+// NSKeeper keeps track of cluster namespaces and of the namespaces each mirror's regexps match.
+// Match sets are computed once and updated incrementally when namespaces or mirrors come and go.
 type NSKeeper struct {
 	client.Client
-	pairs           map[string]map[string]*mirrorRegex // namespace -> name -> *mirrorRegex
-	namespaces      map[string]struct{}
-	pairsMutex      sync.RWMutex
-	namespacesMutex sync.RWMutex
-	initChan        chan struct{}
+	mirrors    map[types.NamespacedName]*mirrorRegex
+	namespaces map[string]map[types.NamespacedName]struct{} // namespace -> matching mirrors
+	mutex      sync.RWMutex
+	initChan   chan struct{}
+	initOnce   sync.Once
 }
 
 func MakeNSKeeper(cli client.Client) *NSKeeper {
 	return &NSKeeper{
-		Client:   cli,
-		initChan: make(chan struct{}, 1),
+		Client:     cli,
+		mirrors:    make(map[types.NamespacedName]*mirrorRegex),
+		namespaces: make(map[string]map[types.NamespacedName]struct{}),
+		initChan:   make(chan struct{}),
 	}
 }
 
-func (k *NSKeeper) retrieveNamespaces(ctx context.Context) (*v1.NamespaceList, error) {
-	namespaces := &v1.NamespaceList{}
+func (k *NSKeeper) retrieveNamespaces(ctx context.Context) ([]string, error) {
+	namespaces := &metav1.PartialObjectMetadataList{}
+	namespaces.SetGroupVersionKind(NamespaceGVK)
 	if err := k.List(ctx, namespaces); err != nil {
 		return nil, err
 	}
 
-	return namespaces, nil
+	result := make([]string, 0, len(namespaces.Items))
+	for _, ns := range namespaces.Items {
+		if ns.DeletionTimestamp.IsZero() {
+			result = append(result, ns.Name)
+		}
+	}
+	return result, nil
 }
 
 func (k *NSKeeper) RegisterNamespaceRegex(mirror types.NamespacedName, regexps []*regexp.Regexp) {
-	k.pairsMutex.Lock()
-	defer k.pairsMutex.Unlock()
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
 
-	if k.pairs == nil {
-		k.pairs = make(map[string]map[string]*mirrorRegex)
-	}
-	if _, ok := k.pairs[mirror.Namespace]; !ok {
-		k.pairs[mirror.Namespace] = make(map[string]*mirrorRegex)
+	if existing, ok := k.mirrors[mirror]; ok {
+		if existing.sameRegexps(regexps) {
+			return
+		}
+		k.deregister(mirror)
 	}
 
-	k.pairs[mirror.Namespace][mirror.Name] = &mirrorRegex{
+	pair := &mirrorRegex{
 		Name:    mirror,
 		Regexps: regexps,
+		matches: make(map[string]struct{}),
 	}
+	for ns, mirrors := range k.namespaces {
+		if pair.match(ns) {
+			pair.matches[ns] = struct{}{}
+			mirrors[mirror] = struct{}{}
+		}
+	}
+	k.mirrors[mirror] = pair
 }
 
 func (k *NSKeeper) DeregisterNamespaceRegex(mirror types.NamespacedName) {
-	k.pairsMutex.Lock()
-	defer k.pairsMutex.Unlock()
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.deregister(mirror)
+}
 
-	if k.pairs == nil {
+func (k *NSKeeper) deregister(mirror types.NamespacedName) {
+	pair, ok := k.mirrors[mirror]
+	if !ok {
 		return
 	}
-	if _, ok := k.pairs[mirror.Namespace]; !ok {
-		return
+	for ns := range pair.matches {
+		delete(k.namespaces[ns], mirror)
 	}
-	if _, ok := k.pairs[mirror.Namespace][mirror.Name]; !ok {
-		return
-	}
-
-	delete(k.pairs[mirror.Namespace], mirror.Name)
+	delete(k.mirrors, mirror)
 }
 
 func (k *NSKeeper) AddNamespace(ns string) (isNew bool) {
-	k.namespacesMutex.Lock()
-	defer k.namespacesMutex.Unlock()
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
 	return k.addNamespace(ns)
 }
 
@@ -86,64 +136,66 @@ func (k *NSKeeper) addNamespace(ns string) (isNew bool) {
 	if _, ok := k.namespaces[ns]; ok {
 		return false
 	}
-	if k.namespaces == nil {
-		k.namespaces = make(map[string]struct{})
+
+	mirrors := make(map[types.NamespacedName]struct{})
+	for name, pair := range k.mirrors {
+		if pair.match(ns) {
+			pair.matches[ns] = struct{}{}
+			mirrors[name] = struct{}{}
+		}
 	}
-	k.namespaces[ns] = struct{}{}
+	k.namespaces[ns] = mirrors
 	return true
 }
 
 func (k *NSKeeper) DeleteNamespace(ns string) {
-	k.namespacesMutex.Lock()
-	defer k.namespacesMutex.Unlock()
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	for mirror := range k.namespaces[ns] {
+		if pair, ok := k.mirrors[mirror]; ok {
+			delete(pair.matches, ns)
+		}
+	}
 	delete(k.namespaces, ns)
 }
 
+// FindMatchingMirrors returns mirrors with at least one regexp matching a namespace ns
 func (k *NSKeeper) FindMatchingMirrors(ns string) []types.NamespacedName {
-	k.pairsMutex.RLock()
-	defer k.pairsMutex.RUnlock()
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
 
-	if k.pairs == nil {
+	mirrors := k.namespaces[ns]
+	if len(mirrors) == 0 {
 		return nil
 	}
 
-	var result []types.NamespacedName
-	for _, mirrors := range k.pairs {
-		for _, pair := range mirrors {
-			for _, regex := range pair.Regexps {
-				if regex.MatchString(ns) {
-					result = append(result, pair.Name)
-				}
-			}
-		}
+	result := make([]types.NamespacedName, 0, len(mirrors))
+	for mirror := range mirrors {
+		result = append(result, mirror)
 	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].String() < result[j].String()
+	})
 	return result
 }
 
+// FindMatchingNamespaces returns namespaces matching at least one of the mirror's regexps
 func (k *NSKeeper) FindMatchingNamespaces(mirror types.NamespacedName) []string {
 	k.waitInit()
-	k.pairsMutex.RLock()
-	defer k.pairsMutex.RUnlock()
-	k.namespacesMutex.RLock()
-	defer k.namespacesMutex.RUnlock()
-
-	if k.pairs == nil {
-		return nil
-	}
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
 
-	pair := k.pairs[mirror.Namespace][mirror.Name]
-	if pair == nil {
+	pair := k.mirrors[mirror]
+	if pair == nil || len(pair.matches) == 0 {
 		return nil
 	}
 
-	var result []string
-	for ns := range k.namespaces {
-		for _, regex := range pair.Regexps {
-			if regex.MatchString(ns) {
-				result = append(result, ns)
-			}
-		}
+	result := make([]string, 0, len(pair.matches))
+	for ns := range pair.matches {
+		result = append(result, ns)
 	}
+	sort.Strings(result)
 	return result
 }
 
@@ -151,30 +203,42 @@ func (k *NSKeeper) waitInit() {
 	<-k.initChan
 }
 
+func (k *NSKeeper) markInitialized() {
+	k.initOnce.Do(func() {
+		close(k.initChan)
+	})
+}
+
+// InitNamespaces seeds NSKeeper from the manager's metadata-only namespace cache.
+// It blocks until the cache is started and synced.
 func (k *NSKeeper) InitNamespaces(ctx context.Context) {
 	logger := log.FromContext(ctx)
-	k.namespacesMutex.Lock()
-	defer k.namespacesMutex.Unlock()
 
-loop:
 	for {
-		select {
-		case <-ctx.Done():
-			return
-		default:
-			namespaces, err := k.retrieveNamespaces(ctx)
-			if err != nil {
+		namespaces, err := k.retrieveNamespaces(ctx)
+		if err != nil {
+			var notStarted *cache.ErrCacheNotStarted
+			if !errors.As(err, &notStarted) {
 				logger.Info(fmt.Sprintf("nskeeper: error initializing namespaces: %s", err))
-				time.Sleep(3 * time.Second)
-				goto loop
 			}
 
-			for _, ns := range namespaces.Items {
-				k.addNamespace(ns.Name)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(3 * time.Second):
 			}
-			logger.Info(fmt.Sprintf("nskeeper: initialized with %d namespaces", len(k.namespaces)))
-			close(k.initChan)
-			return
+			continue
+		}
+
+		k.mutex.Lock()
+		for _, ns := range namespaces {
+			k.addNamespace(ns)
 		}
+		count := len(k.namespaces)
+		k.mutex.Unlock()
+
+		logger.Info(fmt.Sprintf("nskeeper: initialized with %d namespaces", count))
+		k.markInitialized()
+		return
 	}
 }
@@ -0,0 +1,118 @@
+package nskeeper
+
+import (
+	"fmt"
+	"k8s.io/apimachinery/pkg/types"
+	"regexp"
+	"testing"
+)
+
+const (
+	benchNamespaces = 5000
+	benchMirrors    = 1000
+)
+
+func makeInitializedKeeper(namespaces int) *NSKeeper {
+	k := MakeNSKeeper(nil)
+	for i := 0; i < namespaces; i++ {
+		k.AddNamespace(fmt.Sprintf("team-%d-ns-%d", i%100, i))
+	}
+	k.markInitialized()
+	return k
+}
+
+func benchMirror(i int) (types.NamespacedName, []*regexp.Regexp) {
+	return types.NamespacedName{Namespace: "default", Name: fmt.Sprintf("mirror-%d", i)},
+		[]*regexp.Regexp{
+			regexp.MustCompile(fmt.Sprintf(`^team-%d-ns-\d+$`, i%100)),
+			regexp.MustCompile(fmt.Sprintf(`^team-%d-.*$`, i%100)),
+		}
+}
+
+func registerBenchMirrors(k *NSKeeper) {
+	for i := 0; i < benchMirrors; i++ {
+		k.RegisterNamespaceRegex(benchMirror(i))
+	}
+}
+
+func TestFindMatchingNamespacesNoDuplicates(t *testing.T) {
+	k := makeInitializedKeeper(10)
+	mirror := types.NamespacedName{Namespace: "default", Name: "mirror"}
+	k.RegisterNamespaceRegex(mirror, []*regexp.Regexp{
+		regexp.MustCompile(`^team-1-.*$`),
+		regexp.MustCompile(`^team-1-ns-1$`),
+	})
+
+	namespaces := k.FindMatchingNamespaces(mirror)
+	if len(namespaces) != 1 || namespaces[0] != "team-1-ns-1" {
+		t.Fatalf("unexpected namespaces: %v", namespaces)
+	}
+	if mirrors := k.FindMatchingMirrors("team-1-ns-1"); len(mirrors) != 1 {
+		t.Fatalf("unexpected mirrors: %v", mirrors)
+	}
+
+	k.AddNamespace("team-1-new")
+	if namespaces := k.FindMatchingNamespaces(mirror); len(namespaces) != 2 {
+		t.Fatalf("new namespace is not matched: %v", namespaces)
+	}
+
+	k.DeleteNamespace("team-1-ns-1")
+	if namespaces := k.FindMatchingNamespaces(mirror); len(namespaces) != 1 || namespaces[0] != "team-1-new" {
+		t.Fatalf("deleted namespace is still matched: %v", namespaces)
+	}
+
+	k.DeregisterNamespaceRegex(mirror)
+	if mirrors := k.FindMatchingMirrors("team-1-new"); len(mirrors) != 0 {
+		t.Fatalf("deregistered mirror is still matched: %v", mirrors)
+	}
+}
+
+func BenchmarkRegisterNamespaceRegex(b *testing.B) {
+	k := makeInitializedKeeper(benchNamespaces)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		name, regexps := benchMirror(i % benchMirrors)
+		k.DeregisterNamespaceRegex(name)
+		k.RegisterNamespaceRegex(name, regexps)
+	}
+}
+
+func BenchmarkReregisterNamespaceRegexUnchanged(b *testing.B) {
+	k := makeInitializedKeeper(benchNamespaces)
+	registerBenchMirrors(k)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		k.RegisterNamespaceRegex(benchMirror(i % benchMirrors))
+	}
+}
+
+func BenchmarkFindMatchingNamespaces(b *testing.B) {
+	k := makeInitializedKeeper(benchNamespaces)
+	registerBenchMirrors(k)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		name, _ := benchMirror(i % benchMirrors)
+		k.FindMatchingNamespaces(name)
+	}
+}
+
+func BenchmarkFindMatchingMirrors(b *testing.B) {
+	k := makeInitializedKeeper(benchNamespaces)
+	registerBenchMirrors(k)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ns := i % benchNamespaces
+		k.FindMatchingMirrors(fmt.Sprintf("team-%d-ns-%d", ns%100, ns))
+	}
+}
+
+func BenchmarkAddDeleteNamespace(b *testing.B) {
+	k := makeInitializedKeeper(benchNamespaces)
+	registerBenchMirrors(k)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ns := fmt.Sprintf("team-%d-new", i%100)
+		k.AddNamespace(ns)
+		k.DeleteNamespace(ns)
+	}
+}
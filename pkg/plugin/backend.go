@@ -0,0 +1,257 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	mirrorsv1alpha2 "github.com/ktsstudio/mirrors/api/v1alpha2"
+	"github.com/ktsstudio/mirrors/pkg/backend"
+	"github.com/ktsstudio/mirrors/pkg/reconresult"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"sort"
+	"time"
+)
+
+// TypePrefix prefixes plugin names in source.type and destination.type
+const TypePrefix = "plugin/"
+
+// Register adds plugin/<name> source and destination types for every discovered plugin
+func (m *Manager) Register() {
+	for _, name := range m.Names() {
+		i := m.plugins[name]
+		backend.RegisterSource(backend.SourceRegistration{
+			Type: mirrorsv1alpha2.SourceType(TypePrefix + name),
+			Factory: func(ctx context.Context, b *backend.SecretMirrorBackend, mirror *mirrorsv1alpha2.SecretMirror) (backend.SourceRetriever, error) {
+				return &pluginSource{
+					EventRecorder: b.Recorder,
+					plugin:        i,
+					mirror:        mirror,
+				}, nil
+			},
+		})
+		backend.RegisterDest(backend.DestRegistration{
+			Type: mirrorsv1alpha2.DestType(TypePrefix + name),
+			Factory: func(ctx context.Context, b *backend.SecretMirrorBackend, mirror *mirrorsv1alpha2.SecretMirror) (backend.DestSyncer, error) {
+				return &pluginDest{
+					EventRecorder: b.Recorder,
+					plugin:        i,
+					mirror:        mirror,
+					writeLimiter:  b.WriteLimiter(),
+				}, nil
+			},
+		})
+	}
+}
+
+// call runs a method of a plugin with invoke, limited by the call timeout. Unavailable plugins, plugins which
+// do not answer in time and plugin errors are reported as ReconcileResult, codes.Unimplemented is returned as is
+func (i *instance) call(ctx context.Context, recorder record.EventRecorder, mirror *mirrorsv1alpha2.SecretMirror,
+	method string, invoke func(ctx context.Context, client BackendClient) error) error {
+
+	client, restarted, err := i.connect(ctx)
+	if restarted {
+		recorder.Eventf(mirror, v1.EventTypeWarning, "PluginRestarted", "Plugin %s was not healthy and has been restarted", i.name)
+	}
+	if err != nil {
+		return unavailable(i.name, err)
+	}
+
+	callCtx, cancel := context.WithTimeout(ctx, i.callTimeout)
+	defer cancel()
+	if err := invoke(callCtx, client); err != nil {
+		switch status.Code(err) {
+		case codes.Unimplemented:
+			return err
+		case codes.Unavailable:
+			// the plugin has crashed - it is restarted on the next call
+			return unavailable(i.name, err)
+		case codes.DeadlineExceeded:
+			return &reconresult.ReconcileResult{
+				Message:     fmt.Sprintf("plugin %s: %s did not answer within %s", i.name, method, i.callTimeout),
+				Status:      mirrorsv1alpha2.MirrorStatusError,
+				EventType:   v1.EventTypeWarning,
+				EventReason: "PluginTimeout",
+			}
+		}
+		return &reconresult.ReconcileResult{
+			Message:     fmt.Sprintf("plugin %s: %s failed: %s", i.name, method, status.Convert(err).Message()),
+			Status:      mirrorsv1alpha2.MirrorStatusError,
+			EventType:   v1.EventTypeWarning,
+			EventReason: "PluginError",
+		}
+	}
+	return nil
+}
+
+func unavailable(name string, err error) error {
+	return &reconresult.ReconcileResult{
+		Message:     fmt.Sprintf("plugin %s is unavailable: %s", name, err),
+		Status:      mirrorsv1alpha2.MirrorStatusError,
+		EventType:   v1.EventTypeWarning,
+		EventReason: "PluginUnavailable",
+	}
+}
+
+func ignoreUnimplemented(err error) error {
+	if status.Code(err) == codes.Unimplemented {
+		return nil
+	}
+	return err
+}
+
+func makeCall(role Role, mirror *mirrorsv1alpha2.SecretMirror, config *runtime.RawExtension) *Call {
+	call := &Call{
+		Role: role,
+		Mirror: &MirrorRef{
+			Namespace:    mirror.Namespace,
+			Name:         mirror.Name,
+			DeletePolicy: string(mirror.Spec.DeletePolicy),
+		},
+	}
+	if config != nil {
+		call.Config = config.Raw
+	}
+	return call
+}
+
+type pluginSource struct {
+	record.EventRecorder
+	plugin *instance
+	mirror *mirrorsv1alpha2.SecretMirror
+}
+
+func (s *pluginSource) call() *Call {
+	return makeCall(Role_ROLE_SOURCE, s.mirror, s.mirror.Spec.Source.Config)
+}
+
+func (s *pluginSource) Setup(ctx context.Context) error {
+	err := s.plugin.call(ctx, s, s.mirror, methodSetup, func(ctx context.Context, client BackendClient) error {
+		_, err := client.Setup(ctx, &SetupRequest{Call: s.call()})
+		return err
+	})
+	return ignoreUnimplemented(err)
+}
+
+func (s *pluginSource) Retrieve(ctx context.Context) (*v1.Secret, error) {
+	var resp *RetrieveResponse
+	err := s.plugin.call(ctx, s, s.mirror, methodRetrieve, func(ctx context.Context, client BackendClient) (err error) {
+		resp, err = client.Retrieve(ctx, &RetrieveRequest{Call: s.call()})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.GetSecret().GetData()) == 0 {
+		return nil, &reconresult.ReconcileResult{
+			Message:      fmt.Sprintf("no data need to be synced, plugin: %s", s.plugin.name),
+			RequeueAfter: time.Until(s.mirror.NextSyncAt(time.Now())),
+			Status:       mirrorsv1alpha2.MirrorStatusActive,
+		}
+	}
+
+	var sourceSecret v1.Secret
+	sourceSecret.Namespace = fmt.Sprintf("<%s%s>", TypePrefix, s.plugin.name)
+	sourceSecret.Name = s.mirror.Spec.Source.Name
+	sourceSecret.Type = v1.SecretType(resp.Secret.Type)
+	sourceSecret.Data = resp.Secret.Data
+	return &sourceSecret, nil
+}
+
+func (s *pluginSource) AfterSync(ctx context.Context, synced bool) error {
+	_, _ = ctx, synced
+	return nil
+}
+
+func (s *pluginSource) Cleanup(ctx context.Context) error {
+	err := s.plugin.call(ctx, s, s.mirror, methodCleanup, func(ctx context.Context, client BackendClient) error {
+		_, err := client.Cleanup(ctx, &CleanupRequest{Call: s.call()})
+		return err
+	})
+	return ignoreUnimplemented(err)
+}
+
+type pluginDest struct {
+	record.EventRecorder
+	plugin       *instance
+	mirror       *mirrorsv1alpha2.SecretMirror
+	writeLimiter *rate.Limiter
+}
+
+func (d *pluginDest) call() *Call {
+	return makeCall(Role_ROLE_DESTINATION, d.mirror, d.mirror.Spec.Destination.Config)
+}
+
+func (d *pluginDest) secret(secret *v1.Secret) *Secret {
+	name := d.mirror.Spec.Source.Name
+	if d.mirror.IsMultiSource() {
+		name = secret.Name
+	}
+	return &Secret{
+		Name: name,
+		Type: string(secret.Type),
+		Data: secret.Data,
+	}
+}
+
+func (d *pluginDest) Setup(ctx context.Context) error {
+	err := d.plugin.call(ctx, d, d.mirror, methodSetup, func(ctx context.Context, client BackendClient) error {
+		_, err := client.Setup(ctx, &SetupRequest{Call: d.call()})
+		return err
+	})
+	return ignoreUnimplemented(err)
+}
+
+func (d *pluginDest) Sync(ctx context.Context, secret *v1.Secret) error {
+	if err := d.writeLimiter.Wait(ctx); err != nil {
+		return err
+	}
+	return d.plugin.call(ctx, d, d.mirror, methodSync, func(ctx context.Context, client BackendClient) error {
+		_, err := client.Sync(ctx, &SyncRequest{Call: d.call(), Secret: d.secret(secret)})
+		return err
+	})
+}
+
+func (d *pluginDest) Plan(ctx context.Context, secret *v1.Secret) ([]mirrorsv1alpha2.DestinationPlan, error) {
+	var resp *PlanResponse
+	err := d.plugin.call(ctx, d, d.mirror, methodPlan, func(ctx context.Context, client BackendClient) (err error) {
+		resp, err = client.Plan(ctx, &PlanRequest{Call: d.call(), Secret: d.secret(secret)})
+		return err
+	})
+	if status.Code(err) == codes.Unimplemented {
+		// the plugin cannot tell what would change
+		keys := make([]string, 0, len(secret.Data))
+		for k := range secret.Data {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		return []mirrorsv1alpha2.DestinationPlan{{
+			Destination: TypePrefix + d.plugin.name,
+			Action:      mirrorsv1alpha2.PlanActionUpdate,
+			ChangedKeys: keys,
+		}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return planFromProto(resp.GetPlan()), nil
+}
+
+func (d *pluginDest) Prune(ctx context.Context, names []string) error {
+	err := d.plugin.call(ctx, d, d.mirror, methodPrune, func(ctx context.Context, client BackendClient) error {
+		_, err := client.Prune(ctx, &PruneRequest{Call: d.call(), Names: names})
+		return err
+	})
+	return ignoreUnimplemented(err)
+}
+
+func (d *pluginDest) Cleanup(ctx context.Context) error {
+	err := d.plugin.call(ctx, d, d.mirror, methodCleanup, func(ctx context.Context, client BackendClient) error {
+		_, err := client.Cleanup(ctx, &CleanupRequest{Call: d.call()})
+		return err
+	})
+	return ignoreUnimplemented(err)
+}
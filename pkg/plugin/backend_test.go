@@ -0,0 +1,44 @@
+package plugin
+
+import (
+	"context"
+	mirrorsv1alpha2 "github.com/ktsstudio/mirrors/api/v1alpha2"
+	"github.com/ktsstudio/mirrors/pkg/reconresult"
+	"k8s.io/client-go/tools/record"
+	"testing"
+	"time"
+)
+
+// silentBackend never answers a retrieve until the caller gives up
+type silentBackend struct {
+	UnimplementedBackendServer
+}
+
+func (silentBackend) Retrieve(ctx context.Context, req *RetrieveRequest) (*RetrieveResponse, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestPluginCallTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m, _ := serveSocketPlugin(ctx, t, "silent", silentBackend{}, 100*time.Millisecond)
+	var mirror mirrorsv1alpha2.SecretMirror
+	mirror.Namespace, mirror.Name = "default", "db"
+	source := &pluginSource{
+		EventRecorder: record.NewFakeRecorder(10),
+		plugin:        m.plugins["silent"],
+		mirror:        &mirror,
+	}
+
+	started := time.Now()
+	_, err := source.Retrieve(ctx)
+	result, ok := err.(*reconresult.ReconcileResult)
+	if !ok || result.Status != mirrorsv1alpha2.MirrorStatusError || result.EventReason != "PluginTimeout" {
+		t.Fatalf("expected a timeout result, got %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Fatalf("call has not been timed out, took %s", elapsed)
+	}
+}
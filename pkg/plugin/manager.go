@@ -0,0 +1,210 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/go-hclog"
+	goplugin "github.com/hashicorp/go-plugin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// how long a health check of a plugin may take
+const healthCheckTimeout = 5 * time.Second
+
+// DefaultCallTimeout limits a single call to a plugin unless configured otherwise
+const DefaultCallTimeout = 30 * time.Second
+
+// instance is a plugin binary launched by the controller or a sidecar listening on a socket
+type instance struct {
+	name   string
+	path   string // plugin binary, empty for a sidecar
+	socket string
+	// limits a single call, a plugin which does not answer in time fails the call
+	callTimeout time.Duration
+
+	mutex     sync.Mutex
+	client    *goplugin.Client
+	rpcClient goplugin.ClientProtocol
+	conn      *grpc.ClientConn
+	backend   BackendClient
+}
+
+// Manager keeps plugins available to mirrors. Binaries are launched on first use, crashed or unhealthy
+// ones are restarted on the next call
+type Manager struct {
+	plugins map[string]*instance
+}
+
+// Discover finds plugin binaries (executable files) in dir and sidecar plugins listening on sockets (name -> path).
+// Calls to the plugins take no longer than callTimeout, DefaultCallTimeout if it is not positive
+func Discover(dir string, sockets map[string]string, callTimeout time.Duration) (*Manager, error) {
+	m := &Manager{plugins: make(map[string]*instance)}
+	if callTimeout <= 0 {
+		callTimeout = DefaultCallTimeout
+	}
+
+	if dir != "" {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err != nil {
+				return nil, err
+			}
+			if !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 {
+				continue
+			}
+			m.plugins[entry.Name()] = &instance{
+				name:        entry.Name(),
+				path:        filepath.Join(dir, entry.Name()),
+				callTimeout: callTimeout,
+			}
+		}
+	}
+
+	for name, socket := range sockets {
+		if _, ok := m.plugins[name]; ok {
+			return nil, fmt.Errorf("plugin %s is both a binary and a socket", name)
+		}
+		m.plugins[name] = &instance{
+			name:        name,
+			socket:      socket,
+			callTimeout: callTimeout,
+		}
+	}
+	return m, nil
+}
+
+// Names returns names of the discovered plugins
+func (m *Manager) Names() []string {
+	names := make([]string, 0, len(m.plugins))
+	for name := range m.plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Kill stops plugin binaries and closes connections to sidecars
+func (m *Manager) Kill() {
+	for _, i := range m.plugins {
+		i.mutex.Lock()
+		i.stop()
+		i.mutex.Unlock()
+	}
+}
+
+// connect returns a client of a running and healthy plugin, starting it if needed.
+// restarted reports whether a crashed or unhealthy plugin binary has been restarted
+func (i *instance) connect(ctx context.Context) (backend BackendClient, restarted bool, err error) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	if i.backend != nil {
+		err := i.check(ctx)
+		if err == nil {
+			return i.backend, false, nil
+		}
+		if i.path == "" {
+			// a sidecar is restarted by kubelet, gRPC reconnects by itself
+			return nil, false, err
+		}
+		i.stop()
+		restarted = true
+	}
+
+	if err := i.start(); err != nil {
+		return nil, restarted, err
+	}
+	if err := i.check(ctx); err != nil {
+		return nil, restarted, err
+	}
+	return i.backend, restarted, nil
+}
+
+func (i *instance) start() error {
+	if i.path == "" {
+		conn, err := grpc.Dial("unix://"+i.socket, grpc.WithInsecure())
+		if err != nil {
+			return err
+		}
+		i.conn = conn
+		i.backend = NewBackendClient(conn)
+		return nil
+	}
+
+	client := goplugin.NewClient(&goplugin.ClientConfig{
+		HandshakeConfig: Handshake,
+		Plugins: map[string]goplugin.Plugin{
+			pluginName: &grpcPlugin{},
+		},
+		Cmd:              exec.Command(i.path),
+		AllowedProtocols: []goplugin.Protocol{goplugin.ProtocolGRPC},
+		Managed:          true,
+		Logger: hclog.New(&hclog.LoggerOptions{
+			Name:   "plugin." + i.name,
+			Level:  hclog.Info,
+			Output: os.Stderr,
+		}),
+	})
+	rpcClient, err := client.Client()
+	if err != nil {
+		client.Kill()
+		return err
+	}
+	raw, err := rpcClient.Dispense(pluginName)
+	if err != nil {
+		client.Kill()
+		return err
+	}
+
+	i.client = client
+	i.rpcClient = rpcClient
+	i.backend = raw.(BackendClient)
+	return nil
+}
+
+func (i *instance) check(ctx context.Context) error {
+	if i.client != nil {
+		if i.client.Exited() {
+			return errors.New("plugin has exited")
+		}
+		return i.rpcClient.Ping()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	resp, err := grpc_health_v1.NewHealthClient(i.conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{
+		Service: healthServiceName,
+	})
+	if err != nil {
+		return err
+	}
+	if resp.Status != grpc_health_v1.HealthCheckResponse_SERVING {
+		return fmt.Errorf("plugin is %s", resp.Status)
+	}
+	return nil
+}
+
+func (i *instance) stop() {
+	if i.client != nil {
+		i.client.Kill()
+	}
+	if i.conn != nil {
+		_ = i.conn.Close()
+	}
+	i.client = nil
+	i.rpcClient = nil
+	i.conn = nil
+	i.backend = nil
+}
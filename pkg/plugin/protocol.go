@@ -0,0 +1,35 @@
+package plugin
+
+import (
+	mirrorsv1alpha2 "github.com/ktsstudio/mirrors/api/v1alpha2"
+)
+
+// Messages and the gRPC service are generated from backend.proto by `make proto`
+
+// Backend is implemented by plugins. Sources implement Retrieve, destinations implement Sync, Plan and Prune.
+// Embed UnimplementedBackendServer to leave out the rest: the controller treats unimplemented Setup, Prune
+// and Cleanup as no-ops and unimplemented Plan as an update of every key
+type Backend = BackendServer
+
+// names of Backend methods reported in errors
+const (
+	methodSetup    = "Setup"
+	methodRetrieve = "Retrieve"
+	methodSync     = "Sync"
+	methodPlan     = "Plan"
+	methodPrune    = "Prune"
+	methodCleanup  = "Cleanup"
+)
+
+// planFromProto converts planned changes returned by a plugin
+func planFromProto(plan []*DestinationPlan) []mirrorsv1alpha2.DestinationPlan {
+	result := make([]mirrorsv1alpha2.DestinationPlan, 0, len(plan))
+	for _, p := range plan {
+		result = append(result, mirrorsv1alpha2.DestinationPlan{
+			Destination: p.GetDestination(),
+			Action:      mirrorsv1alpha2.PlanAction(p.GetAction()),
+			ChangedKeys: p.GetChangedKeys(),
+		})
+	}
+	return result
+}
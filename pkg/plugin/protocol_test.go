@@ -0,0 +1,111 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	mirrorsv1alpha2 "github.com/ktsstudio/mirrors/api/v1alpha2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type echoBackend struct {
+	UnimplementedBackendServer
+}
+
+func (echoBackend) Retrieve(ctx context.Context, req *RetrieveRequest) (*RetrieveResponse, error) {
+	return &RetrieveResponse{Secret: &Secret{
+		Name: req.Call.Mirror.Name,
+		Data: map[string][]byte{"config": req.Call.Config, "binary": {0, 1, 2}},
+	}}, nil
+}
+
+func (echoBackend) Sync(ctx context.Context, req *SyncRequest) (*SyncResponse, error) {
+	return nil, errors.New("read-only store")
+}
+
+func (echoBackend) Prune(ctx context.Context, req *PruneRequest) (*PruneResponse, error) {
+	if len(req.Names) != 2 || req.Names[1] != "b" || req.Call.Role != Role_ROLE_DESTINATION {
+		return nil, status.Errorf(codes.InvalidArgument, "unexpected request %v", req)
+	}
+	return &PruneResponse{}, nil
+}
+
+// serveSocketPlugin serves a sidecar plugin on a socket and waits until it is healthy
+func serveSocketPlugin(ctx context.Context, t *testing.T, name string, server BackendServer, callTimeout time.Duration) (*Manager, BackendClient) {
+	socket := filepath.Join(t.TempDir(), name+".sock")
+	go func() {
+		_ = ServeSocket(ctx, socket, server)
+	}()
+
+	m, err := Discover("", map[string]string{name: socket}, callTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(m.Kill)
+
+	var backend BackendClient
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		backend, _, err = m.plugins[name].connect(ctx)
+		if err == nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, backend
+}
+
+func TestSocketPluginRoundTrip(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, backend := serveSocketPlugin(ctx, t, "echo", echoBackend{}, 0)
+
+	call := &Call{
+		Role:   Role_ROLE_SOURCE,
+		Mirror: &MirrorRef{Namespace: "default", Name: "mirror"},
+		Config: []byte(`{"key":"value"}`),
+	}
+	resp, err := backend.Retrieve(ctx, &RetrieveRequest{Call: call})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Secret == nil || resp.Secret.Name != "mirror" {
+		t.Fatalf("unexpected secret: %+v", resp.Secret)
+	}
+	if got := string(resp.Secret.Data["config"]); got != `{"key":"value"}` {
+		t.Fatalf("config is not passed as is: %s", got)
+	}
+	if got := resp.Secret.Data["binary"]; len(got) != 3 || got[2] != 2 {
+		t.Fatalf("binary value is corrupted: %v", got)
+	}
+
+	secret := &Secret{Name: "mirror", Data: map[string][]byte{"password": []byte("secret")}}
+	if _, err := backend.Sync(ctx, &SyncRequest{Call: call, Secret: secret}); status.Convert(err).Message() != "read-only store" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := backend.Plan(ctx, &PlanRequest{Call: call, Secret: secret}); status.Code(err) != codes.Unimplemented {
+		t.Fatalf("expected unimplemented, got %v", err)
+	}
+
+	call.Role = Role_ROLE_DESTINATION
+	if _, err := backend.Prune(ctx, &PruneRequest{Call: call, Names: []string{"a", "b"}}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPlanFromProto(t *testing.T) {
+	plan := planFromProto([]*DestinationPlan{
+		{Destination: "a", Action: "create", ChangedKeys: []string{"password"}},
+		{Destination: "b", Action: "noop"},
+	})
+	if len(plan) != 2 || plan[0].Destination != "a" || plan[0].Action != mirrorsv1alpha2.PlanActionCreate ||
+		len(plan[0].ChangedKeys) != 1 || plan[1].Action != mirrorsv1alpha2.PlanActionNoop {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+}
@@ -0,0 +1,75 @@
+package plugin
+
+import (
+	"context"
+	goplugin "github.com/hashicorp/go-plugin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"net"
+	"os"
+)
+
+// Handshake is shared by the controller and plugin binaries, so that a binary is not run as a plugin by mistake
+var Handshake = goplugin.HandshakeConfig{
+	ProtocolVersion:  1,
+	MagicCookieKey:   "MIRRORS_PLUGIN",
+	MagicCookieValue: "secretmirror-backend",
+}
+
+// name a Backend is dispensed under
+const pluginName = "backend"
+
+// health service name checked by the controller, the one go-plugin serves
+const healthServiceName = "plugin"
+
+type grpcPlugin struct {
+	goplugin.NetRPCUnsupportedPlugin
+	impl Backend
+}
+
+func (p *grpcPlugin) GRPCServer(broker *goplugin.GRPCBroker, s *grpc.Server) error {
+	_ = broker
+	RegisterBackendServer(s, p.impl)
+	return nil
+}
+
+func (p *grpcPlugin) GRPCClient(ctx context.Context, broker *goplugin.GRPCBroker, conn *grpc.ClientConn) (interface{}, error) {
+	_, _ = ctx, broker
+	return NewBackendClient(conn), nil
+}
+
+// Serve runs impl as a plugin binary launched by the controller. It is called from main of a plugin and never returns
+func Serve(impl Backend) {
+	goplugin.Serve(&goplugin.ServeConfig{
+		HandshakeConfig: Handshake,
+		Plugins: map[string]goplugin.Plugin{
+			pluginName: &grpcPlugin{impl: impl},
+		},
+		GRPCServer: goplugin.DefaultGRPCServer,
+	})
+}
+
+// ServeSocket serves impl on a unix socket at path until ctx is done. It is used by plugins running as sidecars
+// which share a socket directory with the controller
+func ServeSocket(ctx context.Context, path string, impl Backend) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+
+	server := grpc.NewServer()
+	RegisterBackendServer(server, impl)
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus(healthServiceName, grpc_health_v1.HealthCheckResponse_SERVING)
+	grpc_health_v1.RegisterHealthServer(server, healthServer)
+
+	go func() {
+		<-ctx.Done()
+		server.GracefulStop()
+	}()
+	return server.Serve(listener)
+}
@@ -3,10 +3,18 @@ package reconresult
 import (
 	"fmt"
 	"github.com/ktsstudio/mirrors/api/v1alpha2"
+	"math"
+	"math/rand"
 	"time"
 )
 
-const DefaultRequeueAfter = 15 * time.Second
+const (
+	DefaultRequeueAfter    = 15 * time.Second
+	DefaultMaxRetryBackoff = 10 * time.Minute
+
+	// JitterFactor is the maximum share of a requeue delay added as a random jitter
+	JitterFactor = 0.1
+)
 
 type ReconcileResult struct {
 	Message      string
@@ -26,3 +34,34 @@ func Fmt(format string, args ...interface{}) *ReconcileResult {
 		RequeueAfter: DefaultRequeueAfter,
 	}
 }
+
+// Backoff doubles base for every consecutive failure after the first one and adds jitter. The result never exceeds max
+func Backoff(base time.Duration, failures int32, max time.Duration) time.Duration {
+	d := base
+	for i := int32(1); i < failures && d < max; i++ {
+		if d > max/2 {
+			// doubling would overflow or pass the cap anyway
+			d = max
+			break
+		}
+		d *= 2
+	}
+	d = Jitter(d, JitterFactor)
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+// Jitter adds a random delay of up to factor*d to d
+func Jitter(d time.Duration, factor float64) time.Duration {
+	maxJitter := int64(float64(d) * factor)
+	if maxJitter <= 0 {
+		return d
+	}
+	jitter := time.Duration(rand.Int63n(maxJitter))
+	if d > math.MaxInt64-jitter {
+		return math.MaxInt64
+	}
+	return d + jitter
+}
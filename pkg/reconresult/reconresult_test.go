@@ -0,0 +1,47 @@
+package reconresult
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	const max = 10 * time.Minute
+	tests := []struct {
+		failures int32
+		base     time.Duration
+		max      time.Duration
+		expected time.Duration
+	}{
+		{failures: 1, base: 15 * time.Second, max: max, expected: 15 * time.Second},
+		{failures: 2, base: 15 * time.Second, max: max, expected: 30 * time.Second},
+		{failures: 4, base: 15 * time.Second, max: max, expected: 2 * time.Minute},
+		{failures: 6, base: 15 * time.Second, max: max, expected: 8 * time.Minute},
+		{failures: 7, base: 15 * time.Second, max: max, expected: max},
+		{failures: 10000, base: 15 * time.Second, max: max, expected: max},
+		{failures: math.MaxInt32, base: time.Second, max: math.MaxInt64, expected: math.MaxInt64},
+		{failures: 100, base: time.Hour, max: max, expected: max},
+	}
+
+	for _, test := range tests {
+		for i := 0; i < 100; i++ {
+			d := Backoff(test.base, test.failures, test.max)
+			if d < test.expected || d > test.max || float64(d) > float64(test.expected)*(1+JitterFactor) {
+				t.Fatalf("Backoff(%s, %d, %s) = %s, expected %s plus up to %.0f%% of jitter",
+					test.base, test.failures, test.max, d, test.expected, JitterFactor*100)
+			}
+		}
+	}
+}
+
+func TestJitter(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		if d := Jitter(time.Minute, 0.1); d < time.Minute || d >= time.Minute+6*time.Second {
+			t.Fatalf("unexpected jitter %s", d)
+		}
+	}
+	if d := Jitter(0, 0.1); d != 0 {
+		t.Fatalf("unexpected jitter of zero %s", d)
+	}
+}
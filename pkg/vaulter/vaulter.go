@@ -57,6 +57,10 @@ func (v *Vaulter) ReadSecret(path string) (*vault.Secret, error) {
 	return v.logical.Read(path)
 }
 
+func (v *Vaulter) List(path string) (*vault.Secret, error) {
+	return v.logical.List(path)
+}
+
 func (v *Vaulter) RetrieveData(path string) (map[string]interface{}, error) {
 	secret, err := v.logical.Read(path)
 	if err != nil {
@@ -79,6 +83,10 @@ func (v *Vaulter) RetrieveData(path string) (map[string]interface{}, error) {
 	return data, nil
 }
 
+func (v *Vaulter) Write(path string, data map[string]interface{}) (*vault.Secret, error) {
+	return v.logical.Write(path, data)
+}
+
 func (v *Vaulter) WriteData(path string, data map[string]interface{}) error {
 	_, err := v.logical.Write(path, data)
 	if err != nil {
@@ -90,3 +98,7 @@ func (v *Vaulter) WriteData(path string, data map[string]interface{}) error {
 func (v *Vaulter) RenewLease(leaseId string, increment int) (*vault.Secret, error) {
 	return v.sys.Renew(leaseId, increment)
 }
+
+func (v *Vaulter) RevokeLease(leaseId string) error {
+	return v.sys.Revoke(leaseId)
+}